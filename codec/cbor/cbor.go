@@ -14,32 +14,28 @@ import (
 	ugorjiCodec "github.com/ugorji/go/codec"
 )
 
-// New creates a msgpack codec
-// tipe is required because our Decode() interface doesn't take an argument
+// New creates a cbor codec
+// tipe is required because our Decode() interface doesn't take an argument.
+// If tipe is a pointer, decoded values are pointers, too. If tipe is nil, values are decoded into an interface{},
+// with maps as map[string]interface{} like the json codec does.
+// Fields of type interface{} in typed values still decode maps as map[interface{}]interface{}.
 func New(tipe interface{}) margaret.Codec {
 	ch := ugorjiCodec.CborHandle{}
 	// ch.Canonical = true
 	ch.StructToArray = true
-
-	c := &codec{
-		handle: &ch,
-	}
 	if tipe == nil {
-		c.any = true
-		return c
+		ch.MapType = reflect.TypeOf(map[string]interface{}(nil))
 	}
-	t := reflect.TypeOf(tipe)
-	isPtr := t.Kind() == reflect.Ptr
-	if isPtr {
-		t = t.Elem()
+
+	return &codec{
+		tipe:   reflect.TypeOf(tipe),
+		handle: &ch,
 	}
-	c.tipe = t
-	return c
 }
 
 type codec struct {
+	// nil for untyped decoding
 	tipe   reflect.Type
-	any    bool
 	handle *ugorjiCodec.CborHandle
 }
 
@@ -70,7 +66,16 @@ type decoder struct {
 }
 
 func (dec *decoder) Decode() (interface{}, error) {
-	v := reflect.New(dec.tipe).Interface()
-	err := dec.dec.Decode(&v)
-	return reflect.ValueOf(v).Elem().Interface(), err
+	var ptr reflect.Value
+	if dec.tipe == nil {
+		var v interface{}
+		ptr = reflect.ValueOf(&v)
+	} else {
+		ptr = reflect.New(dec.tipe)
+	}
+
+	if err := dec.dec.Decode(ptr.Interface()); err != nil {
+		return nil, err
+	}
+	return ptr.Elem().Interface(), nil
 }
@@ -0,0 +1,160 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package compress_test
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/codec/compress"
+	"github.com/ssbc/margaret/codec/json"
+	"github.com/ssbc/margaret/mem"
+	"github.com/ssbc/margaret/offset2"
+)
+
+type content struct {
+	Type     string   `json:"type"`
+	Text     string   `json:"text"`
+	Mentions []string `json:"mentions,omitempty"`
+}
+
+type entry struct {
+	Author    string  `json:"author"`
+	Sequence  int64   `json:"sequence"`
+	Timestamp int64   `json:"timestamp"`
+	Content   content `json:"content"`
+}
+
+// makeEntries returns entries that look like the messages of a social log.
+func makeEntries(n int) []interface{} {
+	rand := rand.New(rand.NewSource(42))
+	words := strings.Fields("hello world the of and a to in is it you that he was for on are with as I his they be at one have this from or had by")
+	authors := make([]string, 16)
+	for i := range authors {
+		authors[i] = fmt.Sprintf("@%044x.ed25519", rand.Uint64())
+	}
+
+	entries := make([]interface{}, n)
+	for i := range entries {
+		text := make([]string, 5+rand.Intn(60))
+		for j := range text {
+			text[j] = words[rand.Intn(len(words))]
+		}
+		e := entry{
+			Author:    authors[rand.Intn(len(authors))],
+			Sequence:  int64(i),
+			Timestamp: 1600000000000 + int64(i)*1000,
+			Content:   content{Type: "post", Text: strings.Join(text, " ")},
+		}
+		if rand.Intn(4) == 0 {
+			e.Content.Mentions = []string{authors[rand.Intn(len(authors))]}
+		}
+		entries[i] = e
+	}
+	return entries
+}
+
+type benchCodec struct {
+	name     string
+	newCodec func(b *testing.B, inner margaret.Codec) margaret.Codec
+}
+
+func benchCodecs() []benchCodec {
+	withAlg := func(alg compress.Algorithm) func(*testing.B, margaret.Codec) margaret.Codec {
+		return func(b *testing.B, inner margaret.Codec) margaret.Codec {
+			c, err := compress.New(inner, alg, nil)
+			require.NoError(b, err)
+			return c
+		}
+	}
+
+	return []benchCodec{
+		{"json", func(_ *testing.B, inner margaret.Codec) margaret.Codec { return inner }},
+		{"snappy", withAlg(compress.Snappy)},
+		{"zstd", withAlg(compress.Zstd)},
+		{"zstd-dict", func(b *testing.B, inner margaret.Codec) margaret.Codec {
+			// train on other entries than the ones that are stored
+			samples := mem.New()
+			for _, e := range makeEntries(2000) {
+				_, err := samples.Append(e)
+				require.NoError(b, err)
+			}
+			dict, err := compress.TrainDictFromLog(samples, inner, 2000, compress.DefaultDictSize)
+			require.NoError(b, err)
+
+			c, err := compress.New(inner, compress.Zstd, dict)
+			require.NoError(b, err)
+			return c
+		}},
+	}
+}
+
+// BenchmarkOffset2Append appends entries to offset2 logs and reports the size of the data file per entry.
+func BenchmarkOffset2Append(b *testing.B) {
+	entries := makeEntries(1000)
+
+	for _, bc := range benchCodecs() {
+		b.Run(bc.name, func(b *testing.B) {
+			r := require.New(b)
+			codec := bc.newCodec(b, json.New(entry{}))
+
+			dir := b.TempDir()
+			log, err := offset2.Open(dir, codec)
+			r.NoError(err)
+			defer log.Close()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := log.Append(entries[i%len(entries)])
+				r.NoError(err)
+			}
+			b.StopTimer()
+
+			fi, err := os.Stat(filepath.Join(dir, "data"))
+			r.NoError(err)
+			b.ReportMetric(float64(fi.Size())/float64(b.N), "B/entry")
+		})
+	}
+}
+
+// BenchmarkOffset2Get reads random entries from offset2 logs.
+func BenchmarkOffset2Get(b *testing.B) {
+	entries := makeEntries(1000)
+
+	for _, bc := range benchCodecs() {
+		b.Run(bc.name, func(b *testing.B) {
+			r := require.New(b)
+			codec := bc.newCodec(b, json.New(entry{}))
+
+			log, err := offset2.Open(b.TempDir(), codec)
+			r.NoError(err)
+			defer log.Close()
+
+			for _, e := range entries {
+				_, err := log.Append(e)
+				r.NoError(err)
+			}
+
+			rand := rand.New(rand.NewSource(1))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				v, err := log.Get(rand.Int63n(int64(len(entries))))
+				r.NoError(err)
+				if _, ok := v.(entry); !ok {
+					b.Fatalf("unexpected value %T", v)
+				}
+			}
+		})
+	}
+}
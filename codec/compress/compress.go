@@ -0,0 +1,210 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package compress wraps a margaret.Codec and compresses every encoded value with zstd or snappy.
+//
+// Each frame starts with a byte naming the algorithm and the length of the compressed data as a varint.
+// Values that don't get smaller are stored uncompressed, and all algorithms can be decoded by every codec of this package,
+// so the algorithm of a log can be changed without rewriting it. Frames compressed with a dictionary need the same dictionary to be read.
+package compress // import "github.com/ssbc/margaret/codec/compress"
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/internal/frame"
+)
+
+// Algorithm is the compression algorithm of a frame.
+type Algorithm byte
+
+const (
+	// None stores the value as encoded by the wrapped codec.
+	None Algorithm = iota
+	// Zstd compresses well, especially with a dictionary, but is slower.
+	Zstd
+	// Snappy is fast, but compresses less.
+	Snappy
+)
+
+func (alg Algorithm) String() string {
+	switch alg {
+	case None:
+		return "none"
+	case Zstd:
+		return "zstd"
+	case Snappy:
+		return "snappy"
+	default:
+		return fmt.Sprintf("Algorithm(%d)", byte(alg))
+	}
+}
+
+// New returns a codec that compresses the values encoded by inner with alg.
+// dict is an optional zstd dictionary, see TrainDict. It can only be used with Zstd.
+func New(inner margaret.Codec, alg Algorithm, dict []byte) (margaret.Codec, error) {
+	switch alg {
+	case None, Snappy:
+		if dict != nil {
+			return nil, fmt.Errorf("compress: dictionaries are not supported by %s", alg)
+		}
+	case Zstd:
+	default:
+		return nil, fmt.Errorf("compress: unknown algorithm %d", byte(alg))
+	}
+
+	// entries are small, so there is no need for the big buffers zstd uses by default
+	var (
+		encOpts = []zstd.EOption{zstd.WithEncoderConcurrency(1), zstd.WithLowerEncoderMem(true)}
+		decOpts = []zstd.DOption{zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true)}
+	)
+	if dict != nil {
+		encOpts = append(encOpts, zstd.WithEncoderDict(dict))
+		decOpts = append(decOpts, zstd.WithDecoderDicts(dict))
+	}
+
+	c := &codec{
+		inner:   inner,
+		alg:     alg,
+		decOpts: decOpts,
+	}
+
+	// EncodeAll and DecodeAll can be used concurrently, so all encoders and decoders of the codec share them
+	if alg == Zstd {
+		var err error
+		c.zenc, err = zstd.NewWriter(nil, encOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("compress: error creating zstd encoder: %w", err)
+		}
+	}
+	return c, nil
+}
+
+type codec struct {
+	inner margaret.Codec
+	alg   Algorithm
+
+	zenc *zstd.Encoder
+
+	// the decoder is created once the first zstd frame is read
+	decOpts []zstd.DOption
+	decOnce sync.Once
+	zdec    *zstd.Decoder
+	decErr  error
+}
+
+func (c *codec) zstdDecoder() (*zstd.Decoder, error) {
+	c.decOnce.Do(func() {
+		c.zdec, c.decErr = zstd.NewReader(nil, c.decOpts...)
+	})
+	return c.zdec, c.decErr
+}
+
+func (c *codec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := c.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *codec) Unmarshal(data []byte) (interface{}, error) {
+	return c.NewDecoder(bytes.NewReader(data)).Decode()
+}
+
+func (c *codec) NewEncoder(w io.Writer) margaret.Encoder {
+	return &encoder{c: c, w: w}
+}
+
+func (c *codec) NewDecoder(r io.Reader) margaret.Decoder {
+	return &decoder{c: c, r: frame.NewReader(r)}
+}
+
+// compress returns the frame for the encoded value data.
+func (c *codec) compress(data []byte) []byte {
+	alg := c.alg
+
+	var payload []byte
+	switch alg {
+	case Zstd:
+		payload = c.zenc.EncodeAll(data, nil)
+	case Snappy:
+		payload = s2.EncodeSnappy(nil, data)
+	}
+	if alg == None || len(payload) >= len(data) {
+		alg, payload = None, data
+	}
+
+	return frame.Append([]byte{byte(alg)}, payload)
+}
+
+// decompress returns the encoded value of a frame with algorithm alg.
+func (c *codec) decompress(alg Algorithm, payload []byte) ([]byte, error) {
+	switch alg {
+	case None:
+		return payload, nil
+	case Zstd:
+		zdec, err := c.zstdDecoder()
+		if err != nil {
+			return nil, err
+		}
+		return zdec.DecodeAll(payload, nil)
+	case Snappy:
+		return s2.Decode(nil, payload)
+	default:
+		return nil, fmt.Errorf("unknown algorithm %d", byte(alg))
+	}
+}
+
+type encoder struct {
+	c *codec
+	w io.Writer
+}
+
+func (enc *encoder) Encode(v interface{}) error {
+	data, err := enc.c.inner.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("compress: inner codec failed: %w", err)
+	}
+
+	if _, err := enc.w.Write(enc.c.compress(data)); err != nil {
+		return fmt.Errorf("compress: error writing frame: %w", err)
+	}
+	return nil
+}
+
+type decoder struct {
+	c *codec
+	r frame.ByteReader
+}
+
+func (dec *decoder) Decode() (interface{}, error) {
+	hdr, err := dec.r.ReadByte()
+	if err != nil {
+		return nil, frame.WrapStart(err, "compress: error reading frame header")
+	}
+	alg := Algorithm(hdr)
+
+	payload, err := frame.Read(dec.r, frame.MaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("compress: %w", err)
+	}
+
+	data, err := dec.c.decompress(alg, payload)
+	if err != nil {
+		return nil, fmt.Errorf("compress: error decompressing %s frame: %w", alg, err)
+	}
+
+	v, err := dec.c.inner.Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("compress: inner codec failed: %w", err)
+	}
+	return v, nil
+}
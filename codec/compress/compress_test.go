@@ -0,0 +1,173 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package compress_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/codec/compress"
+	"github.com/ssbc/margaret/codec/json"
+	"github.com/ssbc/margaret/mem"
+)
+
+func newCodec(t *testing.T, alg compress.Algorithm, dict []byte) margaret.Codec {
+	c, err := compress.New(json.New(entry{}), alg, dict)
+	require.NoError(t, err)
+	return c
+}
+
+func TestRoundTrip(t *testing.T) {
+	entries := makeEntries(100)
+
+	samples := mem.New()
+	for _, e := range makeEntries(2000) {
+		_, err := samples.Append(e)
+		require.NoError(t, err)
+	}
+	dict, err := compress.TrainDictFromLog(samples, json.New(entry{}), 2000, compress.DefaultDictSize)
+	require.NoError(t, err)
+
+	tcs := []struct {
+		name string
+		alg  compress.Algorithm
+		dict []byte
+	}{
+		{"none", compress.None, nil},
+		{"zstd", compress.Zstd, nil},
+		{"zstd-dict", compress.Zstd, dict},
+		{"snappy", compress.Snappy, nil},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+			c := newCodec(t, tc.alg, tc.dict)
+
+			var buf bytes.Buffer
+			enc := c.NewEncoder(&buf)
+			for _, e := range entries {
+				data, err := c.Marshal(e)
+				r.NoError(err)
+				if tc.alg != compress.None {
+					r.EqualValues(tc.alg, data[0], "entry not compressed")
+				}
+
+				v, err := c.Unmarshal(data)
+				r.NoError(err)
+				r.Equal(e, v)
+
+				r.NoError(enc.Encode(e))
+			}
+
+			dec := c.NewDecoder(&buf)
+			for i, e := range entries {
+				v, err := dec.Decode()
+				r.NoError(err, "decode #%d", i)
+				r.Equal(e, v, "value #%d differs", i)
+			}
+			_, err := dec.Decode()
+			r.Equal(io.EOF, err)
+		})
+	}
+
+	t.Run("incompressible", func(t *testing.T) {
+		r := require.New(t)
+		c := newCodec(t, compress.Zstd, nil)
+
+		data, err := c.Marshal(entry{})
+		r.NoError(err)
+		r.EqualValues(compress.None, data[0], "value that doesn't get smaller should be stored as it is")
+
+		v, err := c.Unmarshal(data)
+		r.NoError(err)
+		r.Equal(entry{}, v)
+	})
+
+	t.Run("other algorithm", func(t *testing.T) {
+		r := require.New(t)
+
+		data, err := newCodec(t, compress.Zstd, nil).Marshal(entries[0])
+		r.NoError(err)
+
+		// the algorithm of a log can be changed without rewriting it
+		v, err := newCodec(t, compress.Snappy, nil).Unmarshal(data)
+		r.NoError(err)
+		r.Equal(entries[0], v)
+	})
+}
+
+func TestCorruptFrame(t *testing.T) {
+	e := makeEntries(1)[0]
+
+	for _, alg := range []compress.Algorithm{compress.Zstd, compress.Snappy} {
+		t.Run(alg.String(), func(t *testing.T) {
+			r := require.New(t)
+			c := newCodec(t, alg, nil)
+
+			data, err := c.Marshal(e)
+			r.NoError(err)
+			r.EqualValues(alg, data[0])
+
+			corrupt := append([]byte(nil), data...)
+			for i := len(corrupt) / 2; i < len(corrupt); i++ {
+				corrupt[i] ^= 0xff
+			}
+			_, err = c.Unmarshal(corrupt)
+			r.Error(err, "corrupt payload decoded")
+
+			_, err = c.Unmarshal(data[:len(data)/2])
+			r.True(errors.Is(err, io.ErrUnexpectedEOF), "expected io.ErrUnexpectedEOF for a truncated frame, got %v", err)
+
+			unknown := append([]byte{0x7f}, data[1:]...)
+			_, err = c.Unmarshal(unknown)
+			r.Error(err, "unknown algorithm decoded")
+
+			_, err = c.Unmarshal(nil)
+			r.Equal(io.EOF, err)
+		})
+	}
+
+	t.Run("missing dict", func(t *testing.T) {
+		r := require.New(t)
+
+		samples := make([][]byte, 0, 2000)
+		inner := json.New(entry{})
+		for _, e := range makeEntries(2000) {
+			data, err := inner.Marshal(e)
+			r.NoError(err)
+			samples = append(samples, data)
+		}
+		dict, err := compress.TrainDict(samples, compress.DefaultDictSize)
+		r.NoError(err)
+
+		data, err := newCodec(t, compress.Zstd, dict).Marshal(e)
+		r.NoError(err)
+		_, err = newCodec(t, compress.Zstd, nil).Unmarshal(data)
+		r.Error(err, "frame decoded without its dictionary")
+	})
+}
+
+func TestTrainDictTooFewSamples(t *testing.T) {
+	r := require.New(t)
+
+	_, err := compress.TrainDict(nil, compress.DefaultDictSize)
+	r.True(errors.Is(err, compress.ErrTooFewSamples), "got %v", err)
+
+	// zstd panics on samples that match the history too rarely, which has to come back as an error
+	var samples [][]byte
+	for i := 0; i < 20; i++ {
+		samples = append(samples, []byte(fmt.Sprintf("sample %d of a few", i)))
+	}
+	r.NotPanics(func() {
+		_, err = compress.TrainDict(samples, compress.DefaultDictSize)
+	})
+	r.True(errors.Is(err, compress.ErrTooFewSamples), "got %v", err)
+}
@@ -0,0 +1,114 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package compress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ssbc/go-luigi"
+
+	"github.com/ssbc/margaret"
+)
+
+// DefaultDictSize is a good size for dictionaries of logs with small entries.
+const DefaultDictSize = 16 << 10
+
+// ErrTooFewSamples is returned by TrainDict if the samples don't have enough in common to build a dictionary.
+var ErrTooFewSamples = errors.New("compress: too few samples to train a dictionary")
+
+// TrainDict builds a zstd dictionary of up to size bytes from samples of encoded values.
+// The samples should come from the wrapped codec and look like the values that will be stored, later samples are preferred.
+// A few hundred samples are needed, depending on their size.
+func TrainDict(samples [][]byte, size int) (dict []byte, err error) {
+	if len(samples) == 0 {
+		return nil, ErrTooFewSamples
+	}
+
+	defer func() {
+		// BuildDict divides by zero if the samples matched the history less than 512 times
+		if r := recover(); r != nil {
+			dict, err = nil, fmt.Errorf("%w: %v", ErrTooFewSamples, r)
+		}
+	}()
+
+	// the history is the part of the dictionary that frames can refer to, most useful at its end
+	var history []byte
+	for i := len(samples) - 1; i >= 0 && len(history) < size; i-- {
+		history = append(samples[i][:len(samples[i]):len(samples[i])], history...)
+	}
+	if len(history) > size {
+		history = history[len(history)-size:]
+	}
+
+	dict, err = zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       dictID(history),
+		Contents: samples,
+		History:  history,
+		Offsets:  [3]int{1, 4, 8},
+		Level:    zstd.SpeedDefault,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("compress: error building dictionary: %w", err)
+	}
+
+	// samples that are too much alike can result in tables the encoder refuses
+	zenc, err := zstd.NewWriter(nil, zstd.WithEncoderDict(dict))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTooFewSamples, err)
+	}
+	zenc.Close()
+	return dict, nil
+}
+
+// TrainDictFromLog builds a dictionary of up to size bytes from the last n entries of log, encoded with inner.
+// Deleted entries are skipped.
+func TrainDictFromLog(log margaret.Log, inner margaret.Codec, n, size int) ([]byte, error) {
+	src, err := log.Query(margaret.Reverse(true), margaret.Limit(n), margaret.Live(false))
+	if err != nil {
+		return nil, fmt.Errorf("compress: error querying log: %w", err)
+	}
+
+	var samples [][]byte
+	ctx := context.Background()
+	for {
+		v, err := src.Next(ctx)
+		if luigi.IsEOS(err) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("compress: error reading log: %w", err)
+		}
+		if err, ok := v.(error); ok {
+			if margaret.IsErrNulled(err) {
+				continue
+			}
+			return nil, fmt.Errorf("compress: error reading log: %w", err)
+		}
+
+		data, err := inner.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("compress: error encoding sample: %w", err)
+		}
+		samples = append(samples, data)
+	}
+
+	// the query returned the newest entry first
+	for i, j := 0, len(samples)-1; i < j; i, j = i+1, j-1 {
+		samples[i], samples[j] = samples[j], samples[i]
+	}
+	return TrainDict(samples, size)
+}
+
+// dictID derives an id from the dictionary content, in the range that zstd leaves for private dictionaries.
+func dictID(history []byte) uint32 {
+	const (
+		lowest  = 1 << 15
+		highest = 1 << 31
+	)
+	return lowest + crc32.ChecksumIEEE(history)%(highest-lowest)
+}
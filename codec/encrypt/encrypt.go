@@ -0,0 +1,247 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package encrypt wraps a margaret.Codec and seals every encoded value with an AEAD, so logs can be encrypted at rest.
+//
+// Each frame starts with a header naming the cipher and the id of the key it was sealed with, followed by the length of the sealed data as a varint.
+// The header is authenticated together with the value. New frames are sealed with the current key of the KeyProvider,
+// older frames are opened with the key named in their header, so keys can be rotated without rewriting the log.
+package encrypt // import "github.com/ssbc/margaret/codec/encrypt"
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/internal/frame"
+)
+
+// KeySize is the size of the keys for all ciphers.
+const KeySize = 32
+
+// Cipher is the AEAD a frame is sealed with.
+type Cipher byte
+
+const (
+	// XChaCha20Poly1305 uses random 24 byte nonces, so a key can seal any number of frames.
+	XChaCha20Poly1305 Cipher = iota + 1
+	// AESGCM is AES-256 in Galois/Counter Mode, which is faster on hardware with AES instructions.
+	// It uses random 12 byte nonces, so keys should be rotated after a few billion frames.
+	AESGCM
+)
+
+func (c Cipher) String() string {
+	switch c {
+	case XChaCha20Poly1305:
+		return "xchacha20poly1305"
+	case AESGCM:
+		return "aes-gcm"
+	default:
+		return fmt.Sprintf("Cipher(%d)", byte(c))
+	}
+}
+
+func (c Cipher) aead(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("encrypt: key has %d bytes, expected %d", len(key), KeySize)
+	}
+
+	switch c {
+	case XChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	case AESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	default:
+		return nil, fmt.Errorf("encrypt: unknown cipher %d", byte(c))
+	}
+}
+
+// KeyProvider holds the keys of a codec. Its methods can be called concurrently.
+type KeyProvider interface {
+	// CurrentKey returns the key that new frames are sealed with, and its id.
+	CurrentKey() (id uint32, key []byte, err error)
+
+	// Key returns the key with id, to open frames that were sealed with it.
+	// It should return an error wrapping ErrUnknownKey if there is no such key.
+	Key(id uint32) ([]byte, error)
+}
+
+var (
+	// ErrUnknownKey is returned by key providers that don't have the requested key.
+	ErrUnknownKey = errors.New("encrypt: unknown key")
+
+	// ErrOpen is wrapped by DecryptError if a frame couldn't be authenticated,
+	// because it was sealed with a different key of the same id or it was modified.
+	ErrOpen = errors.New("encrypt: message authentication failed")
+)
+
+// DecryptError is returned when a frame can't be decrypted, wrapped by the errors of Get and queries.
+// Use errors.As to find it.
+type DecryptError struct {
+	Cipher Cipher
+	KeyID  uint32
+	Err    error
+}
+
+func (err *DecryptError) Error() string {
+	return fmt.Sprintf("encrypt: error decrypting %s frame with key %d: %s", err.Cipher, err.KeyID, err.Err)
+}
+
+func (err *DecryptError) Unwrap() error { return err.Err }
+
+const headerSize = 1 + 4 // cipher, key id
+
+// New returns a codec that seals the values encoded by inner with c, using the keys of keys.
+func New(inner margaret.Codec, c Cipher, keys KeyProvider) (margaret.Codec, error) {
+	if c != XChaCha20Poly1305 && c != AESGCM {
+		return nil, fmt.Errorf("encrypt: unknown cipher %d", byte(c))
+	}
+	if _, _, err := keys.CurrentKey(); err != nil {
+		return nil, fmt.Errorf("encrypt: error getting current key: %w", err)
+	}
+
+	return &codec{
+		inner:  inner,
+		cipher: c,
+		keys:   keys,
+	}, nil
+}
+
+type codec struct {
+	inner  margaret.Codec
+	cipher Cipher
+	keys   KeyProvider
+}
+
+func (c *codec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := c.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *codec) Unmarshal(data []byte) (interface{}, error) {
+	return c.NewDecoder(bytes.NewReader(data)).Decode()
+}
+
+func (c *codec) NewEncoder(w io.Writer) margaret.Encoder {
+	return &encoder{c: c, w: w}
+}
+
+func (c *codec) NewDecoder(r io.Reader) margaret.Decoder {
+	return &decoder{c: c, r: frame.NewReader(r)}
+}
+
+// seal returns the frame for the encoded value data.
+func (c *codec) seal(data []byte) ([]byte, error) {
+	id, key, err := c.keys.CurrentKey()
+	if err != nil {
+		return nil, fmt.Errorf("encrypt: error getting current key: %w", err)
+	}
+	aead, err := c.cipher.aead(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var hdr [headerSize]byte
+	hdr[0] = byte(c.cipher)
+	binary.BigEndian.PutUint32(hdr[1:], id)
+
+	sealed := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := rand.Read(sealed); err != nil {
+		return nil, fmt.Errorf("encrypt: error making nonce: %w", err)
+	}
+	sealed = aead.Seal(sealed, sealed, data, hdr[:])
+
+	return frame.Append(hdr[:], sealed), nil
+}
+
+// open returns the encoded value of a frame with header hdr.
+func (c *codec) open(hdr [headerSize]byte, sealed []byte) ([]byte, error) {
+	tipe, id := Cipher(hdr[0]), binary.BigEndian.Uint32(hdr[1:])
+	decryptErr := func(err error) error {
+		return &DecryptError{Cipher: tipe, KeyID: id, Err: err}
+	}
+
+	key, err := c.keys.Key(id)
+	if err != nil {
+		return nil, decryptErr(err)
+	}
+	aead, err := tipe.aead(key)
+	if err != nil {
+		return nil, decryptErr(err)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, decryptErr(io.ErrUnexpectedEOF)
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	data, err := aead.Open(nil, nonce, ciphertext, hdr[:])
+	if err != nil {
+		return nil, decryptErr(ErrOpen)
+	}
+	return data, nil
+}
+
+type encoder struct {
+	c *codec
+	w io.Writer
+}
+
+func (enc *encoder) Encode(v interface{}) error {
+	data, err := enc.c.inner.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encrypt: inner codec failed: %w", err)
+	}
+
+	frame, err := enc.c.seal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := enc.w.Write(frame); err != nil {
+		return fmt.Errorf("encrypt: error writing frame: %w", err)
+	}
+	return nil
+}
+
+type decoder struct {
+	c *codec
+	r frame.ByteReader
+}
+
+func (dec *decoder) Decode() (interface{}, error) {
+	var hdr [headerSize]byte
+	if _, err := io.ReadFull(dec.r, hdr[:]); err != nil {
+		return nil, frame.WrapStart(err, "encrypt: error reading frame header")
+	}
+
+	sealed, err := frame.Read(dec.r, frame.MaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt: %w", err)
+	}
+
+	data, err := dec.c.open(hdr, sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := dec.c.inner.Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt: inner codec failed: %w", err)
+	}
+	return v, nil
+}
@@ -0,0 +1,144 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package encrypt_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ssbc/go-luigi"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/codec/encrypt"
+	"github.com/ssbc/margaret/codec/json"
+	"github.com/ssbc/margaret/offset2"
+)
+
+func key(b byte) []byte {
+	return bytes.Repeat([]byte{b}, encrypt.KeySize)
+}
+
+func TestRotate(t *testing.T) {
+	for _, c := range []encrypt.Cipher{encrypt.XChaCha20Poly1305, encrypt.AESGCM} {
+		t.Run(c.String(), func(t *testing.T) {
+			r := require.New(t)
+			dir := t.TempDir()
+
+			keys, err := encrypt.NewKeys(1, key(1))
+			r.NoError(err)
+			codec, err := encrypt.New(json.New(""), c, keys)
+			r.NoError(err)
+
+			log, err := offset2.Open(dir, codec)
+			r.NoError(err)
+			_, err = log.Append("sealed with key 1")
+			r.NoError(err)
+
+			r.NoError(keys.Rotate(2, key(2)))
+			_, err = log.Append("sealed with key 2")
+			r.NoError(err)
+
+			// both keys are needed to read the log
+			v, err := log.Get(0)
+			r.NoError(err)
+			r.Equal("sealed with key 1", v)
+			v, err = log.Get(1)
+			r.NoError(err)
+			r.Equal("sealed with key 2", v)
+
+			// the plaintext doesn't end up on disk
+			data, err := os.ReadFile(filepath.Join(dir, "data"))
+			r.NoError(err)
+			r.False(bytes.Contains(data, []byte("sealed with")))
+
+			// once the old key is gone, its entries can't be read anymore
+			r.NoError(keys.Remove(1))
+			r.Error(keys.Remove(2), "removed current key")
+
+			_, err = log.Get(0)
+			var decErr *encrypt.DecryptError
+			r.True(errors.As(err, &decErr), "unexpected error: %v", err)
+			r.Equal(c, decErr.Cipher)
+			r.EqualValues(1, decErr.KeyID)
+			r.True(errors.Is(err, encrypt.ErrUnknownKey))
+
+			// queries fail the same way
+			src, err := log.Query(margaret.Live(false))
+			r.NoError(err)
+			_, err = src.Next(context.Background())
+			r.True(errors.As(err, &decErr), "unexpected error: %v", err)
+
+			r.NoError(log.Close())
+		})
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	r := require.New(t)
+	dir := t.TempDir()
+
+	keys, err := encrypt.NewKeys(1, key(1))
+	r.NoError(err)
+	codec, err := encrypt.New(json.New(""), encrypt.XChaCha20Poly1305, keys)
+	r.NoError(err)
+
+	log, err := offset2.Open(dir, codec)
+	r.NoError(err)
+	_, err = log.Append("secret")
+	r.NoError(err)
+	r.NoError(log.Close())
+
+	// a different key under the same id can't open the frame
+	otherKeys, err := encrypt.NewKeys(1, key(2))
+	r.NoError(err)
+	otherCodec, err := encrypt.New(json.New(""), encrypt.XChaCha20Poly1305, otherKeys)
+	r.NoError(err)
+
+	log, err = offset2.Open(dir, otherCodec)
+	r.NoError(err)
+	defer log.Close()
+
+	_, err = log.Get(0)
+	var decErr *encrypt.DecryptError
+	r.True(errors.As(err, &decErr), "unexpected error: %v", err)
+	r.True(errors.Is(err, encrypt.ErrOpen))
+	r.False(luigi.IsEOS(err))
+}
+
+func TestTampered(t *testing.T) {
+	r := require.New(t)
+
+	keys, err := encrypt.NewKeys(7, key(7))
+	r.NoError(err)
+	codec, err := encrypt.New(json.New(""), encrypt.AESGCM, keys)
+	r.NoError(err)
+
+	frame, err := codec.Marshal("value")
+	r.NoError(err)
+
+	for i := range frame {
+		if i == 0 || (i >= 1 && i < 5) {
+			// changing the header names another cipher or key
+			continue
+		}
+		tampered := append([]byte(nil), frame...)
+		tampered[i] ^= 0x01
+
+		_, err := codec.Unmarshal(tampered)
+		r.Error(err, "tampered byte %d", i)
+	}
+
+	// the header is authenticated, too
+	r.NoError(keys.Add(8, key(7)))
+	tampered := append([]byte(nil), frame...)
+	tampered[4] = 8
+	_, err = codec.Unmarshal(tampered)
+	r.True(errors.Is(err, encrypt.ErrOpen), "unexpected error: %v", err)
+}
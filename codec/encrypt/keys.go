@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package encrypt
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Keys is a KeyProvider that holds its keys in memory.
+type Keys struct {
+	l       sync.RWMutex
+	current uint32
+	keys    map[uint32][]byte
+}
+
+var _ KeyProvider = (*Keys)(nil)
+
+// NewKeys returns a key provider that seals with key, which has id.
+func NewKeys(id uint32, key []byte) (*Keys, error) {
+	ks := &Keys{keys: make(map[uint32][]byte)}
+	if err := ks.Rotate(id, key); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Add adds a key that is only used to open frames that were sealed with it.
+func (ks *Keys) Add(id uint32, key []byte) error {
+	if len(key) != KeySize {
+		return fmt.Errorf("encrypt: key has %d bytes, expected %d", len(key), KeySize)
+	}
+
+	ks.l.Lock()
+	defer ks.l.Unlock()
+
+	if old, ok := ks.keys[id]; ok && string(old) != string(key) {
+		return fmt.Errorf("encrypt: there already is a different key with id %d", id)
+	}
+	ks.keys[id] = append([]byte(nil), key...)
+	return nil
+}
+
+// Rotate adds key and makes it the key that new frames are sealed with.
+// The previous keys are kept, so that older frames can still be read.
+func (ks *Keys) Rotate(id uint32, key []byte) error {
+	if err := ks.Add(id, key); err != nil {
+		return err
+	}
+
+	ks.l.Lock()
+	defer ks.l.Unlock()
+	ks.current = id
+	return nil
+}
+
+// Remove forgets the key with id. The current key can't be removed.
+func (ks *Keys) Remove(id uint32) error {
+	ks.l.Lock()
+	defer ks.l.Unlock()
+
+	if id == ks.current {
+		return errors.New("encrypt: can't remove the current key")
+	}
+	delete(ks.keys, id)
+	return nil
+}
+
+func (ks *Keys) CurrentKey() (uint32, []byte, error) {
+	ks.l.RLock()
+	defer ks.l.RUnlock()
+
+	return ks.current, ks.keys[ks.current], nil
+}
+
+func (ks *Keys) Key(id uint32) ([]byte, error) {
+	ks.l.RLock()
+	defer ks.l.RUnlock()
+
+	key, ok := ks.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownKey, id)
+	}
+	return key, nil
+}
@@ -13,27 +13,14 @@ import (
 )
 
 // New creates a json codec that decodes into values of type tipe.
+// If tipe is a pointer, decoded values are pointers, too. If tipe is nil, values are decoded like by json.Unmarshal into an interface{}.
 func New(tipe interface{}) margaret.Codec {
-	if tipe == nil {
-		return &codec{any: true}
-	}
-
-	t := reflect.TypeOf(tipe)
-	isPtr := t.Kind() == reflect.Ptr
-	if isPtr {
-		t = t.Elem()
-	}
-
-	return &codec{
-		tipe:  t,
-		asPtr: isPtr,
-	}
+	return &codec{tipe: reflect.TypeOf(tipe)}
 }
 
 type codec struct {
-	tipe  reflect.Type
-	asPtr bool
-	any   bool
+	// nil for untyped decoding
+	tipe reflect.Type
 }
 
 func (*codec) Marshal(v interface{}) ([]byte, error) {
@@ -41,18 +28,11 @@ func (*codec) Marshal(v interface{}) ([]byte, error) {
 }
 
 func (c *codec) Unmarshal(data []byte) (interface{}, error) {
-	var v interface{}
-	if !c.any {
-		v = reflect.New(c.tipe).Interface()
-	}
-
-	err := json.Unmarshal(data, v)
-
-	if !c.asPtr {
-		v = reflect.ValueOf(v).Elem().Interface()
+	ptr := newValue(c.tipe)
+	if err := json.Unmarshal(data, ptr.Interface()); err != nil {
+		return nil, err
 	}
-
-	return v, err
+	return ptr.Elem().Interface(), nil
 }
 
 func (*codec) NewEncoder(w io.Writer) margaret.Encoder {
@@ -61,25 +41,29 @@ func (*codec) NewEncoder(w io.Writer) margaret.Encoder {
 
 func (c *codec) NewDecoder(r io.Reader) margaret.Decoder {
 	return &decoder{
-		tipe:  c.tipe,
-		dec:   json.NewDecoder(r),
-		asPtr: c.asPtr,
+		tipe: c.tipe,
+		dec:  json.NewDecoder(r),
 	}
 }
 
 type decoder struct {
-	tipe  reflect.Type
-	dec   *json.Decoder
-	asPtr bool
+	tipe reflect.Type
+	dec  *json.Decoder
 }
 
 func (dec *decoder) Decode() (interface{}, error) {
-	v := reflect.New(dec.tipe).Interface()
-	err := dec.dec.Decode(v)
-
-	if !dec.asPtr {
-		v = reflect.ValueOf(v).Elem().Interface()
+	ptr := newValue(dec.tipe)
+	if err := dec.dec.Decode(ptr.Interface()); err != nil {
+		return nil, err
 	}
+	return ptr.Elem().Interface(), nil
+}
 
-	return v, err
+// newValue returns a pointer to a new value of type tipe, or to an interface{} if tipe is nil.
+func newValue(tipe reflect.Type) reflect.Value {
+	if tipe == nil {
+		var v interface{}
+		return reflect.ValueOf(&v)
+	}
+	return reflect.New(tipe)
 }
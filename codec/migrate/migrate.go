@@ -0,0 +1,335 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package migrate implements a margaret.Codec for logs whose entry type changes over time.
+//
+// Each frame starts with the version of the schema it was written with and the length of the encoded value, both as varints.
+// A Registry holds a codec for every version and a function that upgrades values of a version to the next one.
+// Old frames stay as they are on disk, but are decoded with the codec of their version and upgraded to the latest version on every read.
+// New values are always written with the latest version.
+//
+// Logs that were written with a plain codec before they switched to this one hold frames without a version.
+// Registry.RegisterLegacy adds a codec for those, which is used for every frame that can't be read as a versioned one.
+package migrate // import "github.com/ssbc/margaret/codec/migrate"
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/internal/frame"
+)
+
+// ErrUnknownVersion is returned when a frame was written with a version that isn't registered.
+var ErrUnknownVersion = errors.New("migrate: unknown schema version")
+
+// UpgradeFunc turns a value of one version into a value of the next registered version.
+type UpgradeFunc func(v interface{}) (interface{}, error)
+
+// Registry holds the versions of a schema.
+type Registry struct {
+	l        sync.Mutex
+	versions map[uint64]version
+	legacy   *version
+}
+
+type version struct {
+	codec   margaret.Codec
+	upgrade UpgradeFunc
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{versions: make(map[uint64]version)}
+}
+
+// Register adds version v of the schema, whose values are encoded by codec.
+// upgrade turns values of v into values of the next higher version and has to be nil for the latest version.
+// Versions don't need to be consecutive or registered in order.
+func (r *Registry) Register(v uint64, codec margaret.Codec, upgrade UpgradeFunc) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	if _, ok := r.versions[v]; ok {
+		return fmt.Errorf("migrate: version %d registered twice", v)
+	}
+	r.versions[v] = version{codec: codec, upgrade: upgrade}
+	return nil
+}
+
+// RegisterLegacy adds codec for values that were written without the migrate codec, so without a version.
+// upgrade turns these values into values of the oldest registered version and may be nil if they already are such values.
+//
+// Legacy values can't be told apart from versioned frames by a marker, since they have none.
+// A frame is read as a versioned one if it starts with a registered version, its length matches the one in the frame
+// and the codec of the version can decode it, otherwise it is decoded with the legacy codec.
+//
+// Decoders check every value of a stream like this until they meet the first legacy value.
+// They decode the rest of the stream with a decoder of the legacy codec, which might read ahead,
+// so versioned frames can't follow legacy values in the same stream.
+// That doesn't matter for offset2, which hands every frame its own reader.
+func (r *Registry) RegisterLegacy(codec margaret.Codec, upgrade UpgradeFunc) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	if r.legacy != nil {
+		return errors.New("migrate: legacy codec registered twice")
+	}
+	r.legacy = &version{codec: codec, upgrade: upgrade}
+	return nil
+}
+
+// Codec returns a codec that writes values with the latest registered version and upgrades older ones when reading them.
+// Changes to the registry after this call don't affect the returned codec.
+func (r *Registry) Codec() (margaret.Codec, error) {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	if len(r.versions) == 0 {
+		return nil, errors.New("migrate: no versions registered")
+	}
+
+	c := &codec{
+		versions: make(map[uint64]version, len(r.versions)),
+		next:     make(map[uint64]uint64, len(r.versions)),
+		legacy:   r.legacy,
+	}
+
+	order := make([]uint64, 0, len(r.versions))
+	for v, ver := range r.versions {
+		order = append(order, v)
+		c.versions[v] = ver
+	}
+	sort.Slice(order, func(i, j int) bool { return order[i] < order[j] })
+	c.oldest = order[0]
+
+	for i, v := range order {
+		upgrade := r.versions[v].upgrade
+		if i == len(order)-1 {
+			if upgrade != nil {
+				return nil, fmt.Errorf("migrate: latest version %d has an upgrade function", v)
+			}
+			c.latest = v
+			break
+		}
+		if upgrade == nil {
+			return nil, fmt.Errorf("migrate: version %d needs an upgrade function to version %d", v, order[i+1])
+		}
+		c.next[v] = order[i+1]
+	}
+	return c, nil
+}
+
+type codec struct {
+	versions map[uint64]version
+	next     map[uint64]uint64 // the version each one upgrades to
+	oldest   uint64
+	latest   uint64
+
+	// legacy decodes frames without a version, if any were registered
+	legacy *version
+}
+
+func (c *codec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := c.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *codec) Unmarshal(data []byte) (interface{}, error) {
+	if c.legacy != nil {
+		return c.unmarshalLegacy(data)
+	}
+	return c.NewDecoder(bytes.NewReader(data)).Decode()
+}
+
+func (c *codec) NewEncoder(w io.Writer) margaret.Encoder {
+	return &encoder{c: c, w: w}
+}
+
+func (c *codec) NewDecoder(r io.Reader) margaret.Decoder {
+	if c.legacy != nil {
+		br, ok := r.(*bufio.Reader)
+		if !ok {
+			br = bufio.NewReader(r)
+		}
+		return &legacyDecoder{c: c, r: br}
+	}
+	return &decoder{c: c, r: frame.NewReader(r)}
+}
+
+// decode decodes data written with version v and upgrades it to the latest version.
+func (c *codec) decode(v uint64, data []byte) (interface{}, error) {
+	ver, ok := c.versions[v]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, v)
+	}
+
+	val, err := ver.codec.Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("migrate: error decoding value of version %d: %w", v, err)
+	}
+	return c.upgrade(v, val)
+}
+
+// upgrade upgrades val of version v to the latest version.
+func (c *codec) upgrade(v uint64, val interface{}) (interface{}, error) {
+	var err error
+	for v != c.latest {
+		val, err = c.versions[v].upgrade(val)
+		if err != nil {
+			return nil, fmt.Errorf("migrate: error upgrading value of version %d: %w", v, err)
+		}
+		v = c.next[v]
+	}
+	return val, nil
+}
+
+// unmarshalLegacy decodes data as a versioned frame if it is one and with the legacy codec otherwise.
+func (c *codec) unmarshalLegacy(data []byte) (interface{}, error) {
+	if v, payload, ok := c.split(data); ok {
+		if val, err := c.versions[v].codec.Unmarshal(payload); err == nil {
+			return c.upgrade(v, val)
+		}
+	}
+
+	val, err := c.legacy.codec.Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("migrate: error decoding unversioned value: %w", err)
+	}
+	return c.upgradeLegacy(val)
+}
+
+// upgradeLegacy upgrades val, decoded by the legacy codec, to the latest version.
+func (c *codec) upgradeLegacy(val interface{}) (interface{}, error) {
+	var err error
+	if c.legacy.upgrade != nil {
+		val, err = c.legacy.upgrade(val)
+		if err != nil {
+			return nil, fmt.Errorf("migrate: error upgrading unversioned value: %w", err)
+		}
+	}
+	return c.upgrade(c.oldest, val)
+}
+
+// split returns the version and the payload of data if it is exactly one frame of a registered version.
+func (c *codec) split(data []byte) (uint64, []byte, bool) {
+	v, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, nil, false
+	}
+	if _, ok := c.versions[v]; !ok {
+		return 0, nil, false
+	}
+
+	sz, m := binary.Uvarint(data[n:])
+	if m <= 0 || sz != uint64(len(data)-n-m) {
+		return 0, nil, false
+	}
+	return v, data[n+m:], true
+}
+
+type encoder struct {
+	c *codec
+	w io.Writer
+}
+
+func (enc *encoder) Encode(v interface{}) error {
+	data, err := enc.c.versions[enc.c.latest].codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("migrate: error encoding value of version %d: %w", enc.c.latest, err)
+	}
+
+	if _, err := enc.w.Write(frame.Append(frame.AppendUvarint(nil, enc.c.latest), data)); err != nil {
+		return fmt.Errorf("migrate: error writing frame: %w", err)
+	}
+	return nil
+}
+
+type decoder struct {
+	c *codec
+	r frame.ByteReader
+}
+
+func (dec *decoder) Decode() (interface{}, error) {
+	v, err := binary.ReadUvarint(dec.r)
+	if err != nil {
+		return nil, frame.WrapStart(err, "migrate: error reading version")
+	}
+
+	data, err := frame.Read(dec.r, frame.MaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+
+	return dec.c.decode(v, data)
+}
+
+// legacyDecoder reads streams that might hold values without a version.
+type legacyDecoder struct {
+	c *codec
+	r *bufio.Reader
+
+	// legacy decodes the rest of the stream once a legacy value was found
+	legacy margaret.Decoder
+}
+
+func (dec *legacyDecoder) Decode() (interface{}, error) {
+	if dec.legacy == nil {
+		val, ok, err := dec.decodeVersioned()
+		if ok || err != nil {
+			return val, err
+		}
+	}
+
+	val, err := dec.legacy.Decode()
+	if err == io.EOF {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("migrate: error decoding unversioned value: %w", err)
+	}
+	return dec.c.upgradeLegacy(val)
+}
+
+// decodeVersioned decodes the next value if it is a frame of a registered version.
+// Otherwise it sets up the legacy decoder with the bytes it looked at.
+func (dec *legacyDecoder) decodeVersioned() (interface{}, bool, error) {
+	// the header is two varints at most, but the stream might end before that
+	hdr, err := dec.r.Peek(2 * binary.MaxVarintLen64)
+	if len(hdr) == 0 {
+		if err == io.EOF {
+			return nil, false, err
+		}
+		return nil, false, fmt.Errorf("migrate: error reading frame: %w", err)
+	}
+
+	var read []byte
+	if v, n := binary.Uvarint(hdr); n > 0 {
+		if ver, ok := dec.c.versions[v]; ok {
+			if sz, m := binary.Uvarint(hdr[n:]); m > 0 && sz <= frame.MaxSize {
+				read = make([]byte, n+m+int(sz))
+				k, err := io.ReadFull(dec.r, read)
+				read = read[:k]
+				if err == nil {
+					if val, err := ver.codec.Unmarshal(read[n+m:]); err == nil {
+						val, err = dec.c.upgrade(v, val)
+						return val, true, err
+					}
+				}
+			}
+		}
+	}
+
+	// hand what was read for the check to the legacy decoder
+	dec.legacy = dec.c.legacy.codec.NewDecoder(io.MultiReader(bytes.NewReader(read), dec.r))
+	return nil, false, nil
+}
@@ -0,0 +1,244 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package migrate_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/margaret/codec/json"
+	"github.com/ssbc/margaret/codec/migrate"
+	"github.com/ssbc/margaret/offset2"
+)
+
+type postV1 struct {
+	Text string
+}
+
+type postV2 struct {
+	Text string
+	Tags []string
+}
+
+type postV3 struct {
+	Title string
+	Body  string
+	Tags  []string
+}
+
+func upgradeV1(v interface{}) (interface{}, error) {
+	p, ok := v.(postV1)
+	if !ok {
+		return nil, fmt.Errorf("expected postV1, got %T", v)
+	}
+	return postV2{Text: p.Text, Tags: []string{"untagged"}}, nil
+}
+
+func upgradeV2(v interface{}) (interface{}, error) {
+	p, ok := v.(postV2)
+	if !ok {
+		return nil, fmt.Errorf("expected postV2, got %T", v)
+	}
+	lines := strings.SplitN(p.Text, "\n", 2)
+	post := postV3{Title: lines[0], Tags: p.Tags}
+	if len(lines) > 1 {
+		post.Body = lines[1]
+	}
+	return post, nil
+}
+
+func TestMigrate(t *testing.T) {
+	r := require.New(t)
+	dir := t.TempDir()
+
+	// write some entries with the first versions
+	reg := migrate.NewRegistry()
+	r.NoError(reg.Register(1, json.New(postV1{}), nil))
+	codec, err := reg.Codec()
+	r.NoError(err)
+
+	log, err := offset2.Open(dir, codec)
+	r.NoError(err)
+	_, err = log.Append(postV1{Text: "first\nwritten with v1"})
+	r.NoError(err)
+	r.NoError(log.Close())
+
+	reg = migrate.NewRegistry()
+	r.NoError(reg.Register(1, json.New(postV1{}), upgradeV1))
+	r.NoError(reg.Register(2, json.New(postV2{}), nil))
+	codec, err = reg.Codec()
+	r.NoError(err)
+
+	log, err = offset2.Open(dir, codec)
+	r.NoError(err)
+	_, err = log.Append(postV2{Text: "second", Tags: []string{"v2"}})
+	r.NoError(err)
+	r.NoError(log.Close())
+
+	dataPath := filepath.Join(dir, "data")
+	before, err := os.ReadFile(dataPath)
+	r.NoError(err)
+
+	// the latest code only knows the latest type, but can read everything
+	reg = migrate.NewRegistry()
+	r.NoError(reg.Register(3, json.New(postV3{}), nil))
+	r.NoError(reg.Register(2, json.New(postV2{}), upgradeV2))
+	r.NoError(reg.Register(1, json.New(postV1{}), upgradeV1))
+	codec, err = reg.Codec()
+	r.NoError(err)
+
+	log, err = offset2.Open(dir, codec)
+	r.NoError(err)
+	defer log.Close()
+
+	_, err = log.Append(postV3{Title: "third", Body: "written with v3"})
+	r.NoError(err)
+
+	want := []postV3{
+		{Title: "first", Body: "written with v1", Tags: []string{"untagged"}},
+		{Title: "second", Tags: []string{"v2"}},
+		{Title: "third", Body: "written with v3"},
+	}
+	for i, w := range want {
+		v, err := log.Get(int64(i))
+		r.NoError(err, "get %d", i)
+		r.Equal(w, v, "entry %d", i)
+	}
+
+	// reading doesn't rewrite old frames
+	after, err := os.ReadFile(dataPath)
+	r.NoError(err)
+	r.Equal(before, after[:len(before)])
+}
+
+func TestRegistry(t *testing.T) {
+	r := require.New(t)
+
+	_, err := migrate.NewRegistry().Codec()
+	r.Error(err, "empty registry")
+
+	reg := migrate.NewRegistry()
+	r.NoError(reg.Register(1, json.New(postV1{}), nil))
+	r.Error(reg.Register(1, json.New(postV1{}), nil), "duplicate version")
+	r.NoError(reg.Register(2, json.New(postV2{}), nil))
+	_, err = reg.Codec()
+	r.Error(err, "missing upgrade function")
+
+	reg = migrate.NewRegistry()
+	r.NoError(reg.Register(1, json.New(postV1{}), upgradeV1))
+	_, err = reg.Codec()
+	r.Error(err, "upgrade function on latest version")
+
+	// frames of versions the codec doesn't know
+	reg = migrate.NewRegistry()
+	r.NoError(reg.Register(5, json.New(postV1{}), nil))
+	newer, err := reg.Codec()
+	r.NoError(err)
+	frame, err := newer.Marshal(postV1{Text: "from the future"})
+	r.NoError(err)
+
+	reg = migrate.NewRegistry()
+	r.NoError(reg.Register(1, json.New(postV1{}), nil))
+	older, err := reg.Codec()
+	r.NoError(err)
+	_, err = older.Unmarshal(frame)
+	r.True(errors.Is(err, migrate.ErrUnknownVersion), "unexpected error: %v", err)
+}
+
+func TestLegacy(t *testing.T) {
+	r := require.New(t)
+	dir := t.TempDir()
+
+	// a log written before it used the migrate codec
+	log, err := offset2.Open(dir, json.New(postV1{}))
+	r.NoError(err)
+	_, err = log.Append(postV1{Text: "first\nwritten without a version"})
+	r.NoError(err)
+	r.NoError(log.Close())
+
+	reg := migrate.NewRegistry()
+	r.NoError(reg.Register(1, json.New(postV1{}), upgradeV1))
+	r.NoError(reg.Register(2, json.New(postV2{}), upgradeV2))
+	r.NoError(reg.Register(3, json.New(postV3{}), nil))
+	r.NoError(reg.RegisterLegacy(json.New(postV1{}), nil))
+	r.Error(reg.RegisterLegacy(json.New(postV1{}), nil), "duplicate legacy codec")
+	codec, err := reg.Codec()
+	r.NoError(err)
+
+	log, err = offset2.Open(dir, codec)
+	r.NoError(err)
+	defer log.Close()
+
+	_, err = log.Append(postV3{Title: "second", Body: "written with v3"})
+	r.NoError(err)
+
+	want := []postV3{
+		{Title: "first", Body: "written without a version", Tags: []string{"untagged"}},
+		{Title: "second", Body: "written with v3"},
+	}
+	for i, w := range want {
+		v, err := log.Get(int64(i))
+		r.NoError(err, "get %d", i)
+		r.Equal(w, v, "entry %d", i)
+	}
+
+	src, err := log.Query()
+	r.NoError(err)
+	for i, w := range want {
+		v, err := src.Next(context.TODO())
+		r.NoError(err, "next %d", i)
+		r.Equal(w, v, "entry %d", i)
+	}
+
+	// streams of legacy values are read with the decoder of the legacy codec
+	var legacyStream bytes.Buffer
+	enc := json.New(postV1{}).NewEncoder(&legacyStream)
+	r.NoError(enc.Encode(postV1{Text: "one"}))
+	r.NoError(enc.Encode(postV1{Text: "two\nlines"}))
+	r.NoError(enc.Encode(postV1{Text: "three"}))
+	dec := codec.NewDecoder(&legacyStream)
+	for i, w := range []postV3{
+		{Title: "one", Tags: []string{"untagged"}},
+		{Title: "two", Body: "lines", Tags: []string{"untagged"}},
+		{Title: "three", Tags: []string{"untagged"}},
+	} {
+		v, err := dec.Decode()
+		r.NoError(err, "legacy value %d", i)
+		r.Equal(w, v, "legacy value %d", i)
+	}
+	_, err = dec.Decode()
+	r.Equal(io.EOF, err)
+
+	// and streams of versioned frames still work
+	var versionedStream bytes.Buffer
+	enc = codec.NewEncoder(&versionedStream)
+	r.NoError(enc.Encode(postV3{Title: "four"}))
+	r.NoError(enc.Encode(postV3{Title: "five"}))
+	dec = codec.NewDecoder(&versionedStream)
+	for i, w := range []postV3{{Title: "four"}, {Title: "five"}} {
+		v, err := dec.Decode()
+		r.NoError(err, "versioned value %d", i)
+		r.Equal(w, v, "versioned value %d", i)
+	}
+	_, err = dec.Decode()
+	r.Equal(io.EOF, err)
+
+	// without a legacy codec, the '{' of the legacy frame is read as version 123
+	reg = migrate.NewRegistry()
+	r.NoError(reg.Register(1, json.New(postV1{}), nil))
+	strict, err := reg.Codec()
+	r.NoError(err)
+	_, err = strict.Unmarshal([]byte(`{"Text":"legacy"}`))
+	r.Error(err)
+}
@@ -16,30 +16,29 @@ import (
 )
 
 // New creates a msgpack codec
-// tipe is required because our Decode() interface doesn't take an argument
+// tipe is required because our Decode() interface doesn't take an argument.
+// If tipe is a pointer, decoded values are pointers, too. If tipe is nil, values are decoded into an interface{},
+// with maps as map[string]interface{} and strings as string like the json codec does.
+// Fields of type interface{} in typed values still decode strings as []byte and maps as map[interface{}]interface{},
+// like they did before untyped decoding was supported.
 func New(tipe interface{}) margaret.Codec {
 	ch := ugorjiCodec.MsgpackHandle{}
 	ch.Canonical = true
-
-	c := &codec{
-		handle: &ch,
-	}
 	if tipe == nil {
-		c.any = true
-		return c
+		ch.MapType = reflect.TypeOf(map[string]interface{}(nil))
+		// strings are written as raw bytes, read them back as strings
+		ch.RawToString = true
 	}
-	t := reflect.TypeOf(tipe)
-	isPtr := t.Kind() == reflect.Ptr
-	if isPtr {
-		t = t.Elem()
+
+	return &codec{
+		tipe:   reflect.TypeOf(tipe),
+		handle: &ch,
 	}
-	c.tipe = t
-	return c
 }
 
 type codec struct {
+	// nil for untyped decoding
 	tipe   reflect.Type
-	any    bool
 	handle *ugorjiCodec.MsgpackHandle
 }
 
@@ -70,7 +69,16 @@ type decoder struct {
 }
 
 func (dec *decoder) Decode() (interface{}, error) {
-	v := reflect.New(dec.tipe).Interface()
-	err := dec.dec.Decode(&v)
-	return reflect.ValueOf(v).Elem().Interface(), err
+	var ptr reflect.Value
+	if dec.tipe == nil {
+		var v interface{}
+		ptr = reflect.ValueOf(&v)
+	} else {
+		ptr = reflect.New(dec.tipe)
+	}
+
+	if err := dec.dec.Decode(ptr.Interface()); err != nil {
+		return nil, err
+	}
+	return ptr.Elem().Interface(), nil
 }
@@ -0,0 +1,72 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package msgpack_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/margaret/codec/msgpack"
+)
+
+type entry struct {
+	Name string
+	Blob []byte
+	Any  interface{}
+}
+
+// TestLegacyBlobs decodes values that were written before the codec supported untyped decoding.
+func TestLegacyBlobs(t *testing.T) {
+	r := require.New(t)
+
+	mustHex := func(s string) []byte {
+		b, err := hex.DecodeString(s)
+		r.NoError(err)
+		return b
+	}
+
+	// entry{Name: "a", Blob: []byte{1, 2}, Any: map[string]interface{}{"k": "v"}}
+	withMap := mustHex("83a3416e7981a16ba176a4426c6f62a20102a44e616d65a161")
+	// entry{Name: "a", Blob: []byte{1, 2}, Any: "str"}
+	withString := mustHex("83a3416e79a3737472a4426c6f62a20102a44e616d65a161")
+	// map[string]interface{}{"k": "v", "n": map[string]interface{}{"x": "y"}}
+	nested := mustHex("82a16ba176a16e81a178a179")
+
+	// interface{} fields of typed values decode like they used to
+	v, err := msgpack.New(entry{}).Unmarshal(withMap)
+	r.NoError(err)
+	r.Equal(entry{
+		Name: "a",
+		Blob: []byte{1, 2},
+		Any:  map[interface{}]interface{}{"k": []byte("v")},
+	}, v)
+
+	v, err = msgpack.New(map[string]interface{}{}).Unmarshal(nested)
+	r.NoError(err)
+	r.Equal(map[string]interface{}{
+		"k": []byte("v"),
+		"n": map[interface{}]interface{}{"x": []byte("y")},
+	}, v)
+
+	// pointer types used to decode into values, now they decode into pointers like with the json codec
+	v, err = msgpack.New(&entry{}).Unmarshal(withString)
+	r.NoError(err)
+	r.Equal(&entry{Name: "a", Blob: []byte{1, 2}, Any: []byte("str")}, v)
+
+	// untyped decoding used to panic, now strings are strings and maps have string keys
+	v, err = msgpack.New(nil).Unmarshal(nested)
+	r.NoError(err)
+	r.Equal(map[string]interface{}{
+		"k": "v",
+		"n": map[string]interface{}{"x": "y"},
+	}, v)
+
+	// a nil pointer used to panic
+	v, err = msgpack.New(&entry{}).Unmarshal([]byte{0xc0})
+	r.NoError(err)
+	r.Equal((*entry)(nil), v)
+}
@@ -0,0 +1,90 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package protobuf implements margaret.Codec for protocol buffer messages.
+//
+// Protocol buffers don't delimit themselves, so every message is prefixed with its length as a varint,
+// both by the encoder and by Marshal. That way a frame written by Marshal can be read back with a decoder, like offset2 does.
+package protobuf // import "github.com/ssbc/margaret/codec/protobuf"
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/internal/frame"
+)
+
+var (
+	marshalOpts = protodelim.MarshalOptions{
+		MarshalOptions: proto.MarshalOptions{Deterministic: true},
+	}
+
+	unmarshalOpts = protodelim.UnmarshalOptions{MaxSize: frame.MaxSize}
+)
+
+// New creates a protobuf codec that decodes into new messages of the same type as tipe.
+// The values passed to Marshal and the encoder have to be proto.Message, and decoded values are always pointers.
+func New(tipe proto.Message) margaret.Codec {
+	return &codec{tipe: tipe.ProtoReflect().Type()}
+}
+
+type codec struct {
+	tipe protoreflect.MessageType
+}
+
+func (c *codec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := c.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *codec) Unmarshal(data []byte) (interface{}, error) {
+	return c.NewDecoder(bytes.NewReader(data)).Decode()
+}
+
+func (c *codec) NewEncoder(w io.Writer) margaret.Encoder {
+	return &encoder{w: w}
+}
+
+func (c *codec) NewDecoder(r io.Reader) margaret.Decoder {
+	return &decoder{tipe: c.tipe, r: frame.NewReader(r)}
+}
+
+type encoder struct {
+	w io.Writer
+}
+
+func (enc *encoder) Encode(v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf codec: expected proto.Message, got %T", v)
+	}
+
+	if _, err := marshalOpts.MarshalTo(enc.w, msg); err != nil {
+		return fmt.Errorf("protobuf codec: encode failed: %w", err)
+	}
+	return nil
+}
+
+type decoder struct {
+	tipe protoreflect.MessageType
+	r    protodelim.Reader
+}
+
+func (dec *decoder) Decode() (interface{}, error) {
+	msg := dec.tipe.New().Interface()
+
+	if err := unmarshalOpts.UnmarshalFrom(dec.r, msg); err != nil {
+		return nil, frame.WrapStart(err, "protobuf codec: decode failed")
+	}
+	return msg, nil
+}
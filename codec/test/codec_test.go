@@ -0,0 +1,15 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package test
+
+import (
+	"testing"
+
+	mtest "github.com/ssbc/margaret/test"
+)
+
+func TestCodecs(t *testing.T) {
+	mtest.RunCodecTests(t)
+}
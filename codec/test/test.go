@@ -0,0 +1,137 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package test
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/codec/cbor"
+	"github.com/ssbc/margaret/codec/compress"
+	"github.com/ssbc/margaret/codec/encrypt"
+	"github.com/ssbc/margaret/codec/json"
+	"github.com/ssbc/margaret/codec/migrate"
+	"github.com/ssbc/margaret/codec/msgpack"
+	"github.com/ssbc/margaret/codec/protobuf"
+	"github.com/ssbc/margaret/codec/union"
+	mtest "github.com/ssbc/margaret/test"
+)
+
+func newProtobuf(tipe interface{}) margaret.Codec {
+	return protobuf.New(tipe.(proto.Message))
+}
+
+// newMigrated returns a codec with two versions, the values of the first one are strings and upgraded by parsing them.
+func newMigrated(tipe interface{}) margaret.Codec {
+	reg := migrate.NewRegistry()
+	if err := reg.Register(1, json.New(""), func(v interface{}) (interface{}, error) {
+		return strconv.Atoi(v.(string))
+	}); err != nil {
+		panic(err)
+	}
+	if err := reg.Register(2, json.New(tipe), nil); err != nil {
+		panic(err)
+	}
+	c, err := reg.Codec()
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+type unionPost struct {
+	Text string
+}
+
+// newUnion returns a codec for ints, strings and posts, ignoring tipe.
+func newUnion(interface{}) margaret.Codec {
+	reg := union.NewRegistry()
+	for tag, tipe := range map[string]interface{}{"int": 0, "string": "", "post": unionPost{}} {
+		if err := reg.Register(tag, tipe); err != nil {
+			panic(err)
+		}
+	}
+	c, err := reg.Codec(json.New)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func mustStruct(m map[string]interface{}) *structpb.Struct {
+	s, err := structpb.NewStruct(m)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func init() {
+	ints := []interface{}{0, 1, -1, 42, 1 << 40}
+
+	mtest.RegisterGenericCodec("json", json.New)
+	mtest.RegisterGenericCodec("cbor", cbor.New)
+	mtest.RegisterGenericCodec("msgpack", msgpack.New)
+	mtest.RegisterGenericCodec("compress/zstd", mtest.CompressedCodec(json.New, compress.Zstd, nil))
+	mtest.RegisterGenericCodec("compress/snappy", mtest.CompressedCodec(json.New, compress.Snappy, nil))
+	mtest.RegisterGenericCodec("encrypt/xchacha20poly1305", mtest.EncryptedCodec(json.New, encrypt.XChaCha20Poly1305))
+
+	mtest.RegisterCodec("json", json.New, 0, ints...)
+	mtest.RegisterCodec("cbor", cbor.New, 0, ints...)
+	mtest.RegisterCodec("msgpack", msgpack.New, 0, ints...)
+
+	texts := []interface{}{
+		"",
+		"short",
+		strings.Repeat("a log entry that repeats itself, ", 20),
+		`{"type":"post","text":"hello world","mentions":["@alice","@bob"]}`,
+	}
+	// the dictionary needs samples that are alike, but not too much
+	rand := rand.New(rand.NewSource(1))
+	words := strings.Fields("hello world log entry the of and a sunny day today sequence offset")
+	samples := make([][]byte, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		text := make([]string, 5+rand.Intn(20))
+		for j := range text {
+			text[j] = words[rand.Intn(len(words))]
+		}
+		entry := fmt.Sprintf(`{"author":"@%08x","sequence":%d,"content":{"type":"post","text":%q}}`, rand.Uint32(), i, strings.Join(text, " "))
+		samples = append(samples, []byte(fmt.Sprintf("%q", entry)))
+	}
+	dict, err := compress.TrainDict(samples, compress.DefaultDictSize)
+	if err != nil {
+		panic(err)
+	}
+
+	mtest.RegisterCodec("compress/none", mtest.CompressedCodec(json.New, compress.None, nil), "", texts...)
+	mtest.RegisterCodec("compress/zstd", mtest.CompressedCodec(json.New, compress.Zstd, nil), "", texts...)
+	mtest.RegisterCodec("compress/zstd-dict", mtest.CompressedCodec(json.New, compress.Zstd, dict), "", texts...)
+	mtest.RegisterCodec("compress/snappy", mtest.CompressedCodec(json.New, compress.Snappy, nil), "", texts...)
+
+	mtest.RegisterCodec("migrate", newMigrated, 0, ints...)
+
+	mtest.RegisterCodec("union", newUnion, nil, 1, "two", unionPost{Text: "three"}, "", 0)
+
+	mtest.RegisterCodec("encrypt/xchacha20poly1305", mtest.EncryptedCodec(json.New, encrypt.XChaCha20Poly1305), "", texts...)
+	mtest.RegisterCodec("encrypt/aes-gcm", mtest.EncryptedCodec(json.New, encrypt.AESGCM), "", texts...)
+
+	mtest.RegisterCodec("protobuf/string", newProtobuf, &wrapperspb.StringValue{},
+		wrapperspb.String("hello"),
+		wrapperspb.String(""),
+		wrapperspb.String("🦀 multi-byte"),
+	)
+	mtest.RegisterCodec("protobuf/struct", newProtobuf, &structpb.Struct{},
+		mustStruct(map[string]interface{}{"type": "post", "text": "hi", "seq": 3}),
+		mustStruct(map[string]interface{}{}),
+		mustStruct(map[string]interface{}{"nested": map[string]interface{}{"list": []interface{}{1, "two", true, nil}}}),
+	)
+}
@@ -0,0 +1,172 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package union implements a margaret.Codec for logs with entries of several types.
+//
+// Each frame starts with the tag of the type of the value and the length of the encoded value.
+// The values themselves are encoded by an inner codec for each type, like the ones in margaret/codec,
+// so they decode into the registered Go type instead of a map.
+package union // import "github.com/ssbc/margaret/codec/union"
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/internal/frame"
+)
+
+var (
+	// ErrUnknownType is returned when encoding a value whose type isn't registered.
+	ErrUnknownType = errors.New("union: unknown type")
+
+	// ErrUnknownTag is returned when decoding a frame whose tag isn't registered.
+	ErrUnknownTag = errors.New("union: unknown tag")
+)
+
+// Registry holds the types of a union and their tags.
+type Registry struct {
+	l      sync.Mutex
+	byTag  map[string]reflect.Type
+	byType map[reflect.Type]string
+	tipes  map[string]interface{}
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		byTag:  make(map[string]reflect.Type),
+		byType: make(map[reflect.Type]string),
+		tipes:  make(map[string]interface{}),
+	}
+}
+
+// Register adds the type of tipe under tag. Values are decoded as the type of tipe, so register a pointer to get pointers back.
+// The tag is stored with every value and must not change once values were written with it.
+func (r *Registry) Register(tag string, tipe interface{}) error {
+	if tag == "" {
+		return errors.New("union: empty tag")
+	}
+	if tipe == nil {
+		return errors.New("union: can't register nil")
+	}
+	t := reflect.TypeOf(tipe)
+
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	if _, ok := r.byTag[tag]; ok {
+		return fmt.Errorf("union: tag %q registered twice", tag)
+	}
+	if other, ok := r.byType[t]; ok {
+		return fmt.Errorf("union: type %s already registered as %q", t, other)
+	}
+	r.byTag[tag] = t
+	r.byType[t] = tag
+	r.tipes[tag] = tipe
+	return nil
+}
+
+// Codec returns a codec for the registered types. newCodec is called for each of them, e.g. json.New.
+// Changes to the registry after this call don't affect the returned codec.
+func (r *Registry) Codec(newCodec func(tipe interface{}) margaret.Codec) (margaret.Codec, error) {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	if len(r.tipes) == 0 {
+		return nil, errors.New("union: no types registered")
+	}
+
+	c := &codec{
+		byTag:  make(map[string]margaret.Codec, len(r.tipes)),
+		byType: make(map[reflect.Type]string, len(r.tipes)),
+	}
+	for tag, tipe := range r.tipes {
+		c.byTag[tag] = newCodec(tipe)
+		c.byType[reflect.TypeOf(tipe)] = tag
+	}
+	return c, nil
+}
+
+type codec struct {
+	byTag  map[string]margaret.Codec
+	byType map[reflect.Type]string
+}
+
+func (c *codec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := c.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *codec) Unmarshal(data []byte) (interface{}, error) {
+	return c.NewDecoder(bytes.NewReader(data)).Decode()
+}
+
+func (c *codec) NewEncoder(w io.Writer) margaret.Encoder {
+	return &encoder{c: c, w: w}
+}
+
+func (c *codec) NewDecoder(r io.Reader) margaret.Decoder {
+	return &decoder{c: c, r: frame.NewReader(r)}
+}
+
+type encoder struct {
+	c *codec
+	w io.Writer
+}
+
+func (enc *encoder) Encode(v interface{}) error {
+	tag, ok := enc.c.byType[reflect.TypeOf(v)]
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrUnknownType, v)
+	}
+
+	data, err := enc.c.byTag[tag].Marshal(v)
+	if err != nil {
+		return fmt.Errorf("union: error encoding %q value: %w", tag, err)
+	}
+
+	if _, err := enc.w.Write(frame.Append(frame.Append(nil, []byte(tag)), data)); err != nil {
+		return fmt.Errorf("union: error writing frame: %w", err)
+	}
+	return nil
+}
+
+// maxTagSize limits the size of tags read from frames.
+const maxTagSize = 1 << 10
+
+type decoder struct {
+	c *codec
+	r frame.ByteReader
+}
+
+func (dec *decoder) Decode() (interface{}, error) {
+	tag, err := frame.ReadStart(dec.r, maxTagSize)
+	if err != nil {
+		return nil, frame.WrapStart(err, "union: error reading tag")
+	}
+
+	data, err := frame.Read(dec.r, frame.MaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("union: %w", err)
+	}
+
+	inner, ok := dec.c.byTag[string(tag)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownTag, tag)
+	}
+
+	v, err := inner.Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("union: error decoding %q value: %w", tag, err)
+	}
+	return v, nil
+}
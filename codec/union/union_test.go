@@ -0,0 +1,121 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package union_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/codec/cbor"
+	"github.com/ssbc/margaret/codec/json"
+	"github.com/ssbc/margaret/codec/msgpack"
+	"github.com/ssbc/margaret/codec/union"
+	"github.com/ssbc/margaret/offset2"
+)
+
+type post struct {
+	Text string
+}
+
+type contact struct {
+	Who       string
+	Following bool
+}
+
+func TestUnion(t *testing.T) {
+	inner := map[string]func(interface{}) margaret.Codec{
+		"json":    json.New,
+		"cbor":    cbor.New,
+		"msgpack": msgpack.New,
+	}
+
+	for name, newCodec := range inner {
+		t.Run(name, func(t *testing.T) {
+			r := require.New(t)
+
+			reg := union.NewRegistry()
+			r.NoError(reg.Register("post", post{}))
+			r.NoError(reg.Register("contact", contact{}))
+			r.NoError(reg.Register("seq", int64(0)))
+			codec, err := reg.Codec(newCodec)
+			r.NoError(err)
+
+			log, err := offset2.Open(t.TempDir(), codec)
+			r.NoError(err)
+			defer log.Close()
+
+			values := []interface{}{
+				post{Text: "hello"},
+				contact{Who: "@alice", Following: true},
+				int64(23),
+				post{Text: "world"},
+			}
+			for _, v := range values {
+				_, err := log.Append(v)
+				r.NoError(err)
+			}
+
+			for i, want := range values {
+				v, err := log.Get(int64(i))
+				r.NoError(err)
+				r.Equal(want, v, "entry %d", i)
+			}
+
+			_, err = log.Append("not registered")
+			r.True(errors.Is(err, union.ErrUnknownType), "unexpected error: %v", err)
+		})
+	}
+}
+
+func TestUnionPointers(t *testing.T) {
+	r := require.New(t)
+
+	reg := union.NewRegistry()
+	r.NoError(reg.Register("post", &post{}))
+	r.NoError(reg.Register("contact", contact{}))
+	codec, err := reg.Codec(json.New)
+	r.NoError(err)
+
+	data, err := codec.Marshal(&post{Text: "pointer"})
+	r.NoError(err)
+	v, err := codec.Unmarshal(data)
+	r.NoError(err)
+	r.Equal(&post{Text: "pointer"}, v)
+
+	// the value type of a registered pointer isn't registered itself
+	_, err = codec.Marshal(post{Text: "value"})
+	r.True(errors.Is(err, union.ErrUnknownType), "unexpected error: %v", err)
+}
+
+func TestUnionRegistry(t *testing.T) {
+	r := require.New(t)
+
+	_, err := union.NewRegistry().Codec(json.New)
+	r.Error(err, "empty registry")
+
+	reg := union.NewRegistry()
+	r.NoError(reg.Register("post", post{}))
+	r.Error(reg.Register("post", contact{}), "duplicate tag")
+	r.Error(reg.Register("other", post{}), "duplicate type")
+	r.Error(reg.Register("", contact{}), "empty tag")
+	r.Error(reg.Register("nil", nil), "nil type")
+
+	// frames with tags the codec doesn't know
+	codec, err := reg.Codec(json.New)
+	r.NoError(err)
+
+	other := union.NewRegistry()
+	r.NoError(other.Register("contact", contact{}))
+	otherCodec, err := other.Codec(json.New)
+	r.NoError(err)
+	data, err := otherCodec.Marshal(contact{Who: "@bob"})
+	r.NoError(err)
+
+	_, err = codec.Unmarshal(data)
+	r.True(errors.Is(err, union.ErrUnknownTag), "unexpected error: %v", err)
+}
@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package margaret
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ssbc/go-luigi"
+)
+
+// ProgressFunc is called by CopyWithProgress after every copied entry,
+// with the number of entries copied so far and the sequence number the last one got in the destination log.
+type ProgressFunc func(copied, seq int64)
+
+// Copy appends the entries of src that match specs to dst and returns how many it copied.
+// See CopyWithProgress for the details.
+func Copy(dst, src Log, specs ...QuerySpec) (int64, error) {
+	return CopyWithProgress(context.Background(), dst, src, nil, specs...)
+}
+
+// CopyWithProgress appends the entries of src that match specs to dst and returns how many it copied.
+// progress is called after each entry and may be nil.
+//
+// Nulled entries of src are copied as nulled entries, so that both logs keep the same sequence numbers.
+// This needs dst to be an Alterer, otherwise copying stops with an error wrapping ErrNulled.
+// The values of src are always appended as they are, even if specs ask for them to be seqwrapped.
+// A live query only returns when ctx is canceled.
+func CopyWithProgress(ctx context.Context, dst, src Log, progress ProgressFunc, specs ...QuerySpec) (int64, error) {
+	// always ask for wrapped values, so they can be unwrapped the same way whatever specs ask for
+	qry, err := src.Query(MergeQuerySpec(specs...), SeqWrap(true))
+	if err != nil {
+		return 0, fmt.Errorf("margaret: copy: error querying source log: %w", err)
+	}
+
+	var copied int64
+	for {
+		v, err := qry.Next(ctx)
+		if luigi.IsEOS(err) {
+			return copied, nil
+		} else if err != nil {
+			return copied, fmt.Errorf("margaret: copy: error reading source log: %w", err)
+		}
+
+		// some logs return nulled entries without a sequence number, even if they were asked to wrap them
+		if sw, ok := v.(SeqWrapper); ok {
+			v = sw.Value()
+		}
+
+		var seq int64
+		if err, ok := v.(error); ok && IsErrNulled(err) {
+			seq, err = copyNulled(dst)
+			if err != nil {
+				return copied, err
+			}
+		} else {
+			seq, err = dst.Append(v)
+			if err != nil {
+				return copied, fmt.Errorf("margaret: copy: error appending to destination log: %w", err)
+			}
+		}
+
+		copied++
+		if progress != nil {
+			progress(copied, seq)
+		}
+	}
+}
+
+// copyNulled appends a placeholder to dst and nulls it.
+func copyNulled(dst Log) (int64, error) {
+	alterer, ok := dst.(Alterer)
+	if !ok {
+		return SeqErrored, fmt.Errorf("margaret: copy: destination log can't hold nulled entries: %w", ErrNulled)
+	}
+
+	seq, err := dst.Append(nil)
+	if err != nil {
+		return SeqErrored, fmt.Errorf("margaret: copy: error appending nulled entry to destination log: %w", err)
+	}
+
+	if err := alterer.Null(seq); err != nil {
+		return SeqErrored, fmt.Errorf("margaret: copy: error nulling entry %d in destination log: %w", seq, err)
+	}
+	return seq, nil
+}
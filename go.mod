@@ -8,6 +8,7 @@ module github.com/ssbc/margaret
 
 require (
 	github.com/cespare/xxhash/v2 v2.1.2 // indirect
+	github.com/cockroachdb/pebble v0.0.0-20221117233435-4ddacdaf26f5
 	github.com/dgraph-io/badger/v3 v3.2103.3
 	github.com/dgraph-io/sroar v0.0.0-20220527172339-b92b7eaaf6e0
 	github.com/go-logfmt/logfmt v0.5.1 // indirect
@@ -19,7 +20,7 @@ require (
 	github.com/hashicorp/errwrap v1.1.0 // indirect
 	github.com/hashicorp/go-multierror v1.1.1 // indirect
 	github.com/keks/persist v0.0.0-20210520094901-9bdd97c1fad2
-	github.com/klauspost/compress v1.15.12 // indirect
+	github.com/klauspost/compress v1.17.0
 	github.com/mattn/go-sqlite3 v1.14.16
 	github.com/maxbrunsfeld/counterfeiter/v6 v6.2.2
 	github.com/pkg/errors v0.9.1
@@ -27,10 +28,12 @@ require (
 	github.com/ssbc/go-luigi v0.3.7-0.20221019204020-324065b9a7c6
 	github.com/stretchr/testify v1.8.1
 	github.com/ugorji/go/codec v1.2.7
+	go.etcd.io/bbolt v1.3.6
 	go.mindeco.de v1.12.0
 	go.opencensus.io v0.23.0 // indirect
+	golang.org/x/crypto v0.21.0
 	golang.org/x/net v0.23.0 // indirect
-	google.golang.org/protobuf v1.33.0 // indirect
+	google.golang.org/protobuf v1.33.0
 	modernc.org/fileutil v1.1.1 // indirect
 	modernc.org/internal v1.0.5 // indirect
 	modernc.org/kv v1.0.4
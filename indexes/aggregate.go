@@ -0,0 +1,172 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package indexes
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/ssbc/go-luigi"
+	"github.com/ssbc/margaret"
+)
+
+// Aggregator changes int64 values relative to what is stored, without a separate read by the caller.
+// Unset values count as zero for Add. Max and Min store v if the address is unset.
+type Aggregator interface {
+	// Add adds delta to the value at addr. This works for counters and sums.
+	Add(ctx context.Context, addr Addr, delta int64) error
+
+	// Max stores v at addr if it is greater than the current value.
+	Max(ctx context.Context, addr Addr, v int64) error
+
+	// Min stores v at addr if it is less than the current value.
+	Min(ctx context.Context, addr Addr, v int64) error
+}
+
+// Increment adds one to the counter at addr.
+func Increment(ctx context.Context, agg Aggregator, addr Addr) error {
+	return agg.Add(ctx, addr, 1)
+}
+
+// Decrement subtracts one from the counter at addr.
+func Decrement(ctx context.Context, agg Aggregator, addr Addr) error {
+	return agg.Add(ctx, addr, -1)
+}
+
+// AggregateIndex is an index of int64 values that are updated with an Aggregator.
+// The observables returned by Get hold an int64 or UnsetValue.
+//
+// Aggregations aren't idempotent, processing a log entry twice counts it twice.
+// Apply therefore stores the changes of an entry together with its sequence,
+// and Reset clears all values when the index needs to be rebuilt from scratch.
+type AggregateIndex interface {
+	Index
+	Aggregator
+
+	// Apply calls f and stores all its changes atomically together with seq as the new sequence of the index.
+	// If f returns an error, none of the changes are stored.
+	Apply(ctx context.Context, seq int64, f func(Aggregator) error) error
+
+	// Reset removes all values and sets the sequence to margaret.SeqEmpty.
+	Reset() error
+
+	GetSeq() (int64, error)
+
+	Flush() error
+
+	io.Closer
+}
+
+// AggregateProcFunc is like StreamProcFunc for aggregate indexes.
+type AggregateProcFunc func(context.Context, int64, interface{}, Aggregator) error
+
+// NewAggregateSinkIndex returns a sink index that updates idx by calling f for each log entry.
+// Entries up to the stored sequence of the index are skipped, so that they are never counted twice.
+func NewAggregateSinkIndex(f AggregateProcFunc, idx AggregateIndex) SinkIndex {
+	return &aggSinkIndex{
+		idx: idx,
+		f:   f,
+	}
+}
+
+type aggSinkIndex struct {
+	idx AggregateIndex
+	f   AggregateProcFunc
+}
+
+func (si *aggSinkIndex) QuerySpec() margaret.QuerySpec {
+	seq, err := si.idx.GetSeq()
+	if err != nil {
+		return margaret.ErrorQuerySpec(err)
+	}
+
+	return margaret.MergeQuerySpec(margaret.Gt(seq), margaret.SeqWrap(true))
+}
+
+func (si *aggSinkIndex) Pour(ctx context.Context, v interface{}) error {
+	switch tv := v.(type) {
+	case margaret.SeqWrapper:
+		seq, err := si.idx.GetSeq()
+		if err != nil {
+			return fmt.Errorf("error getting sequence number: %w", err)
+		}
+		if tv.Seq() <= seq {
+			// already aggregated
+			return nil
+		}
+
+		err = si.idx.Apply(ctx, tv.Seq(), func(agg Aggregator) error {
+			return si.f(ctx, tv.Seq(), tv.Value(), agg)
+		})
+		if err != nil {
+			return fmt.Errorf("error calling aggregate func: %w", err)
+		}
+		return nil
+	case error:
+		if margaret.IsErrNulled(tv) {
+			return nil
+		}
+		return tv
+
+	default:
+		return fmt.Errorf("expecting seqwrapped value (%T)", v)
+	}
+}
+
+func (si *aggSinkIndex) Close() error {
+	return si.idx.Close()
+}
+
+func (si *aggSinkIndex) Get(ctx context.Context, a Addr) (luigi.Observable, error) {
+	return si.idx.Get(ctx, a)
+}
+
+// AggregateOp is one of the operations of an Aggregator.
+type AggregateOp uint8
+
+const (
+	AggregateAdd AggregateOp = iota
+	AggregateMax
+	AggregateMin
+)
+
+// Apply returns the new value of an address after the operation with v.
+// has is false if the address is unset.
+func (op AggregateOp) Apply(cur int64, has bool, v int64) int64 {
+	if !has {
+		return v
+	}
+	switch op {
+	case AggregateMax:
+		if v > cur {
+			return v
+		}
+		return cur
+	case AggregateMin:
+		if v < cur {
+			return v
+		}
+		return cur
+	default:
+		return cur + v
+	}
+}
+
+// EncodeAggregate encodes an aggregated value for storage.
+func EncodeAggregate(v int64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(v))
+	return buf
+}
+
+// DecodeAggregate decodes a value encoded with EncodeAggregate.
+func DecodeAggregate(data []byte) (int64, error) {
+	if len(data) != 8 {
+		return 0, fmt.Errorf("indexes: expected aggregate of length 8, got %d", len(data))
+	}
+	return int64(binary.BigEndian.Uint64(data)), nil
+}
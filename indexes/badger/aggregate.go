@@ -0,0 +1,256 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package badger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/ssbc/go-luigi"
+
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/indexes"
+)
+
+// NewAggregateIndex returns an aggregate index that stores its values in db, with keyPrefix in front of all of its keys.
+// Like with NewIndexWithKeyPrefix, the database is only closed by Close if the prefix is empty.
+// Reset drops all the keys with the prefix, which means all of db if it is empty.
+func NewAggregateIndex(db *badger.DB, keyPrefix []byte) indexes.AggregateIndex {
+	idx := &aggIndex{
+		db:        db,
+		keyPrefix: keyPrefix,
+		curSeq:    -2,
+	}
+	idx.obvs = indexes.NewObservableCache(&idx.l, indexes.DefaultCacheSize, idx.load)
+	return idx
+}
+
+type aggIndex struct {
+	l sync.Mutex
+
+	db        *badger.DB
+	keyPrefix []byte
+
+	obvs   *indexes.ObservableCache
+	curSeq int64
+}
+
+func (idx *aggIndex) dbKey(addr indexes.Addr) []byte {
+	k := make([]byte, 0, len(idx.keyPrefix)+len(addr))
+	k = append(k, idx.keyPrefix...)
+	return append(k, addr...)
+}
+
+func (idx *aggIndex) Flush() error { return nil }
+
+func (idx *aggIndex) Close() error {
+	if len(idx.keyPrefix) == 0 {
+		if err := idx.db.Close(); err != nil {
+			return fmt.Errorf("margaret/indexes/badger: failed to close backing store: %w", err)
+		}
+	}
+	return nil
+}
+
+func (idx *aggIndex) Get(ctx context.Context, addr indexes.Addr) (luigi.Observable, error) {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	return idx.obvs.Get(addr)
+}
+
+// load reads the value of addr from the database. Take the lock first!
+func (idx *aggIndex) load(addr indexes.Addr) (interface{}, error) {
+	var v interface{} = indexes.UnsetValue{Addr: addr}
+	err := idx.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(idx.dbKey(addr))
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("error getting item: %w", err)
+		}
+
+		return item.Value(func(data []byte) error {
+			val, err := indexes.DecodeAggregate(data)
+			v = val
+			return err
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in badger transaction (view): %w", err)
+	}
+	return v, nil
+}
+
+func (idx *aggIndex) Add(ctx context.Context, addr indexes.Addr, delta int64) error {
+	return idx.update(addr, indexes.AggregateAdd, delta)
+}
+
+func (idx *aggIndex) Max(ctx context.Context, addr indexes.Addr, v int64) error {
+	return idx.update(addr, indexes.AggregateMax, v)
+}
+
+func (idx *aggIndex) Min(ctx context.Context, addr indexes.Addr, v int64) error {
+	return idx.update(addr, indexes.AggregateMin, v)
+}
+
+// update applies a single operation in its own transaction
+func (idx *aggIndex) update(addr indexes.Addr, op indexes.AggregateOp, v int64) error {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	agg := idx.newTxnAggregator()
+	err := idx.db.Update(func(txn *badger.Txn) error {
+		agg.txn = txn
+		return agg.apply(addr, op, v)
+	})
+	if err != nil {
+		return fmt.Errorf("error in badger transaction (update): %w", err)
+	}
+	return idx.publish(agg.changed)
+}
+
+func (idx *aggIndex) Apply(ctx context.Context, seq int64, f func(indexes.Aggregator) error) error {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	agg := idx.newTxnAggregator()
+	err := idx.db.Update(func(txn *badger.Txn) error {
+		agg.txn = txn
+		if err := f(agg); err != nil {
+			return err
+		}
+
+		err := txn.Set(idx.dbKey(indexes.Addr(currentSeqAddr)), indexes.EncodeAggregate(seq))
+		if err != nil {
+			return fmt.Errorf("error setting seq: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error in badger transaction (update): %w", err)
+	}
+	idx.curSeq = seq
+	return idx.publish(agg.changed)
+}
+
+// publish sets the new values in the observables. Take the lock first!
+func (idx *aggIndex) publish(changed map[indexes.Addr]int64) error {
+	for addr, v := range changed {
+		if err := idx.obvs.Set(addr, v); err != nil {
+			return fmt.Errorf("error setting value in observable: %w", err)
+		}
+	}
+	return nil
+}
+
+func (idx *aggIndex) GetSeq() (int64, error) {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	if idx.curSeq != -2 {
+		return idx.curSeq, nil
+	}
+
+	seq := int64(margaret.SeqEmpty)
+	err := idx.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(idx.dbKey(indexes.Addr(currentSeqAddr)))
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("error getting item: %w", err)
+		}
+
+		return item.Value(func(data []byte) error {
+			seq, err = indexes.DecodeAggregate(data)
+			return err
+		})
+	})
+	if err != nil {
+		return margaret.SeqErrored, fmt.Errorf("error in badger transaction (view): %w", err)
+	}
+
+	idx.curSeq = seq
+	return seq, nil
+}
+
+func (idx *aggIndex) Reset() error {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	var err error
+	if len(idx.keyPrefix) == 0 {
+		err = idx.db.DropAll()
+	} else {
+		err = idx.db.DropPrefix(idx.keyPrefix)
+	}
+	if err != nil {
+		return fmt.Errorf("error dropping aggregated values: %w", err)
+	}
+	idx.curSeq = margaret.SeqEmpty
+
+	if err := idx.obvs.Reset(); err != nil {
+		return fmt.Errorf("error setting value in observable: %w", err)
+	}
+	return nil
+}
+
+// txnAggregator applies the operations inside of a badger transaction and remembers the changed values.
+type txnAggregator struct {
+	idx     *aggIndex
+	txn     *badger.Txn
+	changed map[indexes.Addr]int64
+}
+
+func (idx *aggIndex) newTxnAggregator() *txnAggregator {
+	return &txnAggregator{
+		idx:     idx,
+		changed: make(map[indexes.Addr]int64),
+	}
+}
+
+func (agg *txnAggregator) Add(ctx context.Context, addr indexes.Addr, delta int64) error {
+	return agg.apply(addr, indexes.AggregateAdd, delta)
+}
+
+func (agg *txnAggregator) Max(ctx context.Context, addr indexes.Addr, v int64) error {
+	return agg.apply(addr, indexes.AggregateMax, v)
+}
+
+func (agg *txnAggregator) Min(ctx context.Context, addr indexes.Addr, v int64) error {
+	return agg.apply(addr, indexes.AggregateMin, v)
+}
+
+func (agg *txnAggregator) apply(addr indexes.Addr, op indexes.AggregateOp, v int64) error {
+	key := agg.idx.dbKey(addr)
+
+	var (
+		cur int64
+		has bool
+	)
+	item, err := agg.txn.Get(key)
+	if err == nil {
+		has = true
+		err = item.Value(func(data []byte) error {
+			cur, err = indexes.DecodeAggregate(data)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error getting value of %q: %w", addr, err)
+		}
+	} else if !errors.Is(err, badger.ErrKeyNotFound) {
+		return fmt.Errorf("error getting item: %w", err)
+	}
+
+	next := op.Apply(cur, has, v)
+	if err := agg.txn.Set(key, indexes.EncodeAggregate(next)); err != nil {
+		return fmt.Errorf("error setting value of %q: %w", addr, err)
+	}
+	agg.changed[addr] = next
+	return nil
+}
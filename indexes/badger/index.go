@@ -6,15 +6,12 @@ package badger // import "github.com/ssbc/margaret/indexes/badger"
 
 import (
 	"context"
-	"encoding"
 	"encoding/binary"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"math"
 	"os"
-	"reflect"
 	"strconv"
 	"sync"
 	"time"
@@ -64,20 +61,27 @@ type index struct {
 	db        *badger.DB
 	keyPrefix []byte
 
-	obvs   map[indexes.Addr]luigi.Observable
-	tipe   interface{}
+	obvs   *indexes.ObservableCache
+	codec  margaret.Codec
 	curSeq int64
 }
 
+// NewIndex returns an index that stores values of type tipe using indexes.NewDefaultCodec.
 func NewIndex(db *badger.DB, tipe interface{}) indexes.SeqSetterIndex {
-	return newIndex(db, tipe, []byte{})
+	return newIndex(db, indexes.NewDefaultCodec(tipe), []byte{})
 }
 
 func NewIndexWithKeyPrefix(db *badger.DB, tipe interface{}, keyPrefix []byte) indexes.SeqSetterIndex {
-	return newIndex(db, tipe, keyPrefix)
+	return newIndex(db, indexes.NewDefaultCodec(tipe), keyPrefix)
 }
 
-func newIndex(db *badger.DB, tipe interface{}, keyPrefix []byte) indexes.SeqSetterIndex {
+// NewIndexWithCodec returns an index that encodes its values using codec, like the ones in margaret/codec.
+// keyPrefix can be empty, see NewIndexWithKeyPrefix.
+func NewIndexWithCodec(db *badger.DB, codec margaret.Codec, keyPrefix []byte) indexes.SeqSetterIndex {
+	return newIndex(db, codec, keyPrefix)
+}
+
+func newIndex(db *badger.DB, codec margaret.Codec, keyPrefix []byte) indexes.SeqSetterIndex {
 	ctx, cancel := context.WithCancel(context.TODO())
 	idx := &index{
 		stop:    cancel,
@@ -95,14 +99,22 @@ func newIndex(db *badger.DB, tipe interface{}, keyPrefix []byte) indexes.SeqSett
 		keyPrefix: keyPrefix,
 
 		db:     db,
-		tipe:   tipe,
-		obvs:   make(map[indexes.Addr]luigi.Observable),
+		codec:  codec,
 		curSeq: int64(-2),
 	}
+	idx.obvs = indexes.NewObservableCache(idx.l, indexes.DefaultCacheSize, idx.load)
+	openPrefixes.add(idx)
 	go idx.writeBatches()
 	return idx
 }
 
+// dbKey returns the key of addr in the database, with the key prefix in front of it.
+func (idx *index) dbKey(addr indexes.Addr) []byte {
+	k := make([]byte, 0, len(idx.keyPrefix)+len(addr))
+	k = append(k, idx.keyPrefix...)
+	return append(k, addr...)
+}
+
 func (idx *index) Flush() error {
 	idx.l.Lock()
 	defer idx.l.Unlock()
@@ -120,6 +132,7 @@ func (idx *index) Close() error {
 	idx.stop()
 	idx.tickIfFull.Stop()
 	idx.tickPersistAll.Stop()
+	openPrefixes.remove(idx)
 
 	err := idx.flushBatch()
 	if err != nil {
@@ -138,12 +151,15 @@ func (idx *index) Close() error {
 func (idx *index) flushBatch() error {
 	var raw = make([]byte, 8)
 	err := idx.db.Update(func(txn *badger.Txn) error {
-		useq := uint64(idx.curSeq)
-		binary.BigEndian.PutUint64(raw, useq)
+		// -2 means the sequence wasn't set or loaded yet
+		if idx.curSeq != -2 {
+			useq := uint64(idx.curSeq)
+			binary.BigEndian.PutUint64(raw, useq)
 
-		err := txn.Set([]byte("__current_observable"), raw)
-		if err != nil {
-			return fmt.Errorf("error setting seq: %w", err)
+			err := txn.Set(idx.dbKey(indexes.Addr(currentSeqAddr)), raw)
+			if err != nil {
+				return fmt.Errorf("error setting seq: %w", err)
+			}
 		}
 
 		for bi, op := range idx.nextbatch {
@@ -208,95 +224,51 @@ func (idx *index) Get(ctx context.Context, addr indexes.Addr) (luigi.Observable,
 	idx.l.Lock()
 	defer idx.l.Unlock()
 
-	obv, ok := idx.obvs[addr]
-	if ok {
-		return obv, nil
-	}
+	return idx.obvs.Get(addr)
+}
 
-	if err := idx.flushBatch(); err != nil {
-		return nil, err
+// load reads the value of addr from the database. Take the lock first!
+func (idx *index) load(addr indexes.Addr) (interface{}, error) {
+	// a batched set of addr might not be written yet
+	if len(idx.nextbatch) > 0 {
+		if err := idx.flushBatch(); err != nil {
+			return nil, err
+		}
 	}
 
-	t := reflect.TypeOf(idx.tipe)
-	v := reflect.New(t).Interface()
-
+	var v interface{} = indexes.UnsetValue{Addr: addr}
 	err := idx.db.View(func(txn *badger.Txn) error {
-		dbKey := append(idx.keyPrefix, addr...)
-		item, err := txn.Get(dbKey)
-		if err != nil {
+		item, err := txn.Get(idx.dbKey(addr))
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil
+		} else if err != nil {
 			return fmt.Errorf("error getting item: %w", err)
 		}
 
 		err = item.Value(func(data []byte) error {
-			if um, ok := v.(encoding.BinaryUnmarshaler); ok {
-				if t.Kind() != reflect.Ptr {
-					v = reflect.ValueOf(v).Elem().Interface()
-				}
-
-				err = um.UnmarshalBinary(data)
-				return fmt.Errorf("error unmarshaling using custom marshaler: %w", err)
-			}
-
-			err = json.Unmarshal(data, v)
-			if err != nil {
-				return fmt.Errorf("error unmarshaling using json marshaler: %w", err)
-			}
-
-			if t.Kind() != reflect.Ptr {
-				v = reflect.ValueOf(v).Elem().Interface()
-			}
-			return nil
+			v, err = idx.codec.Unmarshal(data)
+			return err
 		})
 		if err != nil {
 			return fmt.Errorf("error getting value: %w", err)
 		}
-
-		return err
+		return nil
 	})
-
-	if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+	if err != nil {
 		return nil, fmt.Errorf("error in badger transaction (view): %w", err)
 	}
-
-	if errors.Is(err, badger.ErrKeyNotFound) {
-		obv = indexes.NewObservable(indexes.UnsetValue{Addr: addr}, idx.deleter(addr))
-	} else {
-		obv = indexes.NewObservable(v, idx.deleter(addr))
-	}
-
-	idx.obvs[addr] = obv
-
-	return roObv{obv}, nil
-}
-
-func (idx *index) deleter(addr indexes.Addr) func() {
-	return func() {
-		delete(idx.obvs, addr)
-	}
+	return v, nil
 }
 
 func (idx *index) Set(ctx context.Context, addr indexes.Addr, v interface{}) error {
-	var (
-		raw []byte
-		err error
-	)
-
-	if m, ok := v.(encoding.BinaryMarshaler); ok {
-		raw, err = m.MarshalBinary()
-		if err != nil {
-			return fmt.Errorf("error marshaling value using custom marshaler: %w", err)
-		}
-	} else {
-		raw, err = json.Marshal(v)
-		if err != nil {
-			return fmt.Errorf("error marshaling value using json marshaler: %w", err)
-		}
+	raw, err := idx.codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error encoding value of %q: %w", addr, err)
 	}
 	idx.l.Lock()
 	defer idx.l.Unlock()
-	dbKey := append(idx.keyPrefix, addr...)
 	batchedOp := setOp{
-		addr: dbKey,
+		addr: idx.dbKey(addr),
 		val:  raw,
 	}
 	idx.nextbatch = append(idx.nextbatch, batchedOp)
@@ -308,21 +280,25 @@ func (idx *index) Set(ctx context.Context, addr indexes.Addr, v interface{}) err
 		}
 	}
 
-	obv, ok := idx.obvs[addr]
-	if ok {
-		err = obv.Set(v)
-		if err != nil {
-			return fmt.Errorf("error setting value in observable: %w", err)
-		}
+	err = idx.obvs.Set(addr, v)
+	if err != nil {
+		return fmt.Errorf("error setting value in observable: %w", err)
 	}
 
 	return nil
 }
 
 func (idx *index) Delete(ctx context.Context, addr indexes.Addr) error {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	// otherwise a batched set of addr would be written after the delete
+	if err := idx.flushBatch(); err != nil {
+		return err
+	}
+
 	err := idx.db.Update(func(txn *badger.Txn) error {
-		dbKey := append(idx.keyPrefix, addr...)
-		err := txn.Delete(dbKey)
+		err := txn.Delete(idx.dbKey(addr))
 		if err != nil {
 			return fmt.Errorf("error deleting item: %w", err)
 		}
@@ -332,15 +308,9 @@ func (idx *index) Delete(ctx context.Context, addr indexes.Addr) error {
 		return fmt.Errorf("error in badger transaction (update): %w", err)
 	}
 
-	idx.l.Lock()
-	defer idx.l.Unlock()
-
-	obv, ok := idx.obvs[addr]
-	if ok {
-		err = obv.Set(indexes.UnsetValue{Addr: addr})
-		if err != nil {
-			return fmt.Errorf("error setting value in observable: %w", err)
-		}
+	err = idx.obvs.Set(addr, indexes.UnsetValue{Addr: addr})
+	if err != nil {
+		return fmt.Errorf("error setting value in observable: %w", err)
 	}
 
 	return nil
@@ -352,7 +322,7 @@ func (idx *index) SetSeq(seq int64) error {
 	idx.l.Lock()
 	defer idx.l.Unlock()
 
-	dbKey := append(idx.keyPrefix, currentSeqAddr...)
+	dbKey := idx.dbKey(indexes.Addr(currentSeqAddr))
 
 	raw := make([]byte, 8)
 	binary.BigEndian.PutUint64(raw, uint64(seq))
@@ -374,7 +344,7 @@ func (idx *index) SetSeq(seq int64) error {
 
 func (idx *index) GetSeq() (int64, error) {
 
-	dbKey := append(idx.keyPrefix, currentSeqAddr...)
+	dbKey := idx.dbKey(indexes.Addr(currentSeqAddr))
 
 	idx.l.Lock()
 	defer idx.l.Unlock()
@@ -0,0 +1,93 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package badger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/ssbc/go-luigi"
+
+	"github.com/ssbc/margaret/indexes"
+)
+
+var _ indexes.RangeIndex = (*index)(nil)
+
+// Range returns a source of indexes.KeyValue for the addresses in [from, to).
+// Pending batched writes are flushed before each page is read.
+func (idx *index) Range(ctx context.Context, from, to indexes.Addr, reverse bool, limit int) (luigi.Source, error) {
+	return indexes.NewRangeSource(idx.fetchRange, from, to, reverse, limit), nil
+}
+
+func (idx *index) fetchRange(from, to indexes.Addr, reverse bool, n int) ([]indexes.KeyValue, error) {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	if err := idx.flushBatch(); err != nil {
+		return nil, err
+	}
+
+	var kvs []indexes.KeyValue
+	err := idx.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Reverse = reverse
+		iter := txn.NewIterator(opts)
+		defer iter.Close()
+
+		if reverse {
+			var end []byte
+			if to != "" {
+				end = append(append([]byte{}, idx.keyPrefix...), to...)
+			} else if len(idx.keyPrefix) > 0 {
+				end = []byte(indexes.PrefixEnd(indexes.Addr(idx.keyPrefix)))
+			}
+
+			// in reverse mode seek finds the highest key that is less than or equal to end
+			if end == nil {
+				iter.Rewind()
+			} else {
+				iter.Seek(end)
+				// end is exclusive, and without a to it belongs to another index and fails the prefix check
+				if iter.Valid() && bytes.Equal(iter.Item().Key(), end) {
+					iter.Next()
+				}
+			}
+		} else {
+			iter.Seek(append(append([]byte{}, idx.keyPrefix...), from...))
+		}
+
+		for ; iter.ValidForPrefix(idx.keyPrefix) && len(kvs) < n; iter.Next() {
+			item := iter.Item()
+			addr := indexes.Addr(item.Key()[len(idx.keyPrefix):])
+
+			if !indexes.InRange(addr, from, to) {
+				break
+			}
+
+			if bytes.Equal([]byte(addr), currentSeqAddr) || bytes.Equal([]byte(addr), currentVersionAddr) {
+				continue
+			}
+
+			err := item.Value(func(data []byte) error {
+				v, err := idx.codec.Unmarshal(data)
+				if err != nil {
+					return err
+				}
+				kvs = append(kvs, indexes.KeyValue{Key: addr, Value: v})
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("error getting value of %q: %w", addr, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in badger transaction (view): %w", err)
+	}
+	return kvs, nil
+}
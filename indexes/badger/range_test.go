@@ -0,0 +1,52 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package badger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/ssbc/go-luigi"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/margaret/indexes"
+)
+
+func TestRangeReversePrefixEnd(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	db, err := badger.Open(badger.DefaultOptions(t.TempDir()).WithLogger(nil))
+	r.NoError(err)
+	defer db.Close()
+
+	idx := NewIndexWithKeyPrefix(db, "", []byte("a")).(*index)
+	for _, addr := range []indexes.Addr{"x", "y", "z"} {
+		r.NoError(idx.Set(ctx, addr, string(addr)))
+	}
+	r.NoError(idx.Flush())
+
+	// the key right after the prefix of the index, which a reverse range without an end seeks to
+	r.NoError(db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte("b"), []byte("other"))
+	}))
+
+	src, err := idx.Range(ctx, "", "", true, -1)
+	r.NoError(err)
+
+	var addrs []indexes.Addr
+	for {
+		v, err := src.Next(ctx)
+		if luigi.IsEOS(err) {
+			break
+		}
+		r.NoError(err)
+		addrs = append(addrs, v.(indexes.KeyValue).Key)
+	}
+	r.Equal([]indexes.Addr{"z", "y", "x"}, addrs)
+
+	r.NoError(idx.Close())
+}
@@ -0,0 +1,181 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package badger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/ssbc/go-luigi"
+
+	"github.com/ssbc/margaret/indexes"
+)
+
+// NewSetIndex returns a set index that stores its members in db, with keyPrefix in front of all of its keys.
+// Like with NewIndexWithKeyPrefix, the database is only closed by Close if the prefix is empty.
+func NewSetIndex(db *badger.DB, keyPrefix []byte) indexes.SetIndex {
+	idx := &setIndex{
+		db:        db,
+		keyPrefix: keyPrefix,
+	}
+	idx.obvs = indexes.NewObservableCache(&idx.l, indexes.DefaultCacheSize, idx.load)
+	return idx
+}
+
+type setIndex struct {
+	l sync.Mutex
+
+	db        *badger.DB
+	keyPrefix []byte
+
+	obvs *indexes.ObservableCache
+}
+
+func (idx *setIndex) Flush() error { return nil }
+
+func (idx *setIndex) Close() error {
+	if len(idx.keyPrefix) == 0 {
+		if err := idx.db.Close(); err != nil {
+			return fmt.Errorf("margaret/indexes/badger: failed to close backing store: %w", err)
+		}
+	}
+	return nil
+}
+
+func (idx *setIndex) memberKey(addr, member indexes.Addr) ([]byte, error) {
+	k, err := indexes.SetMemberKey(addr, member)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, idx.keyPrefix...), k...), nil
+}
+
+func (idx *setIndex) Add(ctx context.Context, addr, member indexes.Addr) error {
+	key, err := idx.memberKey(addr, member)
+	if err != nil {
+		return err
+	}
+
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	var added bool
+	err = idx.db.Update(func(txn *badger.Txn) error {
+		_, err := txn.Get(key)
+		if err == nil {
+			return nil
+		} else if !errors.Is(err, badger.ErrKeyNotFound) {
+			return fmt.Errorf("error getting member: %w", err)
+		}
+
+		added = true
+		return txn.Set(key, []byte{1})
+	})
+	if err != nil {
+		return fmt.Errorf("error in badger transaction (update): %w", err)
+	}
+
+	if added {
+		return idx.update(addr, func(ms indexes.Members) indexes.Members { return ms.With(member) })
+	}
+	return nil
+}
+
+func (idx *setIndex) Remove(ctx context.Context, addr, member indexes.Addr) error {
+	key, err := idx.memberKey(addr, member)
+	if err != nil {
+		return err
+	}
+
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	var removed bool
+	err = idx.db.Update(func(txn *badger.Txn) error {
+		_, err := txn.Get(key)
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("error getting member: %w", err)
+		}
+
+		removed = true
+		return txn.Delete(key)
+	})
+	if err != nil {
+		return fmt.Errorf("error in badger transaction (update): %w", err)
+	}
+
+	if removed {
+		return idx.update(addr, func(ms indexes.Members) indexes.Members { return ms.Without(member) })
+	}
+	return nil
+}
+
+// update changes the members of addr, if they are in memory. Take the lock first!
+func (idx *setIndex) update(addr indexes.Addr, change func(indexes.Members) indexes.Members) error {
+	err := idx.obvs.Update(addr, func(v interface{}) interface{} {
+		return change(v.(indexes.Members))
+	})
+	if err != nil {
+		return fmt.Errorf("error setting value in observable: %w", err)
+	}
+	return nil
+}
+
+func (idx *setIndex) Has(ctx context.Context, addr, member indexes.Addr) (bool, error) {
+	key, err := idx.memberKey(addr, member)
+	if err != nil {
+		return false, err
+	}
+
+	err = idx.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(key)
+		return err
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("error in badger transaction (view): %w", err)
+	}
+	return true, nil
+}
+
+func (idx *setIndex) Members(ctx context.Context, addr indexes.Addr) (luigi.Observable, error) {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	return idx.obvs.Get(addr)
+}
+
+// load reads the members of addr from the database. Take the lock first!
+func (idx *setIndex) load(addr indexes.Addr) (interface{}, error) {
+	setPrefix, err := indexes.SetKeyPrefix(addr)
+	if err != nil {
+		return nil, err
+	}
+	prefix := append(append([]byte{}, idx.keyPrefix...), setPrefix...)
+
+	members := indexes.Members{}
+	err = idx.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = prefix
+		iter := txn.NewIterator(opts)
+		defer iter.Close()
+
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			members = append(members, indexes.Addr(iter.Item().Key()[len(prefix):]))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in badger transaction (view): %w", err)
+	}
+	return members, nil
+}
@@ -14,6 +14,10 @@ import (
 
 	"github.com/dgraph-io/badger/v3"
 
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/codec/cbor"
+	"github.com/ssbc/margaret/codec/json"
+	"github.com/ssbc/margaret/codec/msgpack"
 	"github.com/ssbc/margaret/indexes"
 	libadger "github.com/ssbc/margaret/indexes/badger"
 	"github.com/ssbc/margaret/indexes/test"
@@ -36,13 +40,27 @@ func init() {
 		return libadger.NewIndex(db, tipe), nil
 	}
 
+	withCodec := func(newCodec func(interface{}) margaret.Codec) test.NewSeqSetterIndexFunc {
+		return func(name string, tipe interface{}) (indexes.SeqSetterIndex, error) {
+			dir := filepath.Join("testrun", name)
+			os.RemoveAll(dir)
+			os.MkdirAll(dir, 0700)
+
+			db, err := badger.Open(pbadger.BadgerOpts(dir))
+			if err != nil {
+				return nil, fmt.Errorf("error opening test database (%s): %w", dir, err)
+			}
+
+			return libadger.NewIndexWithCodec(db, newCodec(tipe), nil), nil
+		}
+	}
+
 	var (
 		initDB   sync.Once
 		sharedDB *badger.DB
 	)
 
-	newSharedSeqSetterIdx := func(name string, tipe interface{}) (indexes.SeqSetterIndex, error) {
-
+	openSharedDB := func() *badger.DB {
 		initDB.Do(func() {
 			dir := filepath.Join("testrun", "badger-shared")
 			os.RemoveAll(dir)
@@ -56,11 +74,51 @@ func init() {
 				panic(fmt.Errorf("error opening test database (%s): %w", dir, err))
 			}
 		})
+		return sharedDB
+	}
 
+	randomPrefix := func() []byte {
 		keyPrefix := make([]byte, 16)
 		rand.Read(keyPrefix)
+		return []byte(hex.EncodeToString(keyPrefix))
+	}
+
+	newSharedSeqSetterIdx := func(name string, tipe interface{}) (indexes.SeqSetterIndex, error) {
+		return libadger.NewIndexWithKeyPrefix(openSharedDB(), tipe, randomPrefix()), nil
+	}
+
+	newStandaloneSetIdx := func(name string) (indexes.SetIndex, error) {
+		dir := filepath.Join("testrun", name)
+		os.RemoveAll(dir)
+		os.MkdirAll(dir, 0700)
+
+		db, err := badger.Open(pbadger.BadgerOpts(dir))
+		if err != nil {
+			return nil, fmt.Errorf("error opening test database (%s): %w", dir, err)
+		}
 
-		return libadger.NewIndexWithKeyPrefix(sharedDB, tipe, []byte(hex.EncodeToString(keyPrefix))), nil
+		return libadger.NewSetIndex(db, nil), nil
+	}
+
+	newSharedSetIdx := func(name string) (indexes.SetIndex, error) {
+		return libadger.NewSetIndex(openSharedDB(), randomPrefix()), nil
+	}
+
+	newStandaloneAggIdx := func(name string) (indexes.AggregateIndex, error) {
+		dir := filepath.Join("testrun", name)
+		os.RemoveAll(dir)
+		os.MkdirAll(dir, 0700)
+
+		db, err := badger.Open(pbadger.BadgerOpts(dir))
+		if err != nil {
+			return nil, fmt.Errorf("error opening test database (%s): %w", dir, err)
+		}
+
+		return libadger.NewAggregateIndex(db, nil), nil
+	}
+
+	newSharedAggIdx := func(name string) (indexes.AggregateIndex, error) {
+		return libadger.NewAggregateIndex(openSharedDB(), randomPrefix()), nil
 	}
 
 	toSetterIdx := func(f test.NewSeqSetterIndexFunc) test.NewSetterIndexFunc {
@@ -75,4 +133,20 @@ func init() {
 
 	test.RegisterSeqSetterIndex("badger-shared", newSharedSeqSetterIdx)
 	test.RegisterSetterIndex("badger-shared", toSetterIdx(newSharedSeqSetterIdx))
+
+	for name, newCodec := range map[string]func(interface{}) margaret.Codec{
+		"json":    json.New,
+		"cbor":    cbor.New,
+		"msgpack": msgpack.New,
+	} {
+		newIdx := withCodec(newCodec)
+		test.RegisterSeqSetterIndex("badger-"+name, newIdx)
+		test.RegisterSetterIndex("badger-"+name, toSetterIdx(newIdx))
+	}
+
+	test.RegisterSetIndex("badger-standalone", newStandaloneSetIdx)
+	test.RegisterSetIndex("badger-shared", newSharedSetIdx)
+
+	test.RegisterAggregateIndex("badger-standalone", newStandaloneAggIdx)
+	test.RegisterAggregateIndex("badger-shared", newSharedAggIdx)
 }
@@ -0,0 +1,66 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package badger
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v3"
+
+	"github.com/ssbc/margaret/indexes"
+)
+
+var _ indexes.TxnIndex = (*index)(nil)
+
+// Begin starts a transaction. Its changes are written in a single badger transaction,
+// after the batched writes of the index.
+func (idx *index) Begin(context.Context) (indexes.Txn, error) {
+	return indexes.NewTxn(idx, idx.codec, idx.commitTxn), nil
+}
+
+// commitTxn writes the changes of a transaction and seq in one badger transaction.
+func (idx *index) commitTxn(ops []indexes.TxnOp, seq int64) error {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	// the batched writes are older, they need to go first
+	if err := idx.flushBatch(); err != nil {
+		return err
+	}
+
+	err := idx.db.Update(func(btxn *badger.Txn) error {
+		for i, op := range ops {
+			var err error
+			if op.Delete {
+				err = btxn.Delete(idx.dbKey(op.Addr))
+			} else {
+				err = btxn.Set(idx.dbKey(op.Addr), op.Data)
+			}
+			if err != nil {
+				return fmt.Errorf("error applying change #%d: %w", i, err)
+			}
+		}
+
+		raw := make([]byte, 8)
+		binary.BigEndian.PutUint64(raw, uint64(seq))
+		if err := btxn.Set(idx.dbKey(indexes.Addr(currentSeqAddr)), raw); err != nil {
+			return fmt.Errorf("error setting seq: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error in badger transaction (update): %w", err)
+	}
+	idx.curSeq = seq
+
+	for _, op := range ops {
+		if err := idx.obvs.Set(op.Addr, op.Value); err != nil {
+			return fmt.Errorf("error setting value in observable: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package badger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/dgraph-io/badger/v3"
+
+	"github.com/ssbc/margaret/indexes"
+)
+
+var _ indexes.VersionedIndex = (*index)(nil)
+
+var currentVersionAddr = []byte("__current_version")
+
+func (idx *index) GetVersion() (uint64, error) {
+	var version uint64
+	err := idx.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(idx.dbKey(indexes.Addr(currentVersionAddr)))
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("error getting item: %w", err)
+		}
+
+		return item.Value(func(data []byte) error {
+			if l := len(data); l != 8 {
+				return fmt.Errorf("expected data of length 8, got %v", l)
+			}
+			version = binary.BigEndian.Uint64(data)
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error in badger transaction (view): %w", err)
+	}
+	return version, nil
+}
+
+func (idx *index) SetVersion(version uint64) error {
+	raw := make([]byte, 8)
+	binary.BigEndian.PutUint64(raw, version)
+
+	err := idx.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(idx.dbKey(indexes.Addr(currentVersionAddr)), raw)
+	})
+	if err != nil {
+		return fmt.Errorf("error in badger transaction (update): %w", err)
+	}
+	return nil
+}
+
+// Reset deletes all the keys of the index.
+//
+// Keys are stored as the key prefix followed by the address, so the keys of another index on the same database
+// whose prefix starts with the one of this index look like keys of this index, too.
+// Reset leaves the keys of such indexes alone as long as they are open.
+// An index without a key prefix treats the whole database as its own, so it refuses to reset while other indexes use it.
+func (idx *index) Reset() error {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	others := openPrefixes.within(idx.db, idx.keyPrefix)
+	if len(idx.keyPrefix) == 0 && len(others) > 0 {
+		return errors.New("can't reset an index without key prefix while other indexes use its database")
+	}
+
+	idx.nextbatch = []setOp{}
+
+	if err := idx.dropKeys(others); err != nil {
+		return fmt.Errorf("error dropping index values: %w", err)
+	}
+	// reloaded as margaret.SeqEmpty by GetSeq
+	idx.curSeq = -2
+
+	if err := idx.obvs.Reset(); err != nil {
+		return fmt.Errorf("error setting value in observable: %w", err)
+	}
+	return nil
+}
+
+// dropKeys deletes the keys with the prefix of the index, except for those that start with one of skip.
+func (idx *index) dropKeys(skip [][]byte) error {
+	if len(idx.keyPrefix) == 0 {
+		return idx.db.DropAll()
+	}
+	if len(skip) == 0 {
+		return idx.db.DropPrefix(idx.keyPrefix)
+	}
+
+	var keys [][]byte
+	err := idx.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = idx.keyPrefix
+
+		iter := txn.NewIterator(opts)
+		defer iter.Close()
+
+	keys:
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			k := iter.Item().KeyCopy(nil)
+			for _, p := range skip {
+				if bytes.HasPrefix(k, p) {
+					continue keys
+				}
+			}
+			keys = append(keys, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error in badger transaction (view): %w", err)
+	}
+
+	batch := idx.db.NewWriteBatch()
+	defer batch.Cancel()
+	for _, k := range keys {
+		if err := batch.Delete(k); err != nil {
+			return fmt.Errorf("error deleting key: %w", err)
+		}
+	}
+	return batch.Flush()
+}
+
+// openPrefixes holds the key prefixes of the open indexes of every database.
+var openPrefixes = prefixRegistry{m: make(map[*badger.DB]map[*index][]byte)}
+
+type prefixRegistry struct {
+	l sync.Mutex
+	m map[*badger.DB]map[*index][]byte
+}
+
+func (reg *prefixRegistry) add(idx *index) {
+	reg.l.Lock()
+	defer reg.l.Unlock()
+
+	idxs, ok := reg.m[idx.db]
+	if !ok {
+		idxs = make(map[*index][]byte)
+		reg.m[idx.db] = idxs
+	}
+	idxs[idx] = idx.keyPrefix
+}
+
+func (reg *prefixRegistry) remove(idx *index) {
+	reg.l.Lock()
+	defer reg.l.Unlock()
+
+	idxs := reg.m[idx.db]
+	delete(idxs, idx)
+	if len(idxs) == 0 {
+		delete(reg.m, idx.db)
+	}
+}
+
+// within returns the prefixes of the open indexes of db that are longer than prefix and start with it.
+func (reg *prefixRegistry) within(db *badger.DB, prefix []byte) [][]byte {
+	reg.l.Lock()
+	defer reg.l.Unlock()
+
+	var ps [][]byte
+	for _, p := range reg.m[db] {
+		if len(p) > len(prefix) && bytes.HasPrefix(p, prefix) {
+			ps = append(ps, p)
+		}
+	}
+	return ps
+}
@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package badger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/margaret/indexes"
+)
+
+func TestResetSharedDB(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	db, err := badger.Open(badger.DefaultOptions(t.TempDir()).WithLogger(nil))
+	r.NoError(err)
+	defer db.Close()
+
+	// foobar starts with foo, so its keys look like keys of foo
+	foo := NewIndexWithKeyPrefix(db, "", []byte("foo")).(*index)
+	foobar := NewIndexWithKeyPrefix(db, "", []byte("foobar")).(*index)
+
+	r.NoError(foo.Set(ctx, "x", "foo x"))
+	r.NoError(foo.SetSeq(1))
+	r.NoError(foobar.Set(ctx, "y", "foobar y"))
+	r.NoError(foobar.SetSeq(2))
+	r.NoError(foo.Flush())
+	r.NoError(foobar.Flush())
+
+	// an index without prefix can't reset while others use the database
+	all := NewIndex(db, "").(*index)
+	r.Error(all.Reset())
+	openPrefixes.remove(all) // closing it would close db
+
+	r.NoError(foo.Reset())
+
+	get := func(idx *index, addr indexes.Addr) interface{} {
+		obv, err := idx.Get(ctx, addr)
+		r.NoError(err)
+		v, err := obv.Value()
+		r.NoError(err)
+		return v
+	}
+	r.Equal(indexes.UnsetValue{Addr: "x"}, get(foo, "x"))
+	seq, err := foo.GetSeq()
+	r.NoError(err)
+	r.EqualValues(-1, seq)
+
+	r.Equal("foobar y", get(foobar, "y"))
+	seq, err = foobar.GetSeq()
+	r.NoError(err)
+	r.EqualValues(2, seq)
+
+	r.NoError(foo.Close())
+	r.NoError(foobar.Close())
+}
@@ -0,0 +1,302 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package indexes
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+
+	"github.com/ssbc/go-luigi"
+)
+
+// DefaultCacheSize is the number of values of unobserved addresses that the indexes keep in memory.
+const DefaultCacheSize = 1024
+
+// LoadFunc loads the value of addr from the store of an index, or returns UnsetValue if there is none.
+type LoadFunc func(addr Addr) (interface{}, error)
+
+// ObservableCache holds the observables of an index, without growing with every address that was ever requested.
+//
+// Get returns a small read-only handle instead of the observable itself.
+// Only addresses with registered sinks get an observable, which is kept until the last registration is cancelled.
+// The values of the other addresses are kept in a least-recently-used cache of a fixed size,
+// and loaded again when they were evicted, so handles that are dropped by their users don't need to be tracked.
+//
+// The cache uses the lock of its index around calls to load, so that loads and updates are never reordered.
+// Get, Set, Update and Reset have to be called with that lock held.
+// The handles only take it when they have to load a value, so a sink can read values and register other sinks
+// while Set pours into it, as long as those addresses are observed or cached.
+// Loading an address from inside such a pour deadlocks, since the index lock is still held.
+type ObservableCache struct {
+	// lock of the index
+	idxLock sync.Locker
+	load    LoadFunc
+	size    int
+
+	// protects the fields below, only held briefly and never while calling into an observable
+	l sync.Mutex
+
+	observed map[Addr]*observedAddr
+
+	lru    *list.List // of *cachedValue, most recently used first
+	values map[Addr]*list.Element
+}
+
+type observedAddr struct {
+	obv luigi.Observable
+	v   interface{}
+	n   int // number of registrations
+}
+
+type cachedValue struct {
+	addr Addr
+	v    interface{}
+}
+
+// NewObservableCache returns a cache that uses load to get values and keeps up to size values of unobserved addresses.
+// l is the lock of the index that protects its store.
+func NewObservableCache(l sync.Locker, size int, load LoadFunc) *ObservableCache {
+	return &ObservableCache{
+		idxLock: l,
+		load:    load,
+		size:    size,
+
+		observed: make(map[Addr]*observedAddr),
+		lru:      list.New(),
+		values:   make(map[Addr]*list.Element),
+	}
+}
+
+// Get loads the value of addr, if it isn't cached, and returns a read-only observable for it.
+// Take the index lock first!
+func (c *ObservableCache) Get(addr Addr) (luigi.Observable, error) {
+	if _, err := c.value(addr); err != nil {
+		return nil, err
+	}
+	return cacheHandle{c: c, addr: addr}, nil
+}
+
+// Set stores v as the value of addr and updates the registered sinks.
+// Take the index lock first!
+func (c *ObservableCache) Set(addr Addr, v interface{}) error {
+	c.l.Lock()
+	o, ok := c.observed[addr]
+	if ok {
+		o.v = v
+	} else {
+		c.add(addr, v)
+	}
+	c.l.Unlock()
+
+	if ok {
+		// sinks might cancel their registration while this pours, so c.l must not be held
+		return o.obv.Set(v)
+	}
+	return nil
+}
+
+// Update replaces the value of addr with the result of f, if it is in memory.
+// Otherwise the new value is loaded from the store once it is needed.
+// Take the index lock first!
+func (c *ObservableCache) Update(addr Addr, f func(interface{}) interface{}) error {
+	c.l.Lock()
+	o, ok := c.observed[addr]
+	if !ok {
+		if el, ok := c.values[addr]; ok {
+			cv := el.Value.(*cachedValue)
+			cv.v = f(cv.v)
+		}
+		c.l.Unlock()
+		return nil
+	}
+	o.v = f(o.v)
+	v := o.v
+	c.l.Unlock()
+
+	return o.obv.Set(v)
+}
+
+// Reset sets all observed addresses to UnsetValue and forgets all cached values.
+// Take the index lock first!
+func (c *ObservableCache) Reset() error {
+	c.l.Lock()
+	c.lru.Init()
+	c.values = make(map[Addr]*list.Element)
+
+	observed := make(map[Addr]luigi.Observable, len(c.observed))
+	for addr, o := range c.observed {
+		o.v = UnsetValue{Addr: addr}
+		observed[addr] = o.obv
+	}
+	c.l.Unlock()
+
+	for addr, obv := range observed {
+		if err := obv.Set(UnsetValue{Addr: addr}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Len returns the number of addresses the cache holds in memory, observed or not.
+func (c *ObservableCache) Len() int {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	return len(c.observed) + c.lru.Len()
+}
+
+// value returns the value of addr from the cache or loads it. Take the index lock first!
+func (c *ObservableCache) value(addr Addr) (interface{}, error) {
+	if v, ok := c.cached(addr); ok {
+		return v, nil
+	}
+
+	v, err := c.load(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c.l.Lock()
+	c.add(addr, v)
+	c.l.Unlock()
+	return v, nil
+}
+
+// cached returns the value of addr if it is in memory. It doesn't need the index lock.
+func (c *ObservableCache) cached(addr Addr) (interface{}, bool) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	if o, ok := c.observed[addr]; ok {
+		return o.v, true
+	}
+	if el, ok := c.values[addr]; ok {
+		c.lru.MoveToFront(el)
+		return el.Value.(*cachedValue).v, true
+	}
+	return nil, false
+}
+
+// add puts v into the lru cache and evicts the oldest value if it is full. Take c.l first!
+func (c *ObservableCache) add(addr Addr, v interface{}) {
+	if el, ok := c.values[addr]; ok {
+		el.Value.(*cachedValue).v = v
+		c.lru.MoveToFront(el)
+		return
+	}
+	if c.size <= 0 {
+		return
+	}
+
+	c.values[addr] = c.lru.PushFront(&cachedValue{addr: addr, v: v})
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.values, oldest.Value.(*cachedValue).addr)
+	}
+}
+
+// register moves addr from the lru cache to the observed addresses and returns its observable.
+// It only takes the index lock if the value of addr has to be loaded.
+func (c *ObservableCache) register(addr Addr) (luigi.Observable, error) {
+	if obv, ok := c.observe(addr, nil, false); ok {
+		return obv, nil
+	}
+
+	c.idxLock.Lock()
+	defer c.idxLock.Unlock()
+
+	v, err := c.value(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	obv, _ := c.observe(addr, v, true)
+	return obv, nil
+}
+
+// observe counts a registration for addr and returns its observable.
+// If addr isn't observed yet, the observable starts with the cached value, or with v if loaded is set.
+// Otherwise observe returns false.
+func (c *ObservableCache) observe(addr Addr, v interface{}, loaded bool) (luigi.Observable, bool) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	o, ok := c.observed[addr]
+	if !ok {
+		if el, ok := c.values[addr]; ok {
+			v = el.Value.(*cachedValue).v
+			c.lru.Remove(el)
+			delete(c.values, addr)
+		} else if !loaded {
+			return nil, false
+		}
+
+		o = &observedAddr{obv: luigi.NewObservable(v), v: v}
+		c.observed[addr] = o
+	}
+	// counted before the sink is registered, so that a concurrent cancel doesn't drop the observable
+	o.n++
+	return o.obv, true
+}
+
+// unregister moves addr back to the lru cache once its last registration is cancelled.
+// It doesn't need the index lock, so that sinks can cancel while a value is poured into them.
+func (c *ObservableCache) unregister(addr Addr) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	o, ok := c.observed[addr]
+	if !ok {
+		return
+	}
+	o.n--
+	if o.n == 0 {
+		delete(c.observed, addr)
+		c.add(addr, o.v)
+	}
+}
+
+// cacheHandle is the read-only observable returned by ObservableCache.Get
+type cacheHandle struct {
+	c    *ObservableCache
+	addr Addr
+}
+
+func (h cacheHandle) Value() (interface{}, error) {
+	if v, ok := h.c.cached(h.addr); ok {
+		return v, nil
+	}
+
+	h.c.idxLock.Lock()
+	defer h.c.idxLock.Unlock()
+
+	return h.c.value(h.addr)
+}
+
+func (h cacheHandle) Set(interface{}) error {
+	return errors.New("read-only observable")
+}
+
+func (h cacheHandle) Register(sink luigi.Sink) func() {
+	obv, err := h.c.register(h.addr)
+	if err != nil {
+		// like a failed pour of the current value in luigi
+		sink.Close()
+		return func() {}
+	}
+
+	cancel := obv.Register(sink)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			h.c.unregister(h.addr)
+		})
+	}
+}
@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package indexes
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/ssbc/margaret"
+	mjson "github.com/ssbc/margaret/codec/json"
+)
+
+// NewDefaultCodec returns the codec that is used by indexes that are created with a type instead of a codec.
+// Values that implement encoding.BinaryMarshaler are stored using MarshalBinary, all others as json.
+// When decoding, a new value of type tipe is created and filled with UnmarshalBinary, if it has that method, or json.
+// Encoder and decoder streams always use json.
+func NewDefaultCodec(tipe interface{}) margaret.Codec {
+	c := &defaultCodec{Codec: mjson.New(tipe)}
+	if tipe != nil {
+		c.tipe = reflect.TypeOf(tipe)
+		c.asPtr = c.tipe.Kind() == reflect.Ptr
+		if c.asPtr {
+			c.tipe = c.tipe.Elem()
+		}
+	}
+	return c
+}
+
+type defaultCodec struct {
+	margaret.Codec // json, for the streams
+
+	tipe  reflect.Type
+	asPtr bool
+}
+
+func (c *defaultCodec) Marshal(v interface{}) ([]byte, error) {
+	if m, ok := v.(encoding.BinaryMarshaler); ok {
+		raw, err := m.MarshalBinary()
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling value using custom marshaler: %w", err)
+		}
+		return raw, nil
+	}
+
+	raw, err := c.Codec.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling value using json marshaler: %w", err)
+	}
+	return raw, nil
+}
+
+func (c *defaultCodec) Unmarshal(data []byte) (interface{}, error) {
+	if c.tipe == nil {
+		v, err := c.Codec.Unmarshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshaling using json marshaler: %w", err)
+		}
+		return v, nil
+	}
+
+	v := reflect.New(c.tipe).Interface()
+	if um, ok := v.(encoding.BinaryUnmarshaler); ok {
+		if err := um.UnmarshalBinary(data); err != nil {
+			return nil, fmt.Errorf("error unmarshaling using custom marshaler: %w", err)
+		}
+	} else if err := json.Unmarshal(data, v); err != nil {
+		return nil, fmt.Errorf("error unmarshaling using json marshaler: %w", err)
+	}
+
+	if !c.asPtr {
+		v = reflect.ValueOf(v).Elem().Interface()
+	}
+	return v, nil
+}
@@ -69,5 +69,23 @@ type SeqSetterIndex interface {
 	io.Closer
 }
 
-// TODO maybe provide other index builders as well, e.g. for managing
-// sets: add and remove values from and to sets, stored at address
+// SetIndex is an index that stores a set of members at each address.
+// Every member is stored on its own, so that adding and removing members doesn't need to read the whole set first.
+type SetIndex interface {
+	// Add adds member to the set at addr. Adding a member that is already in the set does nothing.
+	Add(ctx context.Context, addr Addr, member Addr) error
+
+	// Remove removes member from the set at addr. Removing a member that isn't in the set does nothing.
+	Remove(ctx context.Context, addr Addr, member Addr) error
+
+	// Has returns true if member is in the set at addr.
+	Has(ctx context.Context, addr Addr, member Addr) (bool, error)
+
+	// Members returns an observable of the set at addr. Its value is of type Members
+	// and it is updated every time a member is added or removed.
+	Members(ctx context.Context, addr Addr) (luigi.Observable, error)
+
+	Flush() error
+
+	io.Closer
+}
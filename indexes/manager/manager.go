@@ -0,0 +1,250 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package manager runs many sinks, like indexes.SinkIndex and multilog.Sink, over one log.
+// Instead of one query per sink, the log is read once and every entry is poured into all the sinks that still need it.
+// Tracker reports how far a sink is behind the log and lets callers wait until it processed an entry.
+package manager // import "github.com/ssbc/margaret/indexes/manager"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ssbc/go-luigi"
+
+	"github.com/ssbc/margaret"
+)
+
+// Sink is a sink that knows which entries of the log it needs next.
+// Both indexes.SinkIndex and multilog.Sink implement it.
+type Sink interface {
+	luigi.Sink
+	QuerySpec() margaret.QuerySpec
+}
+
+var (
+	// ErrRunning is returned by Register and Serve once Serve was called.
+	ErrRunning = errors.New("manager: already serving")
+
+	// ErrDuplicate is returned by Register if the name is already taken.
+	ErrDuplicate = errors.New("manager: duplicate sink name")
+)
+
+// Manager reads a log once for many sinks.
+//
+// Serve first catches up all sinks, starting at the lowest sequence any of them needs,
+// and then switches all of them to live mode together.
+type Manager struct {
+	log margaret.Log
+
+	l       sync.Mutex
+	sinks   []*managedSink
+	byName  map[string]*managedSink
+	running bool
+
+	live chan struct{}
+}
+
+type managedSink struct {
+	name string
+	sink Sink
+
+	// pour unwrapped values, if the sink didn't ask for sequence numbers
+	seqWrap bool
+
+	// next is the sequence of the next entry the sink needs, protected by the manager lock
+	next int64
+}
+
+// New returns a manager for sinks over log.
+func New(log margaret.Log) *Manager {
+	return &Manager{
+		log:    log,
+		byName: make(map[string]*managedSink),
+		live:   make(chan struct{}),
+	}
+}
+
+// Register adds sink under name. It has to be called before Serve.
+func (m *Manager) Register(name string, sink Sink) error {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	if m.running {
+		return ErrRunning
+	}
+	if _, ok := m.byName[name]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicate, name)
+	}
+
+	ms := &managedSink{name: name, sink: sink}
+	m.sinks = append(m.sinks, ms)
+	m.byName[name] = ms
+	return nil
+}
+
+// Live returns a channel that is closed once all sinks caught up with the log and Serve waits for new entries.
+func (m *Manager) Live() <-chan struct{} {
+	return m.live
+}
+
+// Progress returns the sequence of the last entry that each sink processed, by name.
+// It is margaret.SeqEmpty for sinks that didn't process anything yet.
+func (m *Manager) Progress() map[string]int64 {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	progress := make(map[string]int64, len(m.sinks))
+	for _, ms := range m.sinks {
+		progress[ms.name] = ms.next - 1
+	}
+	return progress
+}
+
+// Serve pours the entries of the log into the registered sinks until ctx is cancelled or a sink returns an error.
+// It can only be called once.
+func (m *Manager) Serve(ctx context.Context) error {
+	m.l.Lock()
+	if m.running {
+		m.l.Unlock()
+		return ErrRunning
+	}
+	m.running = true
+
+	from := int64(-1)
+	for _, ms := range m.sinks {
+		next, seqWrap, err := position(ms.sink.QuerySpec())
+		if err != nil {
+			m.l.Unlock()
+			return fmt.Errorf("manager: error getting position of sink %s: %w", ms.name, err)
+		}
+		ms.next, ms.seqWrap = next, seqWrap
+
+		if from == -1 || next < from {
+			from = next
+		}
+	}
+	m.l.Unlock()
+
+	if from == -1 {
+		// nothing registered, just follow the log
+		from = 0
+	}
+
+	// catch up with the entries that are there already
+	target := m.log.Seq()
+	if target >= from {
+		src, err := m.log.Query(margaret.Gte(from), margaret.Lte(target), margaret.SeqWrap(true))
+		if err != nil {
+			return fmt.Errorf("manager: error querying log: %w", err)
+		}
+		if from, err = m.drain(ctx, src, from); err != nil {
+			return err
+		}
+	}
+	close(m.live)
+
+	src, err := m.log.Query(margaret.Gte(from), margaret.SeqWrap(true), margaret.Live(true))
+	if err != nil {
+		return fmt.Errorf("manager: error querying log: %w", err)
+	}
+	_, err = m.drain(ctx, src, from)
+	if errors.Is(err, context.Canceled) && ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// drain pours all values of src into the sinks and returns the sequence of the next entry.
+func (m *Manager) drain(ctx context.Context, src luigi.Source, next int64) (int64, error) {
+	for {
+		v, err := src.Next(ctx)
+		if luigi.IsEOS(err) {
+			return next, nil
+		} else if err != nil {
+			return next, err
+		}
+
+		sw, ok := v.(margaret.SeqWrapper)
+		if !ok {
+			if err, ok := v.(error); ok && margaret.IsErrNulled(err) {
+				// some logs don't wrap deleted entries, but they are still in sequence
+				m.advance(next)
+				next++
+				continue
+			}
+			return next, fmt.Errorf("manager: expecting seqwrapped value (%T)", v)
+		}
+
+		seq := sw.Seq()
+		if err, ok := sw.Value().(error); ok && margaret.IsErrNulled(err) {
+			m.advance(seq)
+		} else if err := m.pour(ctx, sw); err != nil {
+			return seq, err
+		}
+		next = seq + 1
+	}
+}
+
+// pour fans the entry out to all the sinks that still need it.
+func (m *Manager) pour(ctx context.Context, sw margaret.SeqWrapper) error {
+	seq := sw.Seq()
+	for _, ms := range m.sinks {
+		m.l.Lock()
+		needed := ms.next <= seq
+		m.l.Unlock()
+		if !needed {
+			continue
+		}
+
+		var v interface{} = sw
+		if !ms.seqWrap {
+			v = sw.Value()
+		}
+		if err := ms.sink.Pour(ctx, v); err != nil {
+			return fmt.Errorf("manager: sink %s failed at seq %d: %w", ms.name, seq, err)
+		}
+
+		m.l.Lock()
+		ms.next = seq + 1
+		m.l.Unlock()
+	}
+	return nil
+}
+
+// skipper is implemented by sinks that want to know about the deleted entries, which aren't poured into them, like Tracker.
+type skipper interface {
+	Skip(seq int64)
+}
+
+// advance marks a deleted entry as processed by all sinks that would have needed it.
+func (m *Manager) advance(seq int64) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	for _, ms := range m.sinks {
+		if ms.next <= seq {
+			ms.next = seq + 1
+			if s, ok := ms.sink.(skipper); ok {
+				s.Skip(seq)
+			}
+		}
+	}
+}
+
+// Close closes all the registered sinks and returns the first error.
+func (m *Manager) Close() error {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	var firstErr error
+	for _, ms := range m.sinks {
+		if err := ms.sink.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("manager: error closing sink %s: %w", ms.name, err)
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,198 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package manager_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/indexes"
+	"github.com/ssbc/margaret/indexes/manager"
+	"github.com/ssbc/margaret/indexes/mapidx"
+	"github.com/ssbc/margaret/mem"
+	"github.com/ssbc/margaret/multilog"
+	"github.com/ssbc/margaret/multilog/roaring/fs"
+)
+
+// recordingSink remembers the sequences poured into it and starts after from.
+type recordingSink struct {
+	from int64
+
+	l    sync.Mutex
+	seqs []int64
+}
+
+func (rs *recordingSink) QuerySpec() margaret.QuerySpec {
+	return margaret.MergeQuerySpec(margaret.Gt(rs.from), margaret.SeqWrap(true))
+}
+
+func (rs *recordingSink) Pour(ctx context.Context, v interface{}) error {
+	sw, ok := v.(margaret.SeqWrapper)
+	if !ok {
+		return fmt.Errorf("expecting seqwrapped value (%T)", v)
+	}
+
+	rs.l.Lock()
+	defer rs.l.Unlock()
+	rs.seqs = append(rs.seqs, sw.Seq())
+	return nil
+}
+
+func (rs *recordingSink) Close() error { return nil }
+
+func (rs *recordingSink) Seqs() []int64 {
+	rs.l.Lock()
+	defer rs.l.Unlock()
+	return append([]int64(nil), rs.seqs...)
+}
+
+func seqRange(from, to int64) []int64 {
+	var seqs []int64
+	for i := from; i <= to; i++ {
+		seqs = append(seqs, i)
+	}
+	return seqs
+}
+
+func TestManager(t *testing.T) {
+	r := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log := mem.New()
+	for i := 0; i < 10; i++ {
+		_, err := log.Append(fmt.Sprintf("entry %d", i))
+		r.NoError(err)
+	}
+
+	// a sink index that starts from the beginning
+	idx := mapidx.New()
+	sinkIdx := indexes.NewSinkIndex(func(ctx context.Context, seq int64, v interface{}, idx indexes.SetterIndex) error {
+		return idx.Set(ctx, indexes.Addr(fmt.Sprint(seq)), v)
+	}, idx)
+
+	// a multilog sink that stores its progress as a checkpoint
+	mlog, err := fs.NewMultiLog(t.TempDir())
+	r.NoError(err)
+	defer mlog.Close()
+	mlogSink := multilog.NewCheckpointSink("even", mlog, func(ctx context.Context, seq int64, v interface{}, mlog multilog.MultiLog) error {
+		if seq%2 != 0 {
+			return nil
+		}
+		sublog, err := mlog.Get(indexes.Addr("even"))
+		if err != nil {
+			return err
+		}
+		_, err = sublog.Append(seq)
+		return err
+	})
+
+	// sinks that already processed some of the log
+	early := &recordingSink{from: 2}
+	late := &recordingSink{from: 7}
+
+	mgr := manager.New(log)
+	r.NoError(mgr.Register("index", sinkIdx))
+	r.NoError(mgr.Register("even", mlogSink))
+	r.NoError(mgr.Register("early", early))
+	r.NoError(mgr.Register("late", late))
+	r.True(errors.Is(mgr.Register("late", late), manager.ErrDuplicate))
+
+	errc := make(chan error, 1)
+	go func() { errc <- mgr.Serve(ctx) }()
+
+	select {
+	case <-mgr.Live():
+	case <-time.After(5 * time.Second):
+		t.Fatal("manager didn't go live")
+	}
+
+	r.Equal(seqRange(3, 9), early.Seqs())
+	r.Equal(seqRange(8, 9), late.Seqs())
+	r.Equal(map[string]int64{
+		"index": 9,
+		"even":  9,
+		"early": 9,
+		"late":  9,
+	}, mgr.Progress())
+
+	seq, err := idx.GetSeq()
+	r.NoError(err)
+	r.EqualValues(9, seq)
+
+	r.True(errors.Is(mgr.Register("other", &recordingSink{}), manager.ErrRunning))
+	r.True(errors.Is(mgr.Serve(ctx), manager.ErrRunning))
+
+	// new entries reach all sinks
+	for i := 10; i < 15; i++ {
+		_, err := log.Append(fmt.Sprintf("entry %d", i))
+		r.NoError(err)
+	}
+
+	r.Eventually(func() bool {
+		for _, seq := range mgr.Progress() {
+			if seq != 14 {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	r.Equal(seqRange(3, 14), early.Seqs())
+	r.Equal(seqRange(8, 14), late.Seqs())
+
+	obv, err := idx.Get(ctx, indexes.Addr("14"))
+	r.NoError(err)
+	v, err := obv.Value()
+	r.NoError(err)
+	r.Equal("entry 14", v)
+
+	r.NoError(mlog.Flush())
+	sublog, err := mlog.Get(indexes.Addr("even"))
+	r.NoError(err)
+	r.EqualValues(7, sublog.Seq()) // 0, 2, …, 14
+
+	cancel()
+	select {
+	case err := <-errc:
+		r.NoError(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve didn't return after cancel")
+	}
+
+	r.NoError(mgr.Close())
+}
+
+func TestManagerSinkError(t *testing.T) {
+	r := require.New(t)
+
+	log := mem.New()
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(i)
+		r.NoError(err)
+	}
+
+	testErr := errors.New("test error")
+	sinkIdx := indexes.NewSinkIndex(func(ctx context.Context, seq int64, v interface{}, idx indexes.SetterIndex) error {
+		if seq == 1 {
+			return testErr
+		}
+		return nil
+	}, mapidx.New())
+
+	mgr := manager.New(log)
+	r.NoError(mgr.Register("failing", sinkIdx))
+
+	err := mgr.Serve(context.Background())
+	r.True(errors.Is(err, testErr), "unexpected error: %v", err)
+	r.Equal(map[string]int64{"failing": 0}, mgr.Progress())
+}
@@ -0,0 +1,58 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package manager
+
+import (
+	"errors"
+
+	"github.com/ssbc/margaret"
+)
+
+// errUpperBound is returned for sinks that limit their query, the manager can't stop pouring into them.
+var errUpperBound = errors.New("manager: sinks can't limit their query")
+
+// position returns the sequence of the first entry that spec asks for and whether it wants them seqwrapped.
+func position(spec margaret.QuerySpec) (int64, bool, error) {
+	var qry recordingQuery
+	if err := spec(&qry); err != nil {
+		return 0, false, err
+	}
+	return qry.next, qry.seqWrap, nil
+}
+
+// recordingQuery is a margaret.Query that only remembers the constraints of a sink's query spec.
+type recordingQuery struct {
+	next    int64
+	seqWrap bool
+}
+
+func (qry *recordingQuery) Gt(seq int64) error {
+	qry.next = seq + 1
+	return nil
+}
+
+func (qry *recordingQuery) Gte(seq int64) error {
+	qry.next = seq
+	return nil
+}
+
+func (qry *recordingQuery) Lt(int64) error  { return errUpperBound }
+func (qry *recordingQuery) Lte(int64) error { return errUpperBound }
+func (qry *recordingQuery) Limit(int) error { return errUpperBound }
+
+func (qry *recordingQuery) Reverse(yes bool) error {
+	if yes {
+		return errors.New("manager: sinks can't query in reverse")
+	}
+	return nil
+}
+
+// Live is ignored, the manager always switches to live mode after catching up.
+func (qry *recordingQuery) Live(bool) error { return nil }
+
+func (qry *recordingQuery) SeqWrap(wrap bool) error {
+	qry.seqWrap = wrap
+	return nil
+}
@@ -0,0 +1,302 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ssbc/go-luigi"
+
+	"github.com/ssbc/margaret"
+)
+
+// ErrTrackerClosed is returned by WaitFor once the tracked sink was closed.
+var ErrTrackerClosed = errors.New("manager: tracked sink closed")
+
+// rateWindow is the time over which the throughput of a tracked sink is averaged.
+const rateWindow = 10 * time.Second
+
+// Status describes how far a tracked sink is behind its log.
+type Status struct {
+	// Seq is the sequence of the last entry the sink processed, or margaret.SeqEmpty.
+	Seq int64
+
+	// Lag is the number of entries of the log that the sink didn't process yet.
+	Lag int64
+
+	// Rate is the number of entries processed per second, averaged over the last few seconds.
+	Rate float64
+
+	// LastError is the last error returned by the sink, and LastErrorAt when it was returned.
+	LastError   error
+	LastErrorAt time.Time
+}
+
+// Progresser is implemented by sinks that process entries after Pour returned, like the parallel sinks of package multilog.
+// The observable holds the sequence up to which all poured entries are processed, or the error that stopped the sink.
+type Progresser interface {
+	Progress() luigi.Observable
+}
+
+// Tracker wraps a sink, like an indexes.SinkIndex or a multilog.Sink, and reports its progress.
+// It is a Sink itself, so it can be registered with a Manager or pumped from a log query as usual.
+//
+// An entry counts as processed once Pour returned, unless the sink is a Progresser,
+// in which case the tracker follows its progress instead.
+type Tracker struct {
+	log  margaret.Log
+	sink Sink
+
+	// pour unwrapped values, if the sink didn't ask for sequence numbers
+	unwrap bool
+
+	// cancelProgress ends the registration on the progress of a Progresser, nil for other sinks
+	cancelProgress func()
+
+	l   sync.Mutex
+	seq int64
+
+	// poured is the sequence of the last entry handed to the sink or skipped
+	poured int64
+
+	// skips holds the deleted entries that count as processed once everything before them is
+	skips []skip
+
+	lastErr     error
+	lastErrAt   time.Time
+	failed      bool
+	closed      bool
+	changed     chan struct{} // closed and replaced whenever the fields above change
+	windowStart time.Time
+	windowCount int64
+	windowFull  bool
+	rate        float64
+}
+
+// Track returns a tracker for sink, which processes the entries of log.
+// The starting point is taken from the query spec of the sink.
+func Track(log margaret.Log, sink Sink) (*Tracker, error) {
+	next, seqWrap, err := position(sink.QuerySpec())
+	if err != nil {
+		return nil, fmt.Errorf("manager: error getting position of sink: %w", err)
+	}
+
+	t := &Tracker{
+		log:     log,
+		sink:    sink,
+		unwrap:  !seqWrap,
+		seq:     next - 1,
+		poured:  next - 1,
+		changed: make(chan struct{}),
+	}
+	if p, ok := sink.(Progresser); ok {
+		t.cancelProgress = p.Progress().Register(luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+			if err == nil {
+				t.progressed(v)
+			}
+			return nil
+		}))
+	}
+	return t, nil
+}
+
+// skip is a deleted entry that wasn't poured into the sink
+type skip struct {
+	// after is the sequence of the last entry poured before it
+	after int64
+	seq   int64
+}
+
+// QuerySpec returns the query spec of the sink, but always asks for sequence numbers so the tracker can see them.
+func (t *Tracker) QuerySpec() margaret.QuerySpec {
+	return margaret.MergeQuerySpec(t.sink.QuerySpec(), margaret.SeqWrap(true))
+}
+
+// Pour passes v on to the sink and records the outcome.
+func (t *Tracker) Pour(ctx context.Context, v interface{}) error {
+	sw, ok := v.(margaret.SeqWrapper)
+	if ok && t.unwrap {
+		v = sw.Value()
+	}
+
+	err := t.sink.Pour(ctx, v)
+
+	t.l.Lock()
+	defer t.l.Unlock()
+
+	if err != nil {
+		t.lastErr, t.lastErrAt = err, time.Now()
+		t.failed = true
+		t.notify()
+		return err
+	}
+
+	if ok {
+		seq := sw.Seq()
+		if seq > t.poured {
+			t.poured = seq
+		}
+		if t.cancelProgress == nil {
+			t.count(time.Now(), 1)
+			t.complete(seq)
+		}
+		t.failed = false
+		t.notify()
+	}
+	return nil
+}
+
+// Skip marks the deleted entry seq as processed once all entries poured before it are.
+// The Manager calls it for the deleted entries that it doesn't pour into the sinks.
+func (t *Tracker) Skip(seq int64) {
+	t.l.Lock()
+	defer t.l.Unlock()
+
+	if seq <= t.poured {
+		return
+	}
+	if t.poured <= t.seq {
+		// nothing in flight
+		t.poured = seq
+		t.complete(seq)
+	} else {
+		t.skips = append(t.skips, skip{after: t.poured, seq: seq})
+		t.poured = seq
+	}
+	t.notify()
+}
+
+// progressed records a value of the progress of a Progresser.
+func (t *Tracker) progressed(v interface{}) {
+	t.l.Lock()
+	defer t.l.Unlock()
+
+	switch tv := v.(type) {
+	case int64:
+		if tv <= t.seq {
+			return
+		}
+		t.count(time.Now(), tv-t.seq)
+		t.complete(tv)
+	case error:
+		t.lastErr, t.lastErrAt = tv, time.Now()
+		t.failed = true
+	default:
+		return
+	}
+	t.notify()
+}
+
+// complete records that all entries up to seq are processed,
+// which also completes the skipped entries right after it. Take the lock first!
+func (t *Tracker) complete(seq int64) {
+	if seq > t.seq {
+		t.seq = seq
+	}
+	for len(t.skips) > 0 && t.skips[0].after <= t.seq {
+		if s := t.skips[0].seq; s > t.seq {
+			t.seq = s
+		}
+		t.skips = t.skips[1:]
+	}
+}
+
+// Close closes the sink and makes all waiting calls to WaitFor return.
+func (t *Tracker) Close() error {
+	t.l.Lock()
+	t.closed = true
+	t.notify()
+	t.l.Unlock()
+
+	err := t.sink.Close()
+	if t.cancelProgress != nil {
+		t.cancelProgress()
+	}
+	return err
+}
+
+// Status returns the current progress of the sink.
+func (t *Tracker) Status() Status {
+	// get the log sequence first, so the lag is never negative
+	logSeq := t.log.Seq()
+
+	t.l.Lock()
+	defer t.l.Unlock()
+
+	s := Status{
+		Seq:         t.seq,
+		Lag:         logSeq - t.seq,
+		Rate:        t.rate,
+		LastError:   t.lastErr,
+		LastErrorAt: t.lastErrAt,
+	}
+	if s.Lag < 0 {
+		s.Lag = 0
+	}
+	// don't report an old rate if nothing happened since
+	if elapsed := time.Since(t.windowStart); !t.windowStart.IsZero() && elapsed >= rateWindow {
+		s.Rate = float64(t.windowCount) / elapsed.Seconds()
+	}
+	return s
+}
+
+// WaitFor blocks until the sink processed the entry with sequence seq, or a later one.
+// It returns early if ctx is cancelled, the sink failed or the tracker was closed.
+//
+// Entries that were deleted from the log are not poured into sinks.
+// The Manager reports them with Skip, otherwise waiting for one only returns once a later entry was processed.
+func (t *Tracker) WaitFor(ctx context.Context, seq int64) error {
+	for {
+		t.l.Lock()
+		switch {
+		case t.seq >= seq:
+			t.l.Unlock()
+			return nil
+		case t.failed:
+			err := t.lastErr
+			t.l.Unlock()
+			return fmt.Errorf("manager: sink failed before seq %d: %w", seq, err)
+		case t.closed:
+			t.l.Unlock()
+			return ErrTrackerClosed
+		}
+		changed := t.changed
+		t.l.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// notify wakes up all calls to WaitFor. Take the lock first!
+func (t *Tracker) notify() {
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// count adds n processed entries to the throughput. Take the lock first!
+func (t *Tracker) count(now time.Time, n int64) {
+	if t.windowStart.IsZero() {
+		t.windowStart = now
+	}
+	t.windowCount += n
+
+	elapsed := now.Sub(t.windowStart)
+	if elapsed >= rateWindow {
+		t.rate = float64(t.windowCount) / elapsed.Seconds()
+		t.windowStart, t.windowCount = now, 0
+		t.windowFull = true
+	} else if !t.windowFull && elapsed > 0 {
+		// until the first window is full, report what we have
+		t.rate = float64(t.windowCount) / elapsed.Seconds()
+	}
+}
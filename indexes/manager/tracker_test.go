@@ -0,0 +1,211 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package manager_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ssbc/go-luigi"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/indexes"
+	"github.com/ssbc/margaret/indexes/manager"
+	"github.com/ssbc/margaret/indexes/mapidx"
+	"github.com/ssbc/margaret/mem"
+	"github.com/ssbc/margaret/multilog"
+	"github.com/ssbc/margaret/multilog/roaring/fs"
+)
+
+func TestTracker(t *testing.T) {
+	r := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log := mem.New()
+	for i := 0; i < 5; i++ {
+		_, err := log.Append(i)
+		r.NoError(err)
+	}
+
+	idx := mapidx.New()
+	r.NoError(idx.SetSeq(1))
+	sinkIdx := indexes.NewSinkIndex(func(ctx context.Context, seq int64, v interface{}, idx indexes.SetterIndex) error {
+		return idx.Set(ctx, indexes.Addr(fmt.Sprint(seq)), v)
+	}, idx)
+
+	tracker, err := manager.Track(log, sinkIdx)
+	r.NoError(err)
+
+	status := tracker.Status()
+	r.EqualValues(1, status.Seq)
+	r.EqualValues(3, status.Lag)
+	r.Zero(status.Rate)
+	r.NoError(status.LastError)
+
+	// already processed
+	r.NoError(tracker.WaitFor(ctx, 0))
+
+	mgr := manager.New(log)
+	r.NoError(mgr.Register("index", tracker))
+	go mgr.Serve(ctx)
+
+	r.NoError(tracker.WaitFor(ctx, 4))
+	status = tracker.Status()
+	r.EqualValues(4, status.Seq)
+	r.EqualValues(0, status.Lag)
+
+	// read-after-write: wait for the index to see the new entry before looking it up
+	seq, err := log.Append("new")
+	r.NoError(err)
+	r.NoError(tracker.WaitFor(ctx, seq))
+
+	obv, err := idx.Get(ctx, indexes.Addr(fmt.Sprint(seq)))
+	r.NoError(err)
+	v, err := obv.Value()
+	r.NoError(err)
+	r.Equal("new", v)
+
+	status = tracker.Status()
+	r.EqualValues(5, status.Seq)
+	r.EqualValues(0, status.Lag)
+	r.True(status.Rate > 0, "rate: %v", status.Rate)
+
+	// waiting for entries that don't exist yet respects the context
+	waitCtx, waitCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer waitCancel()
+	err = tracker.WaitFor(waitCtx, 100)
+	r.True(errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+
+	r.NoError(tracker.Close())
+	r.True(errors.Is(tracker.WaitFor(ctx, 100), manager.ErrTrackerClosed))
+}
+
+func TestTrackerError(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	log := mem.New()
+	for i := 0; i < 5; i++ {
+		_, err := log.Append(i)
+		r.NoError(err)
+	}
+
+	testErr := errors.New("test error")
+	tracker, err := manager.Track(log, indexes.NewSinkIndex(func(ctx context.Context, seq int64, v interface{}, idx indexes.SetterIndex) error {
+		if seq == 2 {
+			return testErr
+		}
+		return nil
+	}, mapidx.New()))
+	r.NoError(err)
+
+	waitErr := make(chan error, 1)
+	go func() { waitErr <- tracker.WaitFor(ctx, 4) }()
+
+	// trackers also work without a manager
+	src, err := log.Query(tracker.QuerySpec(), margaret.Live(false))
+	r.NoError(err)
+	err = luigi.Pump(ctx, tracker, src)
+	r.True(errors.Is(err, testErr), "unexpected error: %v", err)
+
+	select {
+	case err := <-waitErr:
+		r.True(errors.Is(err, testErr), "unexpected error: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("WaitFor didn't return after the sink failed")
+	}
+
+	status := tracker.Status()
+	r.EqualValues(1, status.Seq)
+	r.EqualValues(3, status.Lag)
+	r.True(errors.Is(status.LastError, testErr))
+	r.False(status.LastErrorAt.IsZero())
+}
+
+func TestTrackerNulled(t *testing.T) {
+	r := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	log := mem.New()
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(i)
+		r.NoError(err)
+	}
+	// the last entry is never poured into the sink
+	r.NoError(log.(margaret.Alterer).Null(2))
+
+	tracker, err := manager.Track(log, indexes.NewSinkIndex(func(ctx context.Context, seq int64, v interface{}, idx indexes.SetterIndex) error {
+		return idx.Set(ctx, indexes.Addr(fmt.Sprint(seq)), v)
+	}, mapidx.New()))
+	r.NoError(err)
+
+	mgr := manager.New(log)
+	r.NoError(mgr.Register("index", tracker))
+	go mgr.Serve(ctx)
+
+	r.NoError(tracker.WaitFor(ctx, 2))
+	r.EqualValues(2, tracker.Status().Seq)
+	r.NoError(tracker.Close())
+}
+
+func TestTrackerParallel(t *testing.T) {
+	r := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	log := mem.New()
+	for i := 0; i < 4; i++ {
+		_, err := log.Append(i)
+		r.NoError(err)
+	}
+	r.NoError(log.(margaret.Alterer).Null(3))
+
+	mlog, err := fs.NewMultiLog(t.TempDir())
+	r.NoError(err)
+	defer mlog.Close()
+
+	// the second entry takes until it is released
+	release := make(chan struct{})
+	sink := multilog.NewParallelCheckpointSink("all", mlog, func(ctx context.Context, seq int64, v interface{}, mlog multilog.MultiLog) error {
+		if seq == 1 {
+			<-release
+		}
+		slog, err := mlog.Get("all")
+		if err != nil {
+			return err
+		}
+		_, err = slog.Append(seq)
+		return err
+	}, 4)
+
+	tracker, err := manager.Track(log, sink)
+	r.NoError(err)
+
+	mgr := manager.New(log)
+	r.NoError(mgr.Register("parallel", tracker))
+	go mgr.Serve(ctx)
+
+	r.NoError(tracker.WaitFor(ctx, 0))
+
+	// handed off, but not processed yet, which also holds back the nulled entry after it
+	for _, seq := range []int64{1, 3} {
+		waitCtx, waitCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		err = tracker.WaitFor(waitCtx, seq)
+		waitCancel()
+		r.True(errors.Is(err, context.DeadlineExceeded), "unexpected error waiting for %d: %v", seq, err)
+	}
+	r.EqualValues(0, tracker.Status().Seq)
+
+	close(release)
+	r.NoError(tracker.WaitFor(ctx, 3))
+	r.EqualValues(3, tracker.Status().Seq)
+	r.NoError(tracker.Close())
+}
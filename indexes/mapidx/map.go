@@ -16,16 +16,23 @@ import (
 
 // New returns a new map based index
 func New() indexes.SeqSetterIndex {
-	return &mapSetterIndex{
-		m:      make(map[indexes.Addr]luigi.Observable),
+	idx := &mapSetterIndex{
+		m:      make(map[indexes.Addr]interface{}),
 		curSeq: margaret.SeqEmpty,
 	}
+	// the values are in memory anyway, only observed addresses need an observable
+	idx.obvs = indexes.NewObservableCache(&idx.l, 0, idx.load)
+	return idx
 }
 
 type mapSetterIndex struct {
-	m      map[indexes.Addr]luigi.Observable
-	curSeq int64
-	l      sync.Mutex
+	m map[indexes.Addr]interface{}
+	// the addresses of m in ascending order for Range, nil if addresses were added or removed since it was sorted
+	sorted  []indexes.Addr
+	obvs    *indexes.ObservableCache
+	curSeq  int64
+	version uint64
+	l       sync.Mutex
 }
 
 func (idx *mapSetterIndex) Flush() error { return nil }
@@ -35,33 +42,31 @@ func (idx *mapSetterIndex) Get(_ context.Context, addr indexes.Addr) (luigi.Obse
 	idx.l.Lock()
 	defer idx.l.Unlock()
 
-	obv, ok := idx.m[addr]
-	if ok {
-		return obv, nil
-	}
-
-	obv = luigi.NewObservable(indexes.UnsetValue{Addr: addr})
-	idx.m[addr] = obv
+	return idx.obvs.Get(addr)
+}
 
-	return obv, nil
+// load returns the value of addr from the map. Take the lock first!
+func (idx *mapSetterIndex) load(addr indexes.Addr) (interface{}, error) {
+	v, ok := idx.m[addr]
+	if !ok {
+		return indexes.UnsetValue{Addr: addr}, nil
+	}
+	return v, nil
 }
 
 func (idx *mapSetterIndex) Set(_ context.Context, addr indexes.Addr, v interface{}) error {
 	idx.l.Lock()
 	defer idx.l.Unlock()
 
-	obv, ok := idx.m[addr]
-	if ok {
-		err := obv.Set(v)
-		if err != nil {
-			return fmt.Errorf("error setting observable: %w", err)
-		}
-		return nil
+	if _, has := idx.m[addr]; !has {
+		idx.sorted = nil
 	}
+	idx.m[addr] = v
 
-	obv = luigi.NewObservable(v)
-	idx.m[addr] = obv
-
+	err := idx.obvs.Set(addr, v)
+	if err != nil {
+		return fmt.Errorf("error setting observable: %w", err)
+	}
 	return nil
 }
 
@@ -69,14 +74,15 @@ func (idx *mapSetterIndex) Delete(_ context.Context, addr indexes.Addr) error {
 	idx.l.Lock()
 	defer idx.l.Unlock()
 
-	obv, ok := idx.m[addr]
-	if ok {
-		err := obv.Set(indexes.UnsetValue{Addr: addr})
-		if err != nil {
-			return fmt.Errorf("error setting observable: %w", err)
-		}
+	if _, has := idx.m[addr]; has {
+		delete(idx.m, addr)
+		idx.sorted = nil
 	}
 
+	err := idx.obvs.Set(addr, indexes.UnsetValue{Addr: addr})
+	if err != nil {
+		return fmt.Errorf("error setting observable: %w", err)
+	}
 	return nil
 }
 
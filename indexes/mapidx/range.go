@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package mapidx
+
+import (
+	"context"
+	"sort"
+
+	"github.com/ssbc/go-luigi"
+
+	"github.com/ssbc/margaret/indexes"
+)
+
+var _ indexes.RangeIndex = (*mapSetterIndex)(nil)
+
+// Range returns a source of indexes.KeyValue for the addresses in [from, to).
+// The addresses are sorted by the first page after they changed, the following pages reuse the order.
+func (idx *mapSetterIndex) Range(_ context.Context, from, to indexes.Addr, reverse bool, limit int) (luigi.Source, error) {
+	return indexes.NewRangeSource(idx.fetchRange, from, to, reverse, limit), nil
+}
+
+func (idx *mapSetterIndex) fetchRange(from, to indexes.Addr, reverse bool, n int) ([]indexes.KeyValue, error) {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	if idx.sorted == nil {
+		idx.sorted = make([]indexes.Addr, 0, len(idx.m))
+		for addr := range idx.m {
+			idx.sorted = append(idx.sorted, addr)
+		}
+		sort.Slice(idx.sorted, func(i, j int) bool { return idx.sorted[i] < idx.sorted[j] })
+	}
+	sorted := idx.sorted
+
+	var kvs []indexes.KeyValue
+	add := func(addr indexes.Addr) bool {
+		if len(kvs) >= n || !indexes.InRange(addr, from, to) {
+			return false
+		}
+		kvs = append(kvs, indexes.KeyValue{Key: addr, Value: idx.m[addr]})
+		return true
+	}
+
+	if reverse {
+		end := len(sorted)
+		if to != "" {
+			end = sort.Search(len(sorted), func(i int) bool { return sorted[i] >= to })
+		}
+		for i := end - 1; i >= 0; i-- {
+			if !add(sorted[i]) {
+				break
+			}
+		}
+	} else {
+		start := sort.Search(len(sorted), func(i int) bool { return sorted[i] >= from })
+		for i := start; i < len(sorted); i++ {
+			if !add(sorted[i]) {
+				break
+			}
+		}
+	}
+	return kvs, nil
+}
@@ -13,4 +13,8 @@ import (
 func TestMap(t *testing.T) {
 	t.Run("SetterIndex", test.RunSetterIndexTests)
 	t.Run("SeqSetterIndex", test.RunSeqSetterIndexTests)
+	t.Run("RangeIndex", test.RunRangeIndexTests)
+	t.Run("VersionedIndex", test.RunVersionedIndexTests)
+	t.Run("ObservableGC", test.RunObservableGCTests)
+	t.Run("ObservableReentrant", test.RunObservableReentrantTests)
 }
@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package mapidx
+
+import (
+	"fmt"
+
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/indexes"
+)
+
+var _ indexes.VersionedIndex = (*mapSetterIndex)(nil)
+
+func (idx *mapSetterIndex) GetVersion() (uint64, error) {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	return idx.version, nil
+}
+
+func (idx *mapSetterIndex) SetVersion(version uint64) error {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	idx.version = version
+	return nil
+}
+
+// Reset removes all the values and unsets the observed addresses.
+func (idx *mapSetterIndex) Reset() error {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	idx.m = make(map[indexes.Addr]interface{})
+	idx.sorted = nil
+	if err := idx.obvs.Reset(); err != nil {
+		return fmt.Errorf("error setting observable: %w", err)
+	}
+	idx.curSeq = margaret.SeqEmpty
+	idx.version = 0
+	return nil
+}
@@ -0,0 +1,206 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package mkv
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ssbc/go-luigi"
+	"modernc.org/kv"
+
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/indexes"
+)
+
+// NewAggregateIndex returns an aggregate index that stores its values in db.
+// Reset deletes all the keys in db.
+func NewAggregateIndex(db *kv.DB) indexes.AggregateIndex {
+	idx := &aggIndex{
+		db:     db,
+		curSeq: -2,
+	}
+	idx.obvs = indexes.NewObservableCache(&idx.l, indexes.DefaultCacheSize, idx.load)
+	return idx
+}
+
+type aggIndex struct {
+	l      sync.Mutex
+	db     *kv.DB
+	obvs   *indexes.ObservableCache
+	curSeq int64
+}
+
+func (idx *aggIndex) Flush() error { return nil }
+
+func (idx *aggIndex) Close() error { return idx.db.Close() }
+
+func (idx *aggIndex) Get(ctx context.Context, addr indexes.Addr) (luigi.Observable, error) {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	return idx.obvs.Get(addr)
+}
+
+// load reads the value of addr from the store. Take the lock first!
+func (idx *aggIndex) load(addr indexes.Addr) (interface{}, error) {
+	data, err := idx.db.Get(nil, []byte(addr))
+	if err != nil {
+		return nil, fmt.Errorf("error loading data from store:%w", err)
+	}
+	if data == nil {
+		return indexes.UnsetValue{Addr: addr}, nil
+	}
+	return indexes.DecodeAggregate(data)
+}
+
+func (idx *aggIndex) Add(ctx context.Context, addr indexes.Addr, delta int64) error {
+	return idx.update(addr, indexes.AggregateAdd, delta)
+}
+
+func (idx *aggIndex) Max(ctx context.Context, addr indexes.Addr, v int64) error {
+	return idx.update(addr, indexes.AggregateMax, v)
+}
+
+func (idx *aggIndex) Min(ctx context.Context, addr indexes.Addr, v int64) error {
+	return idx.update(addr, indexes.AggregateMin, v)
+}
+
+// update applies a single operation, kv.DB.Put makes the read-modify-write atomic
+func (idx *aggIndex) update(addr indexes.Addr, op indexes.AggregateOp, v int64) error {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	agg := idx.newTxnAggregator()
+	if err := agg.apply(addr, op, v); err != nil {
+		return err
+	}
+	return idx.publish(agg.changed)
+}
+
+func (idx *aggIndex) Apply(ctx context.Context, seq int64, f func(indexes.Aggregator) error) error {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	if err := idx.db.BeginTransaction(); err != nil {
+		return fmt.Errorf("error starting mkv transaction:%w", err)
+	}
+
+	agg := idx.newTxnAggregator()
+	err := f(agg)
+	if err == nil {
+		err = idx.db.Set([]byte(currentSeqAddr), indexes.EncodeAggregate(seq))
+	}
+	if err != nil {
+		if rbErr := idx.db.Rollback(); rbErr != nil {
+			return fmt.Errorf("error rolling back (%s) after:%w", rbErr, err)
+		}
+		return err
+	}
+
+	if err := idx.db.Commit(); err != nil {
+		return fmt.Errorf("error committing mkv transaction:%w", err)
+	}
+	idx.curSeq = seq
+	return idx.publish(agg.changed)
+}
+
+// publish sets the new values in the observables. Take the lock first!
+func (idx *aggIndex) publish(changed map[indexes.Addr]int64) error {
+	for addr, v := range changed {
+		if err := idx.obvs.Set(addr, v); err != nil {
+			return fmt.Errorf("error setting value in observable:%w", err)
+		}
+	}
+	return nil
+}
+
+func (idx *aggIndex) GetSeq() (int64, error) {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	if idx.curSeq != -2 {
+		return idx.curSeq, nil
+	}
+
+	data, err := idx.db.Get(nil, []byte(currentSeqAddr))
+	if err != nil {
+		return margaret.SeqErrored, fmt.Errorf("error getting item:%w", err)
+	}
+	if data == nil {
+		idx.curSeq = margaret.SeqEmpty
+		return idx.curSeq, nil
+	}
+
+	seq, err := indexes.DecodeAggregate(data)
+	if err != nil {
+		return margaret.SeqErrored, err
+	}
+	idx.curSeq = seq
+	return seq, nil
+}
+
+func (idx *aggIndex) Reset() error {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	if err := deleteAll(idx.db); err != nil {
+		return err
+	}
+	idx.curSeq = margaret.SeqEmpty
+
+	if err := idx.obvs.Reset(); err != nil {
+		return fmt.Errorf("error setting value in observable:%w", err)
+	}
+	return nil
+}
+
+// txnAggregator applies the operations directly to the store and remembers the changed values.
+// Inside of Apply they are part of the open transaction.
+type txnAggregator struct {
+	idx     *aggIndex
+	changed map[indexes.Addr]int64
+}
+
+func (idx *aggIndex) newTxnAggregator() *txnAggregator {
+	return &txnAggregator{
+		idx:     idx,
+		changed: make(map[indexes.Addr]int64),
+	}
+}
+
+func (agg *txnAggregator) Add(ctx context.Context, addr indexes.Addr, delta int64) error {
+	return agg.apply(addr, indexes.AggregateAdd, delta)
+}
+
+func (agg *txnAggregator) Max(ctx context.Context, addr indexes.Addr, v int64) error {
+	return agg.apply(addr, indexes.AggregateMax, v)
+}
+
+func (agg *txnAggregator) Min(ctx context.Context, addr indexes.Addr, v int64) error {
+	return agg.apply(addr, indexes.AggregateMin, v)
+}
+
+func (agg *txnAggregator) apply(addr indexes.Addr, op indexes.AggregateOp, v int64) error {
+	var next int64
+	_, _, err := agg.idx.db.Put(nil, []byte(addr), func(_, old []byte) ([]byte, bool, error) {
+		var cur int64
+		if old != nil {
+			var err error
+			cur, err = indexes.DecodeAggregate(old)
+			if err != nil {
+				return nil, false, err
+			}
+		}
+		next = op.Apply(cur, old != nil, v)
+		return indexes.EncodeAggregate(next), true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error updating %q:%w", addr, err)
+	}
+	agg.changed[addr] = next
+	return nil
+}
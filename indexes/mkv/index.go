@@ -6,13 +6,10 @@ package mkv
 
 import (
 	"context"
-	"encoding"
 	"encoding/binary"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
-	"reflect"
 	"sync"
 
 	"github.com/ssbc/go-luigi"
@@ -21,20 +18,28 @@ import (
 	"modernc.org/kv"
 )
 
+// NewIndex returns an index that stores values of type tipe using indexes.NewDefaultCodec.
 func NewIndex(db *kv.DB, tipe interface{}) indexes.SeqSetterIndex {
-	return &index{
+	return NewIndexWithCodec(db, indexes.NewDefaultCodec(tipe))
+}
+
+// NewIndexWithCodec returns an index that encodes its values using codec, like the ones in margaret/codec.
+// The index stores its values under their addresses, so db has to be dedicated to it.
+func NewIndexWithCodec(db *kv.DB, codec margaret.Codec) indexes.SeqSetterIndex {
+	idx := &index{
 		db:     db,
-		tipe:   tipe,
-		obvs:   make(map[indexes.Addr]luigi.Observable),
+		codec:  codec,
 		curSeq: int64(-2),
 	}
+	idx.obvs = indexes.NewObservableCache(&idx.l, indexes.DefaultCacheSize, idx.load)
+	return idx
 }
 
 type index struct {
 	l      sync.Mutex
 	db     *kv.DB
-	obvs   map[indexes.Addr]luigi.Observable
-	tipe   interface{}
+	obvs   *indexes.ObservableCache
+	codec  margaret.Codec
 	curSeq int64
 }
 
@@ -46,115 +51,73 @@ func (idx *index) Get(ctx context.Context, addr indexes.Addr) (luigi.Observable,
 	idx.l.Lock()
 	defer idx.l.Unlock()
 
-	obv, ok := idx.obvs[addr]
-	if ok {
-		return obv, nil
-	}
-
-	t := reflect.TypeOf(idx.tipe)
-	v := reflect.New(t).Interface()
+	return idx.obvs.Get(addr)
+}
 
+// load reads the value of addr from the store. Take the lock first!
+func (idx *index) load(addr indexes.Addr) (interface{}, error) {
 	data, err := idx.db.Get(nil, []byte(addr))
-	if data == nil {
-		obv := indexes.NewObservable(indexes.UnsetValue{addr}, idx.deleter(addr))
-		idx.obvs[addr] = obv
-		return roObv{obv}, nil
-	}
 	if err != nil {
 		return nil, fmt.Errorf("error loading data from store:%w", err)
 	}
-
-	if um, ok := v.(encoding.BinaryUnmarshaler); ok {
-		if t.Kind() != reflect.Ptr {
-			v = reflect.ValueOf(v).Elem().Interface()
-		}
-
-		err = um.UnmarshalBinary(data)
-		return nil, fmt.Errorf("error unmarshaling using custom marshaler:%w", err)
+	if data == nil {
+		return indexes.UnsetValue{Addr: addr}, nil
 	}
 
-	err = json.Unmarshal(data, v)
+	v, err := idx.codec.Unmarshal(data)
 	if err != nil {
-		return nil, fmt.Errorf("error unmarshaling using json marshaler:%w", err)
-	}
-
-	if t.Kind() != reflect.Ptr {
-		v = reflect.ValueOf(v).Elem().Interface()
-	}
-
-	obv = indexes.NewObservable(v, idx.deleter(addr))
-	idx.obvs[addr] = obv
-	return roObv{obv}, nil
-}
-
-func (idx *index) deleter(addr indexes.Addr) func() {
-	return func() {
-		delete(idx.obvs, addr)
+		return nil, fmt.Errorf("error decoding value of %q:%w", addr, err)
 	}
+	return v, nil
 }
 
 func (idx *index) Set(ctx context.Context, addr indexes.Addr, v interface{}) error {
-	var (
-		raw []byte
-		err error
-	)
-
-	if m, ok := v.(encoding.BinaryMarshaler); ok {
-		raw, err = m.MarshalBinary()
-		if err != nil {
-			return fmt.Errorf("error marshaling value using custom marshaler:%w", err)
-		}
-	} else {
-		raw, err = json.Marshal(v)
-		if err != nil {
-			return fmt.Errorf("error marshaling value using json marshaler:%w", err)
-		}
+	raw, err := idx.codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error encoding value of %q:%w", addr, err)
 	}
 
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
 	err = idx.db.Set([]byte(addr), raw)
 	if err != nil {
 		return fmt.Errorf("error in store:%w", err)
 	}
 
-	idx.l.Lock()
-	defer idx.l.Unlock()
-
-	obv, ok := idx.obvs[addr]
-	if ok {
-		err = obv.Set(v)
-		if err != nil {
-			return fmt.Errorf("error setting value in observable:%w", err)
-		}
+	err = idx.obvs.Set(addr, v)
+	if err != nil {
+		return fmt.Errorf("error setting value in observable:%w", err)
 	}
 
 	return nil
 }
 
 func (idx *index) Delete(ctx context.Context, addr indexes.Addr) error {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
 	err := idx.db.Delete([]byte(addr))
 	if err != nil {
 		return fmt.Errorf("error in store:%w", err)
 	}
 
-	idx.l.Lock()
-	defer idx.l.Unlock()
-
-	obv, ok := idx.obvs[addr]
-	if ok {
-		err = obv.Set(indexes.UnsetValue{addr})
-		if err != nil {
-			return fmt.Errorf("error setting value in observable:%w", err)
-		}
+	err = idx.obvs.Set(addr, indexes.UnsetValue{Addr: addr})
+	if err != nil {
+		return fmt.Errorf("error setting value in observable:%w", err)
 	}
 
 	return nil
 }
 
+// currentSeqAddr is where the sequence of the index is stored
+const currentSeqAddr indexes.Addr = "__current_observable"
+
 func (idx *index) SetSeq(seq int64) error {
 	var (
 		raw  = make([]byte, 8)
 		err  error
-		addr indexes.Addr = "__current_observable"
+		addr = currentSeqAddr
 	)
 
 	binary.BigEndian.PutUint64(raw, uint64(seq))
@@ -173,7 +136,7 @@ func (idx *index) SetSeq(seq int64) error {
 }
 
 func (idx *index) GetSeq() (int64, error) {
-	var addr = "__current_observable"
+	var addr = currentSeqAddr
 
 	idx.l.Lock()
 	defer idx.l.Unlock()
@@ -0,0 +1,93 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package mkv
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/ssbc/go-luigi"
+	"modernc.org/kv"
+
+	"github.com/ssbc/margaret/indexes"
+)
+
+var _ indexes.RangeIndex = (*index)(nil)
+
+// Range returns a source of indexes.KeyValue for the addresses in [from, to).
+func (idx *index) Range(ctx context.Context, from, to indexes.Addr, reverse bool, limit int) (luigi.Source, error) {
+	return indexes.NewRangeSource(idx.fetchRange, from, to, reverse, limit), nil
+}
+
+func (idx *index) fetchRange(from, to indexes.Addr, reverse bool, n int) ([]indexes.KeyValue, error) {
+	var (
+		enum *kv.Enumerator
+		err  error
+	)
+	switch {
+	case !reverse:
+		enum, _, err = idx.db.Seek([]byte(from))
+	case to == "":
+		enum, err = idx.db.SeekLast()
+	default:
+		enum, err = idx.seekBefore(to)
+	}
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error seeking in store:%w", err)
+	}
+
+	step := enum.Next
+	if reverse {
+		step = enum.Prev
+	}
+
+	var kvs []indexes.KeyValue
+	for len(kvs) < n {
+		k, data, err := step()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("error iterating store:%w", err)
+		}
+
+		addr := indexes.Addr(k)
+		if !indexes.InRange(addr, from, to) {
+			if reverse && to != "" && addr >= to {
+				continue
+			}
+			break
+		}
+
+		if addr == currentSeqAddr || addr == currentVersionAddr {
+			continue
+		}
+
+		v, err := idx.codec.Unmarshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding value of %q:%w", addr, err)
+		}
+		kvs = append(kvs, indexes.KeyValue{Key: addr, Value: v})
+	}
+	return kvs, nil
+}
+
+// seekBefore returns an enumerator whose Prev returns the keys below to, starting with the highest one.
+func (idx *index) seekBefore(to indexes.Addr) (*kv.Enumerator, error) {
+	enum, _, err := idx.db.Seek([]byte(to))
+	if err != nil {
+		return nil, err
+	}
+
+	// the enumerator is positioned at the first key that is equal to or greater than to, which isn't in the range.
+	// If there is no such key, it is exhausted and the range ends at the last key.
+	_, _, err = enum.Prev()
+	if err == io.EOF {
+		return idx.db.SeekLast()
+	}
+	return enum, err
+}
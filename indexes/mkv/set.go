@@ -0,0 +1,146 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package mkv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/ssbc/go-luigi"
+	"modernc.org/kv"
+
+	"github.com/ssbc/margaret/indexes"
+)
+
+// NewSetIndex returns a set index that stores its members in db.
+func NewSetIndex(db *kv.DB) indexes.SetIndex {
+	idx := &setIndex{db: db}
+	idx.obvs = indexes.NewObservableCache(&idx.l, indexes.DefaultCacheSize, idx.load)
+	return idx
+}
+
+type setIndex struct {
+	l    sync.Mutex
+	db   *kv.DB
+	obvs *indexes.ObservableCache
+}
+
+func (idx *setIndex) Flush() error { return nil }
+
+func (idx *setIndex) Close() error { return idx.db.Close() }
+
+func (idx *setIndex) Add(ctx context.Context, addr, member indexes.Addr) error {
+	key, err := indexes.SetMemberKey(addr, member)
+	if err != nil {
+		return err
+	}
+
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	_, added, err := idx.db.Put(nil, key, func(_, old []byte) ([]byte, bool, error) {
+		if old != nil {
+			return nil, false, nil
+		}
+		return []byte{1}, true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error in store:%w", err)
+	}
+
+	if added {
+		return idx.update(addr, func(ms indexes.Members) indexes.Members { return ms.With(member) })
+	}
+	return nil
+}
+
+func (idx *setIndex) Remove(ctx context.Context, addr, member indexes.Addr) error {
+	key, err := indexes.SetMemberKey(addr, member)
+	if err != nil {
+		return err
+	}
+
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	// the lock makes sure nothing changes between checking and deleting
+	data, err := idx.db.Get(nil, key)
+	if err != nil {
+		return fmt.Errorf("error loading data from store:%w", err)
+	}
+	if data == nil {
+		return nil
+	}
+
+	if err := idx.db.Delete(key); err != nil {
+		return fmt.Errorf("error in store:%w", err)
+	}
+
+	return idx.update(addr, func(ms indexes.Members) indexes.Members { return ms.Without(member) })
+}
+
+// update changes the members of addr, if they are in memory. Take the lock first!
+func (idx *setIndex) update(addr indexes.Addr, change func(indexes.Members) indexes.Members) error {
+	err := idx.obvs.Update(addr, func(v interface{}) interface{} {
+		return change(v.(indexes.Members))
+	})
+	if err != nil {
+		return fmt.Errorf("error setting value in observable:%w", err)
+	}
+	return nil
+}
+
+func (idx *setIndex) Has(ctx context.Context, addr, member indexes.Addr) (bool, error) {
+	key, err := indexes.SetMemberKey(addr, member)
+	if err != nil {
+		return false, err
+	}
+
+	data, err := idx.db.Get(nil, key)
+	if err != nil {
+		return false, fmt.Errorf("error loading data from store:%w", err)
+	}
+	return data != nil, nil
+}
+
+func (idx *setIndex) Members(ctx context.Context, addr indexes.Addr) (luigi.Observable, error) {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	return idx.obvs.Get(addr)
+}
+
+// load reads the members of addr from the store. Take the lock first!
+func (idx *setIndex) load(addr indexes.Addr) (interface{}, error) {
+	prefix, err := indexes.SetKeyPrefix(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	members := indexes.Members{}
+	enum, _, err := idx.db.Seek(prefix)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error seeking in store:%w", err)
+	}
+	for err == nil {
+		var k []byte
+		k, _, err = enum.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("error iterating store:%w", err)
+		}
+
+		if !bytes.HasPrefix(k, prefix) {
+			break
+		}
+		members = append(members, indexes.Addr(k[len(prefix):]))
+	}
+
+	return members, nil
+}
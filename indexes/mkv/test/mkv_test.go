@@ -13,4 +13,11 @@ import (
 func TestMKV(t *testing.T) {
 	t.Run("SetterIndex", test.RunSetterIndexTests)
 	t.Run("SeqSetterIndex", test.RunSeqSetterIndexTests)
+	t.Run("RangeIndex", test.RunRangeIndexTests)
+	t.Run("SetIndex", test.RunSetIndexTests)
+	t.Run("TxnIndex", test.RunTxnIndexTests)
+	t.Run("VersionedIndex", test.RunVersionedIndexTests)
+	t.Run("ObservableGC", test.RunObservableGCTests)
+	t.Run("ObservableReentrant", test.RunObservableReentrantTests)
+	t.Run("AggregateIndex", test.RunAggregateIndexTests)
 }
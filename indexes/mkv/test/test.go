@@ -12,16 +12,22 @@ import (
 
 	"modernc.org/kv"
 
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/codec/cbor"
+	"github.com/ssbc/margaret/codec/json"
+	"github.com/ssbc/margaret/codec/msgpack"
 	"github.com/ssbc/margaret/indexes"
 	libmkv "github.com/ssbc/margaret/indexes/mkv"
 	"github.com/ssbc/margaret/indexes/test"
 )
 
 func init() {
-	newSeqSetterIdx := func(name string, tipe interface{}) (indexes.SeqSetterIndex, error) {
-		os.RemoveAll("testrun")
-		os.MkdirAll("testrun", 0700)
-		dir, err := ioutil.TempDir("./testrun", "mkv")
+	createDB := func(name string) (*kv.DB, error) {
+		// only remove the directory of this index, other backends might keep theirs open in testrun
+		base := filepath.Join("testrun", "mkv", name)
+		os.RemoveAll(base)
+		os.MkdirAll(base, 0700)
+		dir, err := ioutil.TempDir(base, "mkv")
 		if err != nil {
 			return nil, fmt.Errorf("error creating tempdir: %w", err)
 		}
@@ -31,10 +37,43 @@ func init() {
 		if err != nil {
 			return nil, fmt.Errorf("error opening test database (%s): %w", dir, err)
 		}
+		return db, nil
+	}
 
+	newSeqSetterIdx := func(name string, tipe interface{}) (indexes.SeqSetterIndex, error) {
+		db, err := createDB(name)
+		if err != nil {
+			return nil, err
+		}
 		return libmkv.NewIndex(db, tipe), nil
 	}
 
+	withCodec := func(newCodec func(interface{}) margaret.Codec) test.NewSeqSetterIndexFunc {
+		return func(name string, tipe interface{}) (indexes.SeqSetterIndex, error) {
+			db, err := createDB(name)
+			if err != nil {
+				return nil, err
+			}
+			return libmkv.NewIndexWithCodec(db, newCodec(tipe)), nil
+		}
+	}
+
+	newSetIdx := func(name string) (indexes.SetIndex, error) {
+		db, err := createDB(name)
+		if err != nil {
+			return nil, err
+		}
+		return libmkv.NewSetIndex(db), nil
+	}
+
+	newAggIdx := func(name string) (indexes.AggregateIndex, error) {
+		db, err := createDB(name)
+		if err != nil {
+			return nil, err
+		}
+		return libmkv.NewAggregateIndex(db), nil
+	}
+
 	toSetterIdx := func(f test.NewSeqSetterIndexFunc) test.NewSetterIndexFunc {
 		return func(name string, tipe interface{}) (indexes.SetterIndex, error) {
 			idx, err := f(name, tipe)
@@ -44,4 +83,16 @@ func init() {
 
 	test.RegisterSeqSetterIndex("mkv", newSeqSetterIdx)
 	test.RegisterSetterIndex("mkv", toSetterIdx(newSeqSetterIdx))
+	for name, newCodec := range map[string]func(interface{}) margaret.Codec{
+		"json":    json.New,
+		"cbor":    cbor.New,
+		"msgpack": msgpack.New,
+	} {
+		newIdx := withCodec(newCodec)
+		test.RegisterSeqSetterIndex("mkv-"+name, newIdx)
+		test.RegisterSetterIndex("mkv-"+name, toSetterIdx(newIdx))
+	}
+
+	test.RegisterSetIndex("mkv", newSetIdx)
+	test.RegisterAggregateIndex("mkv", newAggIdx)
 }
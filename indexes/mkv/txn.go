@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package mkv
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ssbc/margaret/indexes"
+)
+
+var _ indexes.TxnIndex = (*index)(nil)
+
+// Begin starts a transaction. The changes are kept in memory until they are written in a single kv transaction by Commit.
+func (idx *index) Begin(context.Context) (indexes.Txn, error) {
+	return indexes.NewTxn(idx, idx.codec, idx.commitTxn), nil
+}
+
+// commitTxn writes the changes of a transaction and seq in one kv transaction.
+func (idx *index) commitTxn(ops []indexes.TxnOp, seq int64) error {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	if err := idx.db.BeginTransaction(); err != nil {
+		return fmt.Errorf("error starting mkv transaction:%w", err)
+	}
+
+	if err := idx.applyTxn(ops, seq); err != nil {
+		if rbErr := idx.db.Rollback(); rbErr != nil {
+			return fmt.Errorf("error rolling back (%s) after:%w", rbErr, err)
+		}
+		return err
+	}
+
+	if err := idx.db.Commit(); err != nil {
+		return fmt.Errorf("error committing mkv transaction:%w", err)
+	}
+	idx.curSeq = seq
+
+	for _, op := range ops {
+		if err := idx.obvs.Set(op.Addr, op.Value); err != nil {
+			return fmt.Errorf("error setting value in observable:%w", err)
+		}
+	}
+	return nil
+}
+
+// applyTxn writes the changes and the sequence inside of an open kv transaction.
+func (idx *index) applyTxn(ops []indexes.TxnOp, seq int64) error {
+	for i, op := range ops {
+		var err error
+		if op.Delete {
+			err = idx.db.Delete([]byte(op.Addr))
+		} else {
+			err = idx.db.Set([]byte(op.Addr), op.Data)
+		}
+		if err != nil {
+			return fmt.Errorf("error applying change #%d:%w", i, err)
+		}
+	}
+
+	raw := make([]byte, 8)
+	binary.BigEndian.PutUint64(raw, uint64(seq))
+	if err := idx.db.Set([]byte(currentSeqAddr), raw); err != nil {
+		return fmt.Errorf("error setting seq:%w", err)
+	}
+	return nil
+}
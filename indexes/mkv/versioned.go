@@ -0,0 +1,90 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package mkv
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"modernc.org/kv"
+
+	"github.com/ssbc/margaret/indexes"
+)
+
+var _ indexes.VersionedIndex = (*index)(nil)
+
+// currentVersionAddr is where the version of the index is stored
+const currentVersionAddr indexes.Addr = "__current_version"
+
+func (idx *index) GetVersion() (uint64, error) {
+	data, err := idx.db.Get(nil, []byte(currentVersionAddr))
+	if err != nil {
+		return 0, fmt.Errorf("error getting item:%w", err)
+	}
+	if data == nil {
+		return 0, nil
+	}
+
+	if l := len(data); l != 8 {
+		return 0, fmt.Errorf("expected data of length 8, got %v", l)
+	}
+	return binary.BigEndian.Uint64(data), nil
+}
+
+func (idx *index) SetVersion(version uint64) error {
+	raw := make([]byte, 8)
+	binary.BigEndian.PutUint64(raw, version)
+
+	if err := idx.db.Set([]byte(currentVersionAddr), raw); err != nil {
+		return fmt.Errorf("error during mkv update:%w", err)
+	}
+	return nil
+}
+
+// Reset deletes all the keys in the database of the index.
+// The index uses the keys of the database as they are, without a prefix, so the database can't hold anything else.
+func (idx *index) Reset() error {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	if err := deleteAll(idx.db); err != nil {
+		return err
+	}
+	// reloaded as margaret.SeqEmpty by GetSeq
+	idx.curSeq = -2
+
+	if err := idx.obvs.Reset(); err != nil {
+		return fmt.Errorf("error setting value in observable:%w", err)
+	}
+	return nil
+}
+
+// deleteAll removes all the keys of db in a single transaction.
+func deleteAll(db *kv.DB) error {
+	if err := db.BeginTransaction(); err != nil {
+		return fmt.Errorf("error starting mkv transaction:%w", err)
+	}
+
+	for {
+		k, _, err := db.First()
+		if err == nil && k != nil {
+			err = db.Delete(k)
+		}
+		if err != nil {
+			if rbErr := db.Rollback(); rbErr != nil {
+				return fmt.Errorf("error rolling back (%s) after:%w", rbErr, err)
+			}
+			return fmt.Errorf("error deleting keys:%w", err)
+		}
+		if k == nil {
+			break
+		}
+	}
+
+	if err := db.Commit(); err != nil {
+		return fmt.Errorf("error committing mkv transaction:%w", err)
+	}
+	return nil
+}
@@ -12,6 +12,7 @@ import (
 
 // NewObservable returns a regular observable that calls f when the last registration is cancelled.
 // This is used to garbage-collect observables from the maps in the indexes.
+// The indexes of this module use ObservableCache, which also drops observables that were never registered.
 func NewObservable(v interface{}, f func()) luigi.Observable {
 	return &observable{
 		Observable: luigi.NewObservable(v),
@@ -0,0 +1,129 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package indexes
+
+import (
+	"context"
+
+	"github.com/ssbc/go-luigi"
+)
+
+// KeyValue is a pair of an address and the value stored at it, as returned by the source of RangeIndex.Range.
+type KeyValue struct {
+	Key   Addr
+	Value interface{}
+}
+
+// RangeIndex is an index that can iterate over its addresses in byte-wise order.
+type RangeIndex interface {
+	Index
+
+	// Range returns a source of KeyValue for all set addresses that are equal to or greater than from and less than to.
+	// An empty to means there is no upper bound. If reverse is true, the highest address comes first.
+	// A negative limit returns all of them.
+	Range(ctx context.Context, from, to Addr, reverse bool, limit int) (luigi.Source, error)
+}
+
+// RangePrefix returns a source of KeyValue for all the addresses in idx that start with prefix.
+func RangePrefix(ctx context.Context, idx RangeIndex, prefix Addr, reverse bool, limit int) (luigi.Source, error) {
+	return idx.Range(ctx, prefix, PrefixEnd(prefix), reverse, limit)
+}
+
+// PrefixEnd returns the lowest address that is greater than all addresses starting with prefix.
+// It returns an empty address, which means no upper bound for Range, if there is none.
+func PrefixEnd(prefix Addr) Addr {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end = append([]byte{}, end[:i+1]...)
+			end[i]++
+			return Addr(end)
+		}
+	}
+	return ""
+}
+
+// RangeFetchFunc returns up to n pairs of a range, ordered like the range.
+// It is used by NewRangeSource to fetch the range page by page.
+type RangeFetchFunc func(from, to Addr, reverse bool, n int) ([]KeyValue, error)
+
+// rangePageSize is the number of pairs a range source fetches at once.
+const rangePageSize = 256
+
+// NewRangeSource returns a source for Range that uses fetch to load the next pairs when needed.
+// This way no iterator or transaction of the backing store has to be kept open while the source is consumed.
+func NewRangeSource(fetch RangeFetchFunc, from, to Addr, reverse bool, limit int) luigi.Source {
+	return &rangeSource{
+		fetch:   fetch,
+		from:    from,
+		to:      to,
+		reverse: reverse,
+		limit:   limit,
+	}
+}
+
+type rangeSource struct {
+	fetch RangeFetchFunc
+
+	from, to Addr
+	reverse  bool
+	limit    int
+
+	page []KeyValue
+	done bool
+}
+
+func (src *rangeSource) Next(ctx context.Context) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if src.limit == 0 {
+		return nil, luigi.EOS{}
+	}
+
+	if len(src.page) == 0 {
+		if src.done {
+			return nil, luigi.EOS{}
+		}
+
+		n := rangePageSize
+		if src.limit > 0 && src.limit < n {
+			n = src.limit
+		}
+
+		page, err := src.fetch(src.from, src.to, src.reverse, n)
+		if err != nil {
+			return nil, err
+		}
+		if len(page) < n {
+			src.done = true
+		}
+		if len(page) == 0 {
+			return nil, luigi.EOS{}
+		}
+
+		// continue after the last one
+		last := page[len(page)-1].Key
+		if src.reverse {
+			src.to = last
+		} else {
+			src.from = last + "\x00"
+		}
+		src.page = page
+	}
+
+	kv := src.page[0]
+	src.page = src.page[1:]
+	if src.limit > 0 {
+		src.limit--
+	}
+	return kv, nil
+}
+
+// InRange returns true if from <= addr < to, with an empty to meaning no upper bound.
+func InRange(addr, from, to Addr) bool {
+	return addr >= from && (to == "" || addr < to)
+}
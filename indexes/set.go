@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package indexes
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Members is the value of the observables returned by SetIndex.Members.
+// It is sorted and shouldn't be modified, use With and Without to get changed copies.
+type Members []Addr
+
+// Has returns true if member is in the set.
+func (ms Members) Has(member Addr) bool {
+	i := sort.Search(len(ms), func(i int) bool { return ms[i] >= member })
+	return i < len(ms) && ms[i] == member
+}
+
+// With returns a copy of the set that includes member.
+func (ms Members) With(member Addr) Members {
+	i := sort.Search(len(ms), func(i int) bool { return ms[i] >= member })
+	if i < len(ms) && ms[i] == member {
+		return ms
+	}
+
+	out := make(Members, 0, len(ms)+1)
+	out = append(out, ms[:i]...)
+	out = append(out, member)
+	return append(out, ms[i:]...)
+}
+
+// Without returns a copy of the set that doesn't include member.
+func (ms Members) Without(member Addr) Members {
+	i := sort.Search(len(ms), func(i int) bool { return ms[i] >= member })
+	if i == len(ms) || ms[i] != member {
+		return ms
+	}
+
+	out := make(Members, 0, len(ms)-1)
+	out = append(out, ms[:i]...)
+	return append(out, ms[i+1:]...)
+}
+
+// SetKeyPrefix returns the prefix of the keys that SetIndex implementations use to store the members at addr.
+// The length of addr is encoded in front of it, so that no set is a prefix of another one.
+func SetKeyPrefix(addr Addr) ([]byte, error) {
+	if len(addr) > math.MaxUint16 {
+		return nil, fmt.Errorf("indexes: set address too long (%d bytes)", len(addr))
+	}
+
+	k := make([]byte, 2, 2+len(addr))
+	binary.BigEndian.PutUint16(k, uint16(len(addr)))
+	return append(k, addr...), nil
+}
+
+// SetMemberKey returns the key that stores member in the set at addr.
+func SetMemberKey(addr, member Addr) ([]byte, error) {
+	k, err := SetKeyPrefix(addr)
+	if err != nil {
+		return nil, err
+	}
+	return append(k, member...), nil
+}
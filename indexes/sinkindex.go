@@ -21,8 +21,21 @@ func NewSinkIndex(f StreamProcFunc, idx SeqSetterIndex) SinkIndex {
 	}
 }
 
+// NewTxnSinkIndex is like NewSinkIndex, but calls f with a transaction for every log entry
+// and commits it together with the sequence of the entry, so that an entry is either indexed completely or not at all.
+// This writes every entry on its own, which is slower than the batched writes some indexes do otherwise.
+// f gets the transaction instead of idx.
+func NewTxnSinkIndex(f StreamProcFunc, idx TxnIndex) SinkIndex {
+	return &sinkIndex{
+		idx: idx,
+		txn: idx,
+		f:   f,
+	}
+}
+
 type sinkIndex struct {
 	idx SeqSetterIndex
+	txn TxnIndex // nil unless created by NewTxnSinkIndex
 	f   StreamProcFunc
 }
 
@@ -39,6 +52,10 @@ func (r *sinkIndex) QuerySpec() margaret.QuerySpec {
 func (idx *sinkIndex) Pour(ctx context.Context, v interface{}) error {
 	switch tv := v.(type) {
 	case margaret.SeqWrapper:
+		if idx.txn != nil {
+			return idx.pourTxn(ctx, tv)
+		}
+
 		err := idx.f(ctx, tv.Seq(), tv.Value(), idx.idx)
 		if err != nil {
 			return fmt.Errorf("error calling setter func: %w", err)
@@ -60,6 +77,26 @@ func (idx *sinkIndex) Pour(ctx context.Context, v interface{}) error {
 
 }
 
+// pourTxn calls the processing function with a transaction, so that its changes and the new sequence are stored together.
+func (idx *sinkIndex) pourTxn(ctx context.Context, sw margaret.SeqWrapper) error {
+	txn, err := idx.txn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer txn.Discard()
+
+	err = idx.f(ctx, sw.Seq(), sw.Value(), txnSetterIndex{txn})
+	if err != nil {
+		return fmt.Errorf("error calling setter func: %w", err)
+	}
+
+	err = txn.Commit(sw.Seq())
+	if err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
 func (idx *sinkIndex) Close() error {
 	return idx.idx.Close()
 }
@@ -0,0 +1,175 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package sqlite implements indexes.SeqSetterIndex on top of an sqlite database.
+// Like the other sqlite backends, it doesn't register a driver. Import github.com/mattn/go-sqlite3 to open the database.
+package sqlite // import "github.com/ssbc/margaret/indexes/sqlite"
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ssbc/go-luigi"
+
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/indexes"
+)
+
+const schemaVersion1 = `
+CREATE TABLE IF NOT EXISTS index_values (
+	addr blob PRIMARY KEY,
+	data blob NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS index_seq (
+	id integer PRIMARY KEY CHECK (id = 0),
+	seq integer NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS index_version (
+	id integer PRIMARY KEY CHECK (id = 0),
+	version integer NOT NULL
+);
+`
+
+// NewIndex returns an index that stores values of type tipe in db, using indexes.NewDefaultCodec.
+// The tables of the index are created if they don't exist yet. Close also closes db.
+func NewIndex(db *sql.DB, tipe interface{}) (indexes.SeqSetterIndex, error) {
+	return NewIndexWithCodec(db, indexes.NewDefaultCodec(tipe))
+}
+
+// NewIndexWithCodec returns an index that encodes its values using codec, like the ones in margaret/codec.
+func NewIndexWithCodec(db *sql.DB, codec margaret.Codec) (indexes.SeqSetterIndex, error) {
+	if _, err := db.Exec(schemaVersion1); err != nil {
+		return nil, fmt.Errorf("failed to init schema: %w", err)
+	}
+
+	idx := &index{
+		db:     db,
+		codec:  codec,
+		curSeq: -2,
+	}
+	idx.obvs = indexes.NewObservableCache(&idx.l, indexes.DefaultCacheSize, idx.load)
+	return idx, nil
+}
+
+type index struct {
+	l      sync.Mutex
+	db     *sql.DB
+	obvs   *indexes.ObservableCache
+	codec  margaret.Codec
+	curSeq int64
+}
+
+func (idx *index) Flush() error { return nil }
+
+func (idx *index) Close() error { return idx.db.Close() }
+
+func (idx *index) Get(ctx context.Context, addr indexes.Addr) (luigi.Observable, error) {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	return idx.obvs.Get(addr)
+}
+
+// load reads the value of addr from the database. Take the lock first!
+func (idx *index) load(addr indexes.Addr) (interface{}, error) {
+	var data []byte
+	err := idx.db.QueryRow(`SELECT data FROM index_values WHERE addr = ?`, []byte(addr)).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return indexes.UnsetValue{Addr: addr}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error loading data from store: %w", err)
+	}
+
+	v, err := idx.codec.Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding value of %q: %w", addr, err)
+	}
+	return v, nil
+}
+
+func (idx *index) Set(ctx context.Context, addr indexes.Addr, v interface{}) error {
+	raw, err := idx.codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error encoding value of %q: %w", addr, err)
+	}
+
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	_, err = idx.db.ExecContext(ctx, `INSERT OR REPLACE INTO index_values (addr, data) VALUES (?, ?)`, []byte(addr), raw)
+	if err != nil {
+		return fmt.Errorf("error in store: %w", err)
+	}
+
+	return idx.publish(addr, v)
+}
+
+func (idx *index) Delete(ctx context.Context, addr indexes.Addr) error {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	_, err := idx.db.ExecContext(ctx, `DELETE FROM index_values WHERE addr = ?`, []byte(addr))
+	if err != nil {
+		return fmt.Errorf("error in store: %w", err)
+	}
+
+	return idx.publish(addr, indexes.UnsetValue{Addr: addr})
+}
+
+// publish sets the new value of addr in the observable cache. Take the lock first!
+func (idx *index) publish(addr indexes.Addr, v interface{}) error {
+	if err := idx.obvs.Set(addr, v); err != nil {
+		return fmt.Errorf("error setting value in observable: %w", err)
+	}
+	return nil
+}
+
+// execer is implemented by *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func setSeq(db execer, seq int64) error {
+	_, err := db.Exec(`INSERT OR REPLACE INTO index_seq (id, seq) VALUES (0, ?)`, seq)
+	if err != nil {
+		return fmt.Errorf("error setting seq: %w", err)
+	}
+	return nil
+}
+
+func (idx *index) SetSeq(seq int64) error {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	if err := setSeq(idx.db, seq); err != nil {
+		return err
+	}
+	idx.curSeq = seq
+	return nil
+}
+
+func (idx *index) GetSeq() (int64, error) {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	if idx.curSeq != -2 {
+		return idx.curSeq, nil
+	}
+
+	var seq int64
+	err := idx.db.QueryRow(`SELECT seq FROM index_seq WHERE id = 0`).Scan(&seq)
+	if errors.Is(err, sql.ErrNoRows) {
+		return margaret.SeqEmpty, nil
+	} else if err != nil {
+		return margaret.SeqErrored, fmt.Errorf("error getting seq: %w", err)
+	}
+
+	idx.curSeq = seq
+	return seq, nil
+}
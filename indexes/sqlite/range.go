@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package sqlite
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ssbc/go-luigi"
+
+	"github.com/ssbc/margaret/indexes"
+)
+
+var _ indexes.RangeIndex = (*index)(nil)
+
+// Range returns a source of indexes.KeyValue for the addresses in [from, to).
+// Addresses are stored as blobs, which sqlite compares byte-wise.
+func (idx *index) Range(ctx context.Context, from, to indexes.Addr, reverse bool, limit int) (luigi.Source, error) {
+	return indexes.NewRangeSource(idx.fetchRange, from, to, reverse, limit), nil
+}
+
+func (idx *index) fetchRange(from, to indexes.Addr, reverse bool, n int) ([]indexes.KeyValue, error) {
+	query := `SELECT addr, data FROM index_values WHERE addr >= ?`
+	args := []interface{}{[]byte(from)}
+	if to != "" {
+		query += ` AND addr < ?`
+		args = append(args, []byte(to))
+	}
+	if reverse {
+		query += ` ORDER BY addr DESC`
+	} else {
+		query += ` ORDER BY addr`
+	}
+	query += ` LIMIT ?`
+	args = append(args, n)
+
+	rows, err := idx.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying range: %w", err)
+	}
+	defer rows.Close()
+
+	var kvs []indexes.KeyValue
+	for rows.Next() {
+		var addr, data []byte
+		if err := rows.Scan(&addr, &data); err != nil {
+			return nil, fmt.Errorf("error scanning range: %w", err)
+		}
+
+		v, err := idx.codec.Unmarshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding value of %q: %w", addr, err)
+		}
+		kvs = append(kvs, indexes.KeyValue{Key: indexes.Addr(addr), Value: v})
+	}
+	return kvs, rows.Err()
+}
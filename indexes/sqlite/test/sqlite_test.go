@@ -0,0 +1,21 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package test
+
+import (
+	"testing"
+
+	"github.com/ssbc/margaret/indexes/test"
+)
+
+func TestSqlite(t *testing.T) {
+	t.Run("SetterIndex", test.RunSetterIndexTests)
+	t.Run("SeqSetterIndex", test.RunSeqSetterIndexTests)
+	t.Run("RangeIndex", test.RunRangeIndexTests)
+	t.Run("TxnIndex", test.RunTxnIndexTests)
+	t.Run("VersionedIndex", test.RunVersionedIndexTests)
+	t.Run("ObservableGC", test.RunObservableGCTests)
+	t.Run("ObservableReentrant", test.RunObservableReentrantTests)
+}
@@ -0,0 +1,42 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package test
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/ssbc/margaret/indexes"
+	lisqlite "github.com/ssbc/margaret/indexes/sqlite"
+	"github.com/ssbc/margaret/indexes/test"
+)
+
+func init() {
+	newSeqSetterIdx := func(name string, tipe interface{}) (indexes.SeqSetterIndex, error) {
+		dir := filepath.Join("testrun", "sqlite", name)
+		os.RemoveAll(dir)
+		os.MkdirAll(dir, 0700)
+
+		db, err := sql.Open("sqlite3", filepath.Join(dir, "index.db"))
+		if err != nil {
+			return nil, fmt.Errorf("error opening test database (%s): %w", dir, err)
+		}
+		return lisqlite.NewIndex(db, tipe)
+	}
+
+	toSetterIdx := func(f test.NewSeqSetterIndexFunc) test.NewSetterIndexFunc {
+		return func(name string, tipe interface{}) (indexes.SetterIndex, error) {
+			idx, err := f(name, tipe)
+			return idx, err
+		}
+	}
+
+	test.RegisterSeqSetterIndex("sqlite", newSeqSetterIdx)
+	test.RegisterSetterIndex("sqlite", toSetterIdx(newSeqSetterIdx))
+}
@@ -0,0 +1,63 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package sqlite
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ssbc/margaret/indexes"
+)
+
+var _ indexes.TxnIndex = (*index)(nil)
+
+// Begin starts a transaction. The changes are kept in memory until they are written in a single sql transaction by Commit.
+func (idx *index) Begin(context.Context) (indexes.Txn, error) {
+	return indexes.NewTxn(idx, idx.codec, idx.commitTxn), nil
+}
+
+// commitTxn writes the changes of a transaction and seq in one sql transaction.
+func (idx *index) commitTxn(ops []indexes.TxnOp, seq int64) error {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	sqlTx, err := idx.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting sql transaction: %w", err)
+	}
+
+	for i, op := range ops {
+		if op.Delete {
+			_, err = sqlTx.Exec(`DELETE FROM index_values WHERE addr = ?`, []byte(op.Addr))
+		} else {
+			_, err = sqlTx.Exec(`INSERT OR REPLACE INTO index_values (addr, data) VALUES (?, ?)`, []byte(op.Addr), op.Data)
+		}
+		if err != nil {
+			err = fmt.Errorf("error applying change #%d: %w", i, err)
+			break
+		}
+	}
+	if err == nil {
+		err = setSeq(sqlTx, seq)
+	}
+	if err != nil {
+		if rbErr := sqlTx.Rollback(); rbErr != nil {
+			return fmt.Errorf("error rolling back (%s) after: %w", rbErr, err)
+		}
+		return err
+	}
+
+	if err := sqlTx.Commit(); err != nil {
+		return fmt.Errorf("error committing sql transaction: %w", err)
+	}
+	idx.curSeq = seq
+
+	for _, op := range ops {
+		if err := idx.publish(op.Addr, op.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/ssbc/margaret/indexes"
+)
+
+var _ indexes.VersionedIndex = (*index)(nil)
+
+func (idx *index) GetVersion() (uint64, error) {
+	// sqlite integers are signed, the version is stored as its two's complement
+	var version int64
+	err := idx.db.QueryRow(`SELECT version FROM index_version WHERE id = 0`).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("error getting version: %w", err)
+	}
+	return uint64(version), nil
+}
+
+func (idx *index) SetVersion(version uint64) error {
+	_, err := idx.db.Exec(`INSERT OR REPLACE INTO index_version (id, version) VALUES (0, ?)`, int64(version))
+	if err != nil {
+		return fmt.Errorf("error setting version: %w", err)
+	}
+	return nil
+}
+
+// Reset deletes the rows of all the tables of the index.
+func (idx *index) Reset() error {
+	idx.l.Lock()
+	defer idx.l.Unlock()
+
+	sqlTx, err := idx.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting sql transaction: %w", err)
+	}
+	for _, table := range []string{"index_values", "index_seq", "index_version"} {
+		if _, err := sqlTx.Exec(`DELETE FROM ` + table); err != nil {
+			if rbErr := sqlTx.Rollback(); rbErr != nil {
+				return fmt.Errorf("error rolling back (%s) after: %w", rbErr, err)
+			}
+			return fmt.Errorf("error deleting %s: %w", table, err)
+		}
+	}
+	if err := sqlTx.Commit(); err != nil {
+		return fmt.Errorf("error committing sql transaction: %w", err)
+	}
+	// reloaded as margaret.SeqEmpty by GetSeq
+	idx.curSeq = -2
+
+	if err := idx.obvs.Reset(); err != nil {
+		return fmt.Errorf("error setting value in observable: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/go-luigi"
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/indexes"
+	"github.com/ssbc/margaret/mem"
+)
+
+type NewAggregateIndexFunc func(name string) (indexes.AggregateIndex, error)
+
+func TestAggregateIndex(newIdx NewAggregateIndexFunc) func(*testing.T) {
+	return func(t *testing.T) {
+		t.Run("Operations", TestAggregateIndexOperations(newIdx))
+		t.Run("Concurrent", TestAggregateIndexConcurrent(newIdx))
+		t.Run("SinkRebuild", TestAggregateIndexSinkRebuild(newIdx))
+	}
+}
+
+func TestAggregateIndexOperations(newIdx NewAggregateIndexFunc) func(*testing.T) {
+	return func(t *testing.T) {
+		ctx := context.Background()
+		r := require.New(t)
+
+		idx, err := newIdx(t.Name())
+		r.NoError(err, "error creating index")
+		defer idx.Close()
+
+		r.Equal(indexes.UnsetValue{Addr: "count"}, value(t, idx, "count"))
+
+		obv, err := idx.Get(ctx, "count")
+		r.NoError(err)
+
+		r.NoError(indexes.Increment(ctx, idx, "count"))
+		r.NoError(indexes.Increment(ctx, idx, "count"))
+		r.NoError(indexes.Increment(ctx, idx, "count"))
+		r.NoError(indexes.Decrement(ctx, idx, "count"))
+
+		v, err := obv.Value()
+		r.NoError(err)
+		r.EqualValues(2, v, "observable not updated")
+
+		r.NoError(idx.Add(ctx, "sum", 40))
+		r.NoError(idx.Add(ctx, "sum", -42))
+		r.EqualValues(-2, value(t, idx, "sum"))
+
+		for _, v := range []int64{5, -3, 17, 2} {
+			r.NoError(idx.Max(ctx, "max", v))
+			r.NoError(idx.Min(ctx, "min", v))
+		}
+		r.EqualValues(17, value(t, idx, "max"))
+		r.EqualValues(-3, value(t, idx, "min"))
+	}
+}
+
+func TestAggregateIndexConcurrent(newIdx NewAggregateIndexFunc) func(*testing.T) {
+	return func(t *testing.T) {
+		ctx := context.Background()
+		r := require.New(t)
+
+		idx, err := newIdx(t.Name())
+		r.NoError(err, "error creating index")
+		defer idx.Close()
+
+		const workers, n = 8, 100
+
+		var wg sync.WaitGroup
+		errc := make(chan error, workers)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < n; i++ {
+					if err := indexes.Increment(ctx, idx, "count"); err != nil {
+						errc <- err
+						return
+					}
+					if err := idx.Max(ctx, "max", int64(w*n+i)); err != nil {
+						errc <- err
+						return
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+		close(errc)
+		for err := range errc {
+			r.NoError(err)
+		}
+
+		r.EqualValues(workers*n, value(t, idx, "count"), "lost updates")
+		r.EqualValues(workers*n-1, value(t, idx, "max"))
+	}
+}
+
+func TestAggregateIndexSinkRebuild(newIdx NewAggregateIndexFunc) func(*testing.T) {
+	return func(t *testing.T) {
+		ctx := context.Background()
+		r := require.New(t)
+
+		idx, err := newIdx(t.Name())
+		r.NoError(err, "error creating index")
+
+		log := mem.New()
+		for _, v := range []string{"a", "b", "a", "c", "a", "b"} {
+			_, err := log.Append(v)
+			r.NoError(err)
+		}
+
+		errFail := errors.New("processing failed")
+		f := func(ctx context.Context, seq int64, v interface{}, agg indexes.Aggregator) error {
+			if err := indexes.Increment(ctx, agg, indexes.Addr(v.(string))); err != nil {
+				return err
+			}
+			if err := agg.Max(ctx, "last", seq); err != nil {
+				return err
+			}
+			if v == "fail" {
+				return errFail
+			}
+			return nil
+		}
+
+		sink := indexes.NewAggregateSinkIndex(f, idx)
+		defer sink.Close()
+
+		pump := func() {
+			src, err := log.Query(sink.QuerySpec())
+			r.NoError(err)
+			r.NoError(luigi.Pump(ctx, sink, src))
+		}
+
+		check := func() {
+			r.EqualValues(3, value(t, idx, "a"))
+			r.EqualValues(2, value(t, idx, "b"))
+			r.EqualValues(1, value(t, idx, "c"))
+			r.EqualValues(5, value(t, idx, "last"))
+
+			seq, err := idx.GetSeq()
+			r.NoError(err)
+			r.EqualValues(5, seq)
+		}
+
+		pump()
+		check()
+
+		// pouring entries again doesn't count them twice
+		for i := int64(0); i < 6; i++ {
+			v, err := log.Get(i)
+			r.NoError(err)
+			r.NoError(sink.Pour(ctx, margaret.WrapWithSeq(v, i)))
+		}
+		check()
+
+		// a failing entry doesn't leave partial changes
+		_, err = log.Append("fail")
+		r.NoError(err)
+		src, err := log.Query(sink.QuerySpec())
+		r.NoError(err)
+		r.ErrorIs(luigi.Pump(ctx, sink, src), errFail)
+		r.Equal(indexes.UnsetValue{Addr: "fail"}, value(t, idx, "fail"))
+		check()
+
+		// rebuild from scratch
+		r.NoError(idx.Reset())
+		seq, err := idx.GetSeq()
+		r.NoError(err)
+		r.EqualValues(margaret.SeqEmpty, seq)
+		r.Equal(indexes.UnsetValue{Addr: "a"}, value(t, idx, "a"))
+
+		src, err = log.Query(sink.QuerySpec(), margaret.Lt(6))
+		r.NoError(err)
+		r.NoError(luigi.Pump(ctx, sink, src))
+		check()
+	}
+}
@@ -11,4 +11,5 @@ import (
 	_ "github.com/ssbc/margaret/indexes/badger/test"
 	_ "github.com/ssbc/margaret/indexes/mapidx/test"
 	_ "github.com/ssbc/margaret/indexes/mkv/test"
+	_ "github.com/ssbc/margaret/indexes/sqlite/test"
 )
@@ -13,5 +13,12 @@ import (
 func Test(t *testing.T) {
 	t.Run("SeqSetterIndex", ltest.RunSeqSetterIndexTests)
 	t.Run("SetterIndex", ltest.RunSetterIndexTests)
+	t.Run("RangeIndex", ltest.RunRangeIndexTests)
+	t.Run("SetIndex", ltest.RunSetIndexTests)
+	t.Run("TxnIndex", ltest.RunTxnIndexTests)
+	t.Run("VersionedIndex", ltest.RunVersionedIndexTests)
+	t.Run("ObservableGC", ltest.RunObservableGCTests)
+	t.Run("ObservableReentrant", ltest.RunObservableReentrantTests)
+	t.Run("AggregateIndex", ltest.RunAggregateIndexTests)
 	t.Run("SinkIndex", ltest.RunSinkIndexTests)
 }
@@ -0,0 +1,185 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package test
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/go-luigi"
+	"github.com/ssbc/margaret/indexes"
+)
+
+// ObservableGCAddrs is the number of distinct addresses TestObservableGC requests.
+// It is lowered in short mode.
+var ObservableGCAddrs = 1 << 20
+
+func TestObservableGC(newIdx NewSeqSetterIndexFunc) func(*testing.T) {
+	return func(t *testing.T) {
+		ctx := context.Background()
+		r := require.New(t)
+
+		idx, err := newIdx(t.Name(), "str")
+		r.NoError(err, "error creating index")
+		defer idx.Close()
+
+		n := ObservableGCAddrs
+		if testing.Short() {
+			n = 1 << 14
+		}
+
+		// a registered observable has to keep receiving updates, an unregistered one has to keep returning the current value
+		watched, err := idx.Get(ctx, "watched")
+		r.NoError(err)
+		var (
+			l    sync.Mutex
+			seen []interface{}
+		)
+		cancel := watched.Register(luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+			l.Lock()
+			defer l.Unlock()
+			if err == nil {
+				seen = append(seen, v)
+			}
+			return nil
+		}))
+		defer cancel()
+
+		held, err := idx.Get(ctx, "held")
+		r.NoError(err)
+		r.NoError(idx.Set(ctx, "held", "before"))
+
+		hammer := func() uint64 {
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+
+			for i := 0; i < n; i++ {
+				obv, err := idx.Get(ctx, indexes.Addr(fmt.Sprintf("hammer/%08d", i)))
+				r.NoError(err)
+				_, err = obv.Value()
+				r.NoError(err)
+			}
+
+			runtime.GC()
+			runtime.ReadMemStats(&after)
+			if after.HeapAlloc < before.HeapAlloc {
+				return 0
+			}
+			return after.HeapAlloc - before.HeapAlloc
+		}
+
+		growth := hammer()
+		t.Logf("heap grew by %d bytes for %d addresses", growth, n)
+		// an observable per address takes a few hundred bytes
+		r.Less(growth/uint64(n), uint64(64), "memory grows with the number of requested addresses")
+
+		r.NoError(idx.Set(ctx, "watched", "after"))
+		r.NoError(idx.Set(ctx, "held", "after"))
+		hammer()
+
+		v, err := held.Value()
+		r.NoError(err)
+		r.Equal("after", v, "evicted value not reloaded")
+
+		l.Lock()
+		r.Contains(seen, "after", "registered observable was dropped")
+		l.Unlock()
+	}
+}
+
+func TestObservableReentrant(newIdx NewSeqSetterIndexFunc) func(*testing.T) {
+	return func(t *testing.T) {
+		ctx := context.Background()
+		r := require.New(t)
+
+		idx, err := newIdx(t.Name(), "str")
+		r.NoError(err, "error creating index")
+		defer idx.Close()
+
+		watched, err := idx.Get(ctx, "watched")
+		r.NoError(err)
+		other, err := idx.Get(ctx, "other")
+		r.NoError(err)
+		r.NoError(idx.Set(ctx, "other", "observed"))
+		// mapidx doesn't cache unobserved values, and loading them from inside a pour deadlocks
+		cancelOther := other.Register(luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error { return nil }))
+		defer cancelOther()
+
+		// the sink reads and registers while Set pours into it with the index lock held
+		var (
+			l       sync.Mutex
+			seen    []interface{}
+			cancels []func()
+		)
+		reads := make(chan interface{}, 2)
+		cancel := watched.Register(luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+			if err != nil || v != "after" {
+				return nil
+			}
+
+			self, err := watched.Value()
+			if err != nil {
+				return err
+			}
+			reads <- self
+
+			v, err = other.Value()
+			if err != nil {
+				return err
+			}
+			reads <- v
+
+			c := other.Register(luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+				l.Lock()
+				defer l.Unlock()
+				if err == nil {
+					seen = append(seen, v)
+				}
+				return nil
+			}))
+			l.Lock()
+			cancels = append(cancels, c)
+			l.Unlock()
+			return nil
+		}))
+		defer cancel()
+
+		done := make(chan error, 1)
+		go func() {
+			done <- idx.Set(ctx, "watched", "after")
+		}()
+		select {
+		case err := <-done:
+			r.NoError(err)
+		case <-time.After(10 * time.Second):
+			t.Fatal("Set deadlocked on a sink that calls into the index")
+		}
+		r.Equal("after", <-reads)
+		r.Equal("observed", <-reads)
+
+		// the registration from inside the pour works like any other
+		r.NoError(idx.Set(ctx, "other", "changed"))
+		r.Eventually(func() bool {
+			l.Lock()
+			defer l.Unlock()
+			return len(seen) > 0 && seen[len(seen)-1] == "changed"
+		}, 10*time.Second, 10*time.Millisecond)
+
+		// cancelling closes the sink, which takes l
+		l.Lock()
+		registered := cancels
+		l.Unlock()
+		for _, c := range registered {
+			c()
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/ssbc/go-luigi"
+	"github.com/ssbc/margaret/indexes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRangeIndex(newIdx NewSetterIndexFunc) func(*testing.T) {
+	return func(t *testing.T) {
+		ctx := context.Background()
+		r := require.New(t)
+
+		sidx, err := newIdx(t.Name(), "str")
+		r.NoError(err, "error creating index")
+
+		idx, ok := sidx.(indexes.RangeIndex)
+		if !ok {
+			t.Skip("index doesn't support ranges")
+		}
+
+		// more than one page of the range source
+		for i := 0; i < 300; i++ {
+			r.NoError(sidx.Set(ctx, indexes.Addr(fmt.Sprintf("a/%03d", i)), fmt.Sprint("val", i)))
+		}
+		for i := 0; i < 10; i++ {
+			r.NoError(sidx.Set(ctx, indexes.Addr(fmt.Sprintf("b/%03d", i)), fmt.Sprint("val", i)))
+		}
+		r.NoError(sidx.Set(ctx, "c", "last"))
+		r.NoError(sidx.Delete(ctx, "a/005"))
+
+		// the sequence isn't part of the range
+		if seqIdx, ok := sidx.(indexes.SeqSetterIndex); ok {
+			r.NoError(seqIdx.SetSeq(23))
+		}
+
+		keys := func(from, to indexes.Addr, reverse bool, limit int) []string {
+			src, err := idx.Range(ctx, from, to, reverse, limit)
+			r.NoError(err)
+
+			var out []string
+			for {
+				v, err := src.Next(ctx)
+				if luigi.IsEOS(err) {
+					break
+				}
+				r.NoError(err)
+				kv := v.(indexes.KeyValue)
+				out = append(out, string(kv.Key))
+			}
+			return out
+		}
+
+		var want []string
+		for i := 0; i < 300; i++ {
+			if i != 5 {
+				want = append(want, fmt.Sprintf("a/%03d", i))
+			}
+		}
+
+		src, err := indexes.RangePrefix(ctx, idx, "a/", false, -1)
+		r.NoError(err)
+		var got []string
+		for {
+			v, err := src.Next(ctx)
+			if luigi.IsEOS(err) {
+				break
+			}
+			r.NoError(err)
+			kv := v.(indexes.KeyValue)
+			r.Equal(fmt.Sprint("val", atoi(t, string(kv.Key[2:]))), kv.Value, "wrong value for %s", kv.Key)
+			got = append(got, string(kv.Key))
+		}
+		r.Equal(want, got, "prefix a/")
+
+		r.Equal([]string{"a/299", "a/298", "a/297", "a/296", "a/295"}, keys("a/", indexes.PrefixEnd("a/"), true, 5))
+		r.Equal([]string{"a/004", "a/006"}, keys("a/004", "a/007", false, -1))
+		r.Equal([]string{"a/006", "a/004"}, keys("a/004", "a/007", true, -1))
+		r.Len(keys("a/010", "a/020", false, -1), 10)
+		r.Len(keys("b/", "", false, -1), 11)
+		r.Equal([]string{"c", "b/009", "b/008"}, keys("", "", true, 3))
+		r.Len(keys("", "", false, -1), 310)
+		r.Len(keys("", "", true, -1), 310)
+		r.Len(keys("", "", false, 0), 0)
+		r.Len(keys("d", "", false, -1), 0)
+		r.Len(keys("", "a/", true, -1), 0)
+
+		// reverse ranges that end past the last key
+		r.Equal([]string{"c", "b/009"}, keys("", "z", true, 2))
+		r.Equal([]string{"c"}, keys("c", indexes.PrefixEnd("c"), true, -1))
+		r.Len(keys("b/", "z", true, -1), 11)
+
+		// reverse ranges with only a lower bound
+		r.Equal([]string{"c", "b/009", "b/008", "b/007", "b/006", "b/005"}, keys("b/005", "", true, -1))
+
+		// ranges after addresses were added and removed
+		r.NoError(sidx.Set(ctx, "b/010", "val10"))
+		r.NoError(sidx.Delete(ctx, "c"))
+		r.Equal([]string{"b/010", "b/009"}, keys("", "", true, 2))
+		r.Equal([]string{"b/009", "b/010"}, keys("b/009", "", false, -1))
+	}
+}
+
+func atoi(t *testing.T, s string) int {
+	var i int
+	_, err := fmt.Sscanf(s, "%d", &i)
+	require.NoError(t, err)
+	return i
+}
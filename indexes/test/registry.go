@@ -13,11 +13,15 @@ import (
 var (
 	NewSetterIndexFuncs    map[string]NewSetterIndexFunc
 	NewSeqSetterIndexFuncs map[string]NewSeqSetterIndexFunc
+	NewSetIndexFuncs       map[string]NewSetIndexFunc
+	NewAggregateIndexFuncs map[string]NewAggregateIndexFunc
 )
 
 func init() {
 	NewSetterIndexFuncs = map[string]NewSetterIndexFunc{}
 	NewSeqSetterIndexFuncs = map[string]NewSeqSetterIndexFunc{}
+	NewSetIndexFuncs = map[string]NewSetIndexFunc{}
+	NewAggregateIndexFuncs = map[string]NewAggregateIndexFunc{}
 }
 
 func RegisterSetterIndex(name string, f NewSetterIndexFunc) {
@@ -28,6 +32,14 @@ func RegisterSeqSetterIndex(name string, f NewSeqSetterIndexFunc) {
 	NewSeqSetterIndexFuncs[name] = f
 }
 
+func RegisterSetIndex(name string, f NewSetIndexFunc) {
+	NewSetIndexFuncs[name] = f
+}
+
+func RegisterAggregateIndex(name string, f NewAggregateIndexFunc) {
+	NewAggregateIndexFuncs[name] = f
+}
+
 func RunSetterIndexTests(t *testing.T) {
 	for name, newIndex := range NewSetterIndexFuncs {
 		t.Run(name, TestSetterIndex(newIndex))
@@ -40,6 +52,48 @@ func RunSeqSetterIndexTests(t *testing.T) {
 	}
 }
 
+func RunSetIndexTests(t *testing.T) {
+	for name, newIndex := range NewSetIndexFuncs {
+		t.Run(name, TestSetIndex(newIndex))
+	}
+}
+
+func RunRangeIndexTests(t *testing.T) {
+	for name, newIndex := range NewSetterIndexFuncs {
+		t.Run(name, TestRangeIndex(newIndex))
+	}
+}
+
+func RunTxnIndexTests(t *testing.T) {
+	for name, newIndex := range NewSeqSetterIndexFuncs {
+		t.Run(name, TestTxnIndex(newIndex))
+	}
+}
+
+func RunVersionedIndexTests(t *testing.T) {
+	for name, newIndex := range NewSeqSetterIndexFuncs {
+		t.Run(name, TestVersionedIndex(newIndex))
+	}
+}
+
+func RunObservableGCTests(t *testing.T) {
+	for name, newIndex := range NewSeqSetterIndexFuncs {
+		t.Run(name, TestObservableGC(newIndex))
+	}
+}
+
+func RunObservableReentrantTests(t *testing.T) {
+	for name, newIndex := range NewSeqSetterIndexFuncs {
+		t.Run(name, TestObservableReentrant(newIndex))
+	}
+}
+
+func RunAggregateIndexTests(t *testing.T) {
+	for name, newIndex := range NewAggregateIndexFuncs {
+		t.Run(name, TestAggregateIndex(newIndex))
+	}
+}
+
 func RunSinkIndexTests(t *testing.T) {
 	for logname, newLog := range mtest.NewLogFuncs {
 		for idxname, newSeqSetterIdx := range NewSeqSetterIndexFuncs {
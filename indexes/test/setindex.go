@@ -0,0 +1,188 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/ssbc/go-luigi"
+	"github.com/ssbc/margaret/indexes"
+	"github.com/stretchr/testify/require"
+)
+
+type NewSetIndexFunc func(name string) (indexes.SetIndex, error)
+
+func TestSetIndex(newIdx NewSetIndexFunc) func(*testing.T) {
+	return func(t *testing.T) {
+		t.Run("Sequential", TestSetIndexSequential(newIdx))
+		t.Run("Observable", TestSetIndexObservable(newIdx))
+		t.Run("Concurrent", TestSetIndexConcurrent(newIdx))
+	}
+}
+
+func members(t *testing.T, idx indexes.SetIndex, addr indexes.Addr) indexes.Members {
+	obv, err := idx.Members(context.Background(), addr)
+	require.NoError(t, err, "error getting members of %s", addr)
+
+	v, err := obv.Value()
+	require.NoError(t, err, "error getting value of %s", addr)
+	return v.(indexes.Members)
+}
+
+func TestSetIndexSequential(newIdx NewSetIndexFunc) func(*testing.T) {
+	return func(t *testing.T) {
+		ctx := context.Background()
+		r := require.New(t)
+
+		idx, err := newIdx(t.Name())
+		r.NoError(err, "error creating index")
+		defer idx.Close()
+
+		r.Len(members(t, idx, "follows"), 0)
+
+		for _, m := range []indexes.Addr{"carol", "alice", "bob", "alice"} {
+			r.NoError(idx.Add(ctx, "follows", m))
+		}
+		// an address that is a prefix of the other one
+		r.NoError(idx.Add(ctx, "follow", "dave"))
+
+		r.Equal(indexes.Members{"alice", "bob", "carol"}, members(t, idx, "follows"))
+		r.Equal(indexes.Members{"dave"}, members(t, idx, "follow"))
+
+		has, err := idx.Has(ctx, "follows", "bob")
+		r.NoError(err)
+		r.True(has)
+
+		has, err = idx.Has(ctx, "follows", "dave")
+		r.NoError(err)
+		r.False(has)
+
+		r.NoError(idx.Remove(ctx, "follows", "bob"))
+		r.NoError(idx.Remove(ctx, "follows", "nobody"))
+
+		has, err = idx.Has(ctx, "follows", "bob")
+		r.NoError(err)
+		r.False(has)
+
+		r.Equal(indexes.Members{"alice", "carol"}, members(t, idx, "follows"))
+		r.Equal(indexes.Members{"dave"}, members(t, idx, "follow"))
+	}
+}
+
+func TestSetIndexObservable(newIdx NewSetIndexFunc) func(*testing.T) {
+	return func(t *testing.T) {
+		ctx := context.Background()
+		r := require.New(t)
+
+		idx, err := newIdx(t.Name())
+		r.NoError(err, "error creating index")
+		defer idx.Close()
+
+		r.NoError(idx.Add(ctx, "blocks", "mallory"))
+
+		obv, err := idx.Members(ctx, "blocks")
+		r.NoError(err)
+
+		rxExp := []indexes.Members{
+			{"mallory"},
+			{"eve", "mallory"},
+			{"eve"},
+			{},
+		}
+
+		var (
+			l   sync.Mutex
+			got []indexes.Members
+		)
+		done := make(chan struct{})
+		first := make(chan struct{})
+		cancel := obv.Register(luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+			if err != nil {
+				return nil
+			}
+
+			l.Lock()
+			defer l.Unlock()
+			got = append(got, v.(indexes.Members))
+			switch len(got) {
+			case 1:
+				close(first)
+			case len(rxExp):
+				close(done)
+			}
+			return nil
+		}))
+		defer cancel()
+		<-first
+
+		r.NoError(idx.Add(ctx, "blocks", "eve"))
+		r.NoError(idx.Add(ctx, "blocks", "eve"), "adding again shouldn't emit")
+		r.NoError(idx.Remove(ctx, "blocks", "mallory"))
+		r.NoError(idx.Remove(ctx, "blocks", "mallory"), "removing again shouldn't emit")
+		r.NoError(idx.Add(ctx, "other", "eve"), "other sets shouldn't emit")
+		r.NoError(idx.Remove(ctx, "blocks", "eve"))
+
+		<-done
+
+		l.Lock()
+		defer l.Unlock()
+		r.Len(got, len(rxExp))
+		for i, exp := range rxExp {
+			r.ElementsMatch(exp, got[i], "value %d", i)
+		}
+	}
+}
+
+func TestSetIndexConcurrent(newIdx NewSetIndexFunc) func(*testing.T) {
+	return func(t *testing.T) {
+		ctx := context.Background()
+		r := require.New(t)
+
+		idx, err := newIdx(t.Name())
+		r.NoError(err, "error creating index")
+		defer idx.Close()
+
+		const workers, n = 8, 50
+
+		var wg sync.WaitGroup
+		errc := make(chan error, workers)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < n; i++ {
+					m := indexes.Addr(fmt.Sprintf("%02d-%03d", w, i))
+					if err := idx.Add(ctx, "followers", m); err != nil {
+						errc <- err
+						return
+					}
+					// remove every other one again
+					if i%2 == 1 {
+						if err := idx.Remove(ctx, "followers", m); err != nil {
+							errc <- err
+							return
+						}
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+		close(errc)
+		for err := range errc {
+			r.NoError(err)
+		}
+
+		ms := members(t, idx, "followers")
+		r.Len(ms, workers*n/2)
+		for w := 0; w < workers; w++ {
+			for i := 0; i < n; i += 2 {
+				r.True(ms.Has(indexes.Addr(fmt.Sprintf("%02d-%03d", w, i))))
+			}
+		}
+	}
+}
@@ -0,0 +1,192 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/indexes"
+)
+
+func TestTxnIndex(newIdx NewSeqSetterIndexFunc) func(*testing.T) {
+	return func(t *testing.T) {
+		t.Run("CommitAndDiscard", TestTxnIndexCommitAndDiscard(newIdx))
+		t.Run("SinkIndex", TestTxnIndexSinkIndex(newIdx))
+		t.Run("PlainSinkIndex", TestTxnIndexPlainSinkIndex(newIdx))
+	}
+}
+
+func value(t *testing.T, idx indexes.Index, addr indexes.Addr) interface{} {
+	obv, err := idx.Get(context.Background(), addr)
+	require.NoError(t, err, "error getting observable of %s", addr)
+
+	v, err := obv.Value()
+	require.NoError(t, err, "error getting value of %s", addr)
+	return v
+}
+
+func TestTxnIndexCommitAndDiscard(newIdx NewSeqSetterIndexFunc) func(*testing.T) {
+	return func(t *testing.T) {
+		ctx := context.Background()
+		r := require.New(t)
+
+		seqIdx, err := newIdx(t.Name(), "str")
+		r.NoError(err, "error creating index")
+		defer seqIdx.Close()
+
+		idx, ok := seqIdx.(indexes.TxnIndex)
+		if !ok {
+			t.Skip("index doesn't support transactions")
+		}
+
+		r.NoError(idx.Set(ctx, "gone", "soon"))
+		r.NoError(idx.SetSeq(0))
+
+		// observe one of the addresses, it should only change on commit
+		obv, err := idx.Get(ctx, "a")
+		r.NoError(err)
+
+		txn, err := idx.Begin(ctx)
+		r.NoError(err)
+		r.NoError(txn.Set(ctx, "a", "one"))
+		r.NoError(txn.Set(ctx, "b", "two"))
+		r.NoError(txn.Set(ctx, "a", "three"))
+		r.NoError(txn.Delete(ctx, "gone"))
+
+		// the transaction sees its own changes, the index doesn't
+		txnObv, err := txn.Get(ctx, "a")
+		r.NoError(err)
+		v, err := txnObv.Value()
+		r.NoError(err)
+		r.Equal("three", v)
+
+		v, err = obv.Value()
+		r.NoError(err)
+		r.Equal(indexes.UnsetValue{Addr: "a"}, v)
+		r.Equal("soon", value(t, idx, "gone"))
+
+		seq, err := idx.GetSeq()
+		r.NoError(err)
+		r.EqualValues(0, seq)
+
+		r.NoError(txn.Commit(1))
+		r.ErrorIs(txn.Commit(2), indexes.ErrTxnDone)
+		r.ErrorIs(txn.Set(ctx, "c", "too late"), indexes.ErrTxnDone)
+
+		v, err = obv.Value()
+		r.NoError(err)
+		r.Equal("three", v, "observable not updated")
+		r.Equal("two", value(t, idx, "b"))
+		r.Equal(indexes.UnsetValue{Addr: "gone"}, value(t, idx, "gone"))
+
+		seq, err = idx.GetSeq()
+		r.NoError(err)
+		r.EqualValues(1, seq)
+
+		// discarded changes are never written
+		txn, err = idx.Begin(ctx)
+		r.NoError(err)
+		r.NoError(txn.Set(ctx, "a", "discarded"))
+		txn.Discard()
+		txn.Discard()
+		r.ErrorIs(txn.Commit(2), indexes.ErrTxnDone)
+
+		r.Equal("three", value(t, idx, "a"))
+		seq, err = idx.GetSeq()
+		r.NoError(err)
+		r.EqualValues(1, seq)
+	}
+}
+
+func TestTxnIndexSinkIndex(newIdx NewSeqSetterIndexFunc) func(*testing.T) {
+	return func(t *testing.T) {
+		ctx := context.Background()
+		r := require.New(t)
+
+		seqIdx, err := newIdx(t.Name(), "str")
+		r.NoError(err, "error creating index")
+
+		txnIdx, ok := seqIdx.(indexes.TxnIndex)
+		if !ok {
+			seqIdx.Close()
+			t.Skip("index doesn't support transactions")
+		}
+
+		errFail := errors.New("processing failed")
+		f := func(ctx context.Context, seq int64, v interface{}, idx indexes.SetterIndex) error {
+			if err := idx.Set(ctx, "last", v); err != nil {
+				return err
+			}
+
+			// reads see the changes of the same entry
+			obv, err := idx.Get(ctx, "last")
+			if err != nil {
+				return err
+			}
+			got, err := obv.Value()
+			if err != nil {
+				return err
+			}
+			if got != v {
+				return errors.New("transaction didn't return its own change")
+			}
+
+			if v == "fail" {
+				return errFail
+			}
+			return idx.Set(ctx, "count", v)
+		}
+
+		sink := indexes.NewTxnSinkIndex(f, txnIdx)
+		defer sink.Close()
+
+		r.NoError(sink.Pour(ctx, margaret.WrapWithSeq("0", 0)))
+		r.NoError(sink.Pour(ctx, margaret.WrapWithSeq("1", 1)))
+		r.ErrorIs(sink.Pour(ctx, margaret.WrapWithSeq("fail", 2)), errFail)
+
+		// nothing of the failed entry is stored
+		r.Equal("1", value(t, seqIdx, "last"))
+		r.Equal("1", value(t, seqIdx, "count"))
+
+		seq, err := seqIdx.GetSeq()
+		r.NoError(err)
+		r.EqualValues(1, seq)
+	}
+}
+
+// TestTxnIndexPlainSinkIndex checks that NewSinkIndex keeps passing the index itself to the processing function.
+func TestTxnIndexPlainSinkIndex(newIdx NewSeqSetterIndexFunc) func(*testing.T) {
+	return func(t *testing.T) {
+		ctx := context.Background()
+		r := require.New(t)
+
+		seqIdx, err := newIdx(t.Name(), "str")
+		r.NoError(err, "error creating index")
+
+		f := func(ctx context.Context, seq int64, v interface{}, idx indexes.SetterIndex) error {
+			if idx != indexes.SetterIndex(seqIdx) {
+				return fmt.Errorf("processing function got %T instead of the index", idx)
+			}
+			return idx.Set(ctx, "last", v)
+		}
+
+		sink := indexes.NewSinkIndex(f, seqIdx)
+		defer sink.Close()
+
+		r.NoError(sink.Pour(ctx, margaret.WrapWithSeq("0", 0)))
+		r.NoError(seqIdx.Flush())
+		r.Equal("0", value(t, seqIdx, "last"))
+
+		seq, err := seqIdx.GetSeq()
+		r.NoError(err)
+		r.EqualValues(0, seq)
+	}
+}
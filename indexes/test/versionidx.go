@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/go-luigi"
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/indexes"
+	"github.com/ssbc/margaret/mem"
+)
+
+func TestVersionedIndex(newIdx NewSeqSetterIndexFunc) func(*testing.T) {
+	return func(t *testing.T) {
+		ctx := context.Background()
+		r := require.New(t)
+
+		seqIdx, err := newIdx(t.Name(), "str")
+		r.NoError(err, "error creating index")
+
+		idx, ok := seqIdx.(indexes.VersionedIndex)
+		if !ok {
+			seqIdx.Close()
+			t.Skip("index doesn't support versions")
+		}
+
+		log := mem.New()
+		for i := 0; i < 10; i++ {
+			_, err := log.Append(fmt.Sprint(i))
+			r.NoError(err)
+		}
+
+		// the processing function of each version stores the entries differently
+		version := uint64(1)
+		f := func(ctx context.Context, seq int64, v interface{}, idx indexes.SetterIndex) error {
+			return idx.Set(ctx, indexes.Addr(fmt.Sprintf("v%d/%s", version, v)), v)
+		}
+
+		rebuild := func(sink indexes.SinkIndex) []int64 {
+			var seqs []int64
+			want := log.Seq()
+			err := indexes.Rebuild(ctx, log, sink, func(seq, target int64) {
+				r.Equal(want, target)
+				seqs = append(seqs, seq)
+			})
+			r.NoError(err)
+			return seqs
+		}
+
+		sink, reset, err := indexes.NewVersionedSinkIndex(f, idx, version)
+		r.NoError(err)
+		r.True(reset, "a new index should be reset to the first version")
+		r.Len(rebuild(sink), 10)
+
+		// the same version continues where it stopped
+		_, err = log.Append("10")
+		r.NoError(err)
+		sink, reset, err = indexes.NewVersionedSinkIndex(f, idx, version)
+		r.NoError(err)
+		r.False(reset)
+		r.Equal([]int64{10}, rebuild(sink))
+		r.Equal("3", value(t, idx, "v1/3"))
+
+		obv, err := idx.Get(ctx, "v1/3")
+		r.NoError(err)
+
+		// a new version drops everything
+		version = 2
+		sink, reset, err = indexes.NewVersionedSinkIndex(f, idx, version)
+		r.NoError(err)
+		r.True(reset)
+
+		got, err := idx.GetVersion()
+		r.NoError(err)
+		r.EqualValues(2, got)
+		seq, err := idx.GetSeq()
+		r.NoError(err)
+		r.EqualValues(margaret.SeqEmpty, seq)
+
+		v, err := obv.Value()
+		r.NoError(err)
+		r.Equal(indexes.UnsetValue{Addr: "v1/3"}, v, "observable not updated")
+
+		r.Len(rebuild(sink), 11)
+		r.Equal("3", value(t, idx, "v2/3"))
+		r.Equal(indexes.UnsetValue{Addr: "v1/3"}, value(t, idx, "v1/3"))
+
+		seq, err = idx.GetSeq()
+		r.NoError(err)
+		r.EqualValues(10, seq)
+
+		// the version isn't one of the values
+		if rangeIdx, ok := seqIdx.(indexes.RangeIndex); ok {
+			src, err := rangeIdx.Range(ctx, "", "", false, -1)
+			r.NoError(err)
+
+			var n int
+			for {
+				_, err := src.Next(ctx)
+				if luigi.IsEOS(err) {
+					break
+				}
+				r.NoError(err)
+				n++
+			}
+			r.Equal(11, n)
+		}
+
+		r.NoError(sink.Close())
+	}
+}
@@ -0,0 +1,157 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package indexes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ssbc/go-luigi"
+	"github.com/ssbc/margaret"
+)
+
+// ErrTxnDone is returned when a transaction is used after it was committed or discarded.
+var ErrTxnDone = errors.New("indexes: transaction already committed or discarded")
+
+// Txn collects changes to an index that are written together.
+// Reads through the index don't see the changes before they are committed.
+type Txn interface {
+	// Get returns the value set or deleted in the transaction in a detached observable.
+	// Addresses that the transaction didn't touch are read from the index.
+	Get(context.Context, Addr) (luigi.Observable, error)
+
+	Setter
+
+	// Commit writes all the changes together with the new sequence of the index.
+	// Either all of them are stored or none.
+	Commit(seq int64) error
+
+	// Discard drops the changes. It does nothing if the transaction is already done.
+	Discard()
+}
+
+// TxnIndex is an index that can apply multiple changes and its new sequence atomically.
+// The sink index returned by NewTxnSinkIndex uses one transaction per log entry.
+type TxnIndex interface {
+	SeqSetterIndex
+
+	// Begin starts a new transaction.
+	Begin(context.Context) (Txn, error)
+}
+
+// TxnOp is a change collected by a transaction made with NewTxn.
+type TxnOp struct {
+	Addr Addr
+
+	// Value is the value that was set, or an UnsetValue for deletions.
+	Value interface{}
+
+	// Data is Value encoded with the codec of the index. It is nil for deletions.
+	Data []byte
+
+	// Delete is true if Addr was deleted.
+	Delete bool
+}
+
+// CommitFunc writes the changes of a transaction together with the new sequence of the index, in one transaction of its storage.
+// Once they are stored, it also updates the sequence and the observables of the index.
+type CommitFunc func(ops []TxnOp, seq int64) error
+
+// NewTxn returns a transaction that keeps its changes in memory until Commit hands them to commit.
+// Set encodes values with codec right away, so that encoding errors are returned by Set.
+// Get reads the addresses that the transaction didn't touch from idx.
+func NewTxn(idx Index, codec margaret.Codec, commit CommitFunc) Txn {
+	return &txn{
+		idx:     idx,
+		codec:   codec,
+		commit:  commit,
+		pending: make(map[Addr]interface{}),
+	}
+}
+
+type txn struct {
+	idx    Index
+	codec  margaret.Codec
+	commit CommitFunc
+
+	l       sync.Mutex
+	ops     []TxnOp
+	pending map[Addr]interface{}
+	done    bool
+}
+
+func (tx *txn) Get(ctx context.Context, addr Addr) (luigi.Observable, error) {
+	tx.l.Lock()
+	v, ok := tx.pending[addr]
+	tx.l.Unlock()
+
+	if !ok {
+		return tx.idx.Get(ctx, addr)
+	}
+	return roObv{luigi.NewObservable(v)}, nil
+}
+
+func (tx *txn) Set(ctx context.Context, addr Addr, v interface{}) error {
+	data, err := tx.codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error encoding value of %q: %w", addr, err)
+	}
+	return tx.add(TxnOp{Addr: addr, Value: v, Data: data})
+}
+
+func (tx *txn) Delete(ctx context.Context, addr Addr) error {
+	return tx.add(TxnOp{Addr: addr, Value: UnsetValue{Addr: addr}, Delete: true})
+}
+
+func (tx *txn) add(op TxnOp) error {
+	tx.l.Lock()
+	defer tx.l.Unlock()
+
+	if tx.done {
+		return ErrTxnDone
+	}
+	tx.ops = append(tx.ops, op)
+	tx.pending[op.Addr] = op.Value
+	return nil
+}
+
+func (tx *txn) Discard() {
+	tx.l.Lock()
+	defer tx.l.Unlock()
+	tx.done = true
+	tx.ops = nil
+}
+
+func (tx *txn) Commit(seq int64) error {
+	tx.l.Lock()
+	defer tx.l.Unlock()
+
+	if tx.done {
+		return ErrTxnDone
+	}
+	tx.done = true
+
+	err := tx.commit(tx.ops, seq)
+	tx.ops = nil
+	return err
+}
+
+// roObv is a detached observable of a pending value.
+type roObv struct {
+	luigi.Observable
+}
+
+func (obv roObv) Set(interface{}) error {
+	return errors.New("read-only observable")
+}
+
+// txnSetterIndex passes a transaction to a StreamProcFunc.
+type txnSetterIndex struct {
+	Txn
+}
+
+func (txnSetterIndex) Flush() error { return nil }
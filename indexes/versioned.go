@@ -0,0 +1,105 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package indexes
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ssbc/go-luigi"
+	"github.com/ssbc/margaret"
+)
+
+// VersionedIndex is a SeqSetterIndex that stores a version next to its sequence.
+// The version belongs to the code that fills the index, usually a StreamProcFunc,
+// and should be increased whenever that code changes the values it stores.
+type VersionedIndex interface {
+	SeqSetterIndex
+
+	// GetVersion returns the stored version, or zero if none was stored yet.
+	GetVersion() (uint64, error)
+
+	// SetVersion stores v as the version of the index.
+	SetVersion(v uint64) error
+
+	// Reset removes all values, the sequence and the version from the index.
+	Reset() error
+}
+
+// CheckVersion compares version with the one stored in idx.
+// If they differ, the index is reset and version is stored, so that it is rebuilt from margaret.SeqEmpty.
+// It returns true if the index was reset.
+func CheckVersion(idx VersionedIndex, version uint64) (bool, error) {
+	stored, err := idx.GetVersion()
+	if err != nil {
+		return false, fmt.Errorf("error getting index version: %w", err)
+	}
+	if stored == version {
+		return false, nil
+	}
+
+	if err := idx.Reset(); err != nil {
+		return false, fmt.Errorf("error resetting index of version %d: %w", stored, err)
+	}
+	// if this fails the index is reset again on the next open, which is fine since it is empty
+	if err := idx.SetVersion(version); err != nil {
+		return true, fmt.Errorf("error setting index version: %w", err)
+	}
+	return true, nil
+}
+
+// NewVersionedSinkIndex is like NewSinkIndex, but calls CheckVersion on idx first.
+// It returns true if the index was reset and needs to be rebuilt, see Rebuild.
+func NewVersionedSinkIndex(f StreamProcFunc, idx VersionedIndex, version uint64) (SinkIndex, bool, error) {
+	reset, err := CheckVersion(idx, version)
+	if err != nil {
+		return nil, false, err
+	}
+	return NewSinkIndex(f, idx), reset, nil
+}
+
+// ProgressFunc is called by Rebuild after each entry that was poured into the sink index.
+// target is the sequence of the log when Rebuild was called.
+type ProgressFunc func(seq, target int64)
+
+// Rebuild pours all entries of log that sink hasn't processed yet into it, up to the current sequence of the log.
+// If progress isn't nil, it is called after each entry.
+func Rebuild(ctx context.Context, log margaret.Log, sink SinkIndex, progress ProgressFunc) error {
+	target := log.Seq()
+	if target == margaret.SeqEmpty {
+		return nil
+	}
+
+	src, err := log.Query(sink.QuerySpec(), margaret.Lte(target), margaret.Live(false))
+	if err != nil {
+		return fmt.Errorf("error querying log: %w", err)
+	}
+
+	err = luigi.Pump(ctx, progressSink{SinkIndex: sink, target: target, progress: progress}, src)
+	if err != nil {
+		return fmt.Errorf("error rebuilding index: %w", err)
+	}
+	return nil
+}
+
+// progressSink calls progress after pouring an entry into the sink index, and doesn't close it.
+type progressSink struct {
+	SinkIndex
+
+	target   int64
+	progress ProgressFunc
+}
+
+func (ps progressSink) Pour(ctx context.Context, v interface{}) error {
+	if err := ps.SinkIndex.Pour(ctx, v); err != nil {
+		return err
+	}
+	if sw, ok := v.(margaret.SeqWrapper); ok && ps.progress != nil {
+		ps.progress(sw.Seq(), ps.target)
+	}
+	return nil
+}
+
+func (ps progressSink) Close() error { return nil }
@@ -0,0 +1,96 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package frame holds what the codecs that wrap values in their own frames have in common.
+//
+// Those codecs write a header, the length of the data as a varint, and the data itself.
+// Their decoders have to read one frame at a time from a stream, return io.EOF as it is at the end of the stream,
+// and treat a frame that ends early as broken.
+package frame
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MaxSize limits the size of the data of a single frame, so that broken frames don't allocate huge buffers.
+const MaxSize = 1 << 30
+
+// ByteReader is what decoders need to read varints and data from a stream.
+type ByteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// NewReader returns r if it already is a ByteReader and buffers it otherwise.
+func NewReader(r io.Reader) ByteReader {
+	br, ok := r.(ByteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return br
+}
+
+// AppendUvarint appends v as a varint to dst.
+func AppendUvarint(dst []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(dst, buf[:n]...)
+}
+
+// Append appends data with its length in front of it to dst.
+func Append(dst, data []byte) []byte {
+	return append(AppendUvarint(dst, uint64(len(data))), data...)
+}
+
+// WrapStart wraps err, returned while reading the start of a frame, with msg.
+// io.EOF is returned as it is, since it marks the end of the stream instead of a broken frame
+// and readers like offset2 compare against it.
+func WrapStart(err error, msg string) error {
+	if err == io.EOF {
+		return err
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
+
+// Read reads data written by Append, after the start of a frame, and fails if it's longer than max.
+// The frame already started, so the end of the stream is reported as io.ErrUnexpectedEOF.
+func Read(r ByteReader, max uint64) ([]byte, error) {
+	data, err := ReadStart(r, max)
+	if errors.Is(err, io.EOF) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, err
+}
+
+// ReadStart is like Read, for frames that start with the length.
+// If the stream ends before the frame, it returns io.EOF as it is.
+func ReadStart(r ByteReader, max uint64) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err == io.EOF {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading frame size: %w", noEOF(err))
+	}
+	if size > max {
+		return nil, fmt.Errorf("frame of %d bytes is too big", size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("error reading frame: %w", noEOF(err))
+	}
+	return data, nil
+}
+
+// noEOF turns io.EOF into io.ErrUnexpectedEOF, for frames that end after they started.
+func noEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
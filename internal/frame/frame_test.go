@@ -0,0 +1,60 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package frame
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRead(t *testing.T) {
+	r := require.New(t)
+
+	var stream []byte
+	stream = Append(stream, []byte("hello"))
+	stream = Append(stream, nil)
+	stream = Append(stream, bytes.Repeat([]byte{1}, 300))
+
+	br := NewReader(bytes.NewReader(stream))
+	data, err := ReadStart(br, MaxSize)
+	r.NoError(err)
+	r.Equal([]byte("hello"), data)
+
+	data, err = Read(br, MaxSize)
+	r.NoError(err)
+	r.Len(data, 0)
+
+	data, err = Read(br, MaxSize)
+	r.NoError(err)
+	r.Len(data, 300)
+
+	_, err = ReadStart(br, MaxSize)
+	r.True(err == io.EOF, "expected plain io.EOF, got %v", err)
+
+	_, err = ReadStart(NewReader(bytes.NewReader(Append(nil, data))), 299)
+	r.Error(err, "frame bigger than max")
+
+	// frames that end early
+	for _, broken := range [][]byte{stream[:3], {0x80}} {
+		_, err = ReadStart(NewReader(bytes.NewReader(broken)), MaxSize)
+		r.True(errors.Is(err, io.ErrUnexpectedEOF), "unexpected error: %v", err)
+	}
+	_, err = Read(NewReader(bytes.NewReader(nil)), MaxSize)
+	r.True(errors.Is(err, io.ErrUnexpectedEOF), "unexpected error: %v", err)
+}
+
+func TestWrapStart(t *testing.T) {
+	r := require.New(t)
+
+	r.True(WrapStart(io.EOF, "test") == io.EOF)
+
+	err := WrapStart(io.ErrUnexpectedEOF, "test")
+	r.EqualError(err, "test: unexpected EOF")
+	r.True(errors.Is(err, io.ErrUnexpectedEOF))
+}
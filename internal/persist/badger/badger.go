@@ -22,7 +22,10 @@ type BadgerSaver struct {
 	keyPrefix []byte
 }
 
-var _ persist.Saver = (*BadgerSaver)(nil)
+var (
+	_ persist.Saver       = (*BadgerSaver)(nil)
+	_ persist.RangeLister = (*BadgerSaver)(nil)
+)
 
 // Close closes the backing database if it's not shared.
 func (sl *BadgerSaver) Close() error {
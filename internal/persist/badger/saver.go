@@ -127,6 +127,65 @@ func (s BadgerSaver) List() ([]persist.Key, error) {
 	return keys, nil
 }
 
+func (s BadgerSaver) ListPrefix(prefix persist.Key) ([]persist.Key, error) {
+	actualPrefix := append(append([]byte{}, s.keyPrefix...), prefix...)
+
+	var keys []persist.Key
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = actualPrefix
+		iter := txn.NewIterator(opts)
+		defer iter.Close()
+
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			keys = append(keys, s.trimmedKey(iter.Item()))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (s BadgerSaver) ListRange(from persist.Key, limit int) ([]persist.Key, error) {
+	actualFrom := append(append([]byte{}, s.keyPrefix...), from...)
+
+	var keys []persist.Key
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = s.keyPrefix
+		iter := txn.NewIterator(opts)
+		defer iter.Close()
+
+		for iter.Seek(actualFrom); iter.Valid(); iter.Next() {
+			if limit >= 0 && len(keys) >= limit {
+				break
+			}
+			keys = append(keys, s.trimmedKey(iter.Item()))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// trimmedKey returns a copy of the items key without the keyPrefix
+func (s BadgerSaver) trimmedKey(it *badger.Item) persist.Key {
+	k := bytes.TrimPrefix(it.Key(), s.keyPrefix)
+
+	// we need to make a copy of the key since badger reuses the slice on the next iteration
+	var trimmedKey = make([]byte, len(k))
+	copy(trimmedKey, k)
+	return persist.Key(trimmedKey)
+}
+
 func (s BadgerSaver) Delete(rm persist.Key) error {
 	actualKey := append(s.keyPrefix, []byte(rm)...)
 	return s.db.Update(func(txn *badger.Txn) error {
@@ -0,0 +1,89 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package bolt
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/ssbc/margaret/internal/persist"
+)
+
+// DefaultBucket is the bucket standalone savers keep their keys in.
+var DefaultBucket = []byte("persisted_roaring")
+
+type BoltSaver struct {
+	db *bbolt.DB
+
+	// shared means the backing db is shared with other indexes
+	// this controls the closing behavior.
+	// shared instances need to be closed independantly.
+	shared bool
+
+	bucket []byte
+}
+
+var (
+	_ persist.Saver       = (*BoltSaver)(nil)
+	_ persist.RangeLister = (*BoltSaver)(nil)
+)
+
+// Close closes the backing database if it's not shared.
+func (sl *BoltSaver) Close() error {
+	if sl.shared {
+		return nil
+	}
+	return sl.db.Close()
+}
+
+// NewStandalone opens (or creates) the bolt database in the directory at path.
+func NewStandalone(path string) (*BoltSaver, error) {
+	err := os.MkdirAll(path, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("persist/bolt: failed to create path location: %w", err)
+	}
+
+	dbPath := filepath.Join(path, "roaring.db")
+	db, err := bbolt.Open(dbPath, 0600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("persist/bolt: failed to open database %s: %w", dbPath, err)
+	}
+
+	s, err := newSaver(db, DefaultBucket)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewShared stores the keys in bucket of db, which is created if it doesn't exist yet.
+// Closing the saver doesn't close db.
+func NewShared(db *bbolt.DB, bucket []byte) (*BoltSaver, error) {
+	s, err := newSaver(db, bucket)
+	if err != nil {
+		return nil, err
+	}
+	s.shared = true
+	return s, nil
+}
+
+func newSaver(db *bbolt.DB, bucket []byte) (*BoltSaver, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("persist/bolt: failed to create bucket %q: %w", bucket, err)
+	}
+
+	return &BoltSaver{
+		db:     db,
+		bucket: bucket,
+	}, nil
+}
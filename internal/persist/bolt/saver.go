@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package bolt
+
+import (
+	"bytes"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/ssbc/margaret/internal/persist"
+)
+
+func (s BoltSaver) Put(key persist.Key, data []byte) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(s.bucket).Put(key, data)
+	})
+}
+
+func (s BoltSaver) PutMultiple(values []persist.KeyValuePair) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		for i, kv := range values {
+			err := b.Put(kv.Key, kv.Value)
+			if err != nil {
+				return fmt.Errorf("persist/bolt: failed to put entry %d of %d (%s): %w", i, len(values), kv.Key, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s BoltSaver) Get(key persist.Key) ([]byte, error) {
+	var data []byte
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		// values are only valid during the transaction
+		v := tx.Bucket(s.bucket).Get(key)
+		if len(v) > 0 {
+			data = append([]byte{}, v...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return nil, persist.ErrNotFound
+	}
+
+	return data, nil
+}
+
+func (s BoltSaver) List() ([]persist.Key, error) {
+	return s.ListPrefix(nil)
+}
+
+func (s BoltSaver) ListPrefix(prefix persist.Key) ([]persist.Key, error) {
+	var keys []persist.Key
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(s.bucket).Cursor()
+		for k, _ := seek(c, prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			keys = append(keys, copyKey(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (s BoltSaver) ListRange(from persist.Key, limit int) ([]persist.Key, error) {
+	var keys []persist.Key
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(s.bucket).Cursor()
+		for k, _ := seek(c, from); k != nil; k, _ = c.Next() {
+			if limit >= 0 && len(keys) >= limit {
+				break
+			}
+			keys = append(keys, copyKey(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// seek moves c to the first key that is equal to or greater than from.
+func seek(c *bbolt.Cursor, from persist.Key) ([]byte, []byte) {
+	if len(from) == 0 {
+		return c.First()
+	}
+	return c.Seek(from)
+}
+
+// copyKey returns a copy of k, since bolt reuses the memory after the transaction
+func copyKey(k []byte) persist.Key {
+	return persist.Key(append([]byte{}, k...))
+}
+
+func (s BoltSaver) Delete(rm persist.Key) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(s.bucket).Delete(rm)
+	})
+}
@@ -10,6 +10,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -20,7 +21,10 @@ type Saver struct {
 	base string
 }
 
-var _ persist.Saver = (*Saver)(nil)
+var (
+	_ persist.Saver       = (*Saver)(nil)
+	_ persist.RangeLister = (*Saver)(nil)
+)
 
 func New(base string) *Saver {
 	os.MkdirAll(base, 0700)
@@ -101,6 +105,112 @@ func (s Saver) List() ([]persist.Key, error) {
 	return list, nil
 }
 
+// ListPrefix returns all keys starting with prefix.
+// It only descends into the directories that can hold such keys.
+func (s Saver) ListPrefix(prefix persist.Key) ([]persist.Key, error) {
+	hexPrefix := hex.EncodeToString(prefix)
+	return s.listMatching(
+		func(dir string) bool {
+			return strings.HasPrefix(dir, hexPrefix) || strings.HasPrefix(hexPrefix, dir)
+		},
+		func(hexKey string) bool {
+			return strings.HasPrefix(hexKey, hexPrefix)
+		},
+		-1,
+	)
+}
+
+func (s Saver) ListRange(from persist.Key, limit int) ([]persist.Key, error) {
+	hexFrom := hex.EncodeToString(from)
+	dirFrom := hexFrom
+	if len(dirFrom) > 5 {
+		dirFrom = dirFrom[:5]
+	}
+	return s.listMatching(
+		func(dir string) bool {
+			return dir >= dirFrom
+		},
+		func(hexKey string) bool {
+			return hexKey >= hexFrom
+		},
+		limit,
+	)
+}
+
+// listMatching returns the sorted keys for which keyOk returns true, up to limit of them if it isn't negative.
+// Keys are read in groups of the same first five hex digits, which is the sub-directory that holds the longer ones,
+// so groups after the one that reaches the limit and groups for which dirOk returns false are never read.
+func (s Saver) listMatching(dirOk, keyOk func(string) bool, limit int) ([]persist.Key, error) {
+	entries, err := ioutil.ReadDir(s.base)
+	if err != nil {
+		return nil, errors.Wrap(err, "persist/fs: failed to read base directory")
+	}
+
+	// short keys are files in the base directory, they are sorted in with the sub-directory of their first digits
+	var (
+		groups []string
+		files  = make(map[string][]string)
+		isDir  = make(map[string]bool)
+	)
+	for _, e := range entries {
+		group := e.Name()
+		if !e.IsDir() && len(group) > 5 {
+			group = group[:5]
+		}
+		if _, ok := files[group]; !ok && !isDir[group] {
+			groups = append(groups, group)
+		}
+		if e.IsDir() {
+			isDir[group] = true
+		} else {
+			files[group] = append(files[group], e.Name())
+		}
+	}
+	// every key in a group starts with its name, so sorted groups hold sorted keys
+	sort.Strings(groups)
+
+	var hexKeys []string
+	for _, group := range groups {
+		if limit >= 0 && len(hexKeys) >= limit {
+			break
+		}
+		if !dirOk(group) {
+			continue
+		}
+
+		candidates := files[group]
+		if isDir[group] {
+			dirFiles, err := ioutil.ReadDir(filepath.Join(s.base, group))
+			if err != nil {
+				return nil, errors.Wrap(err, "persist/fs: failed to read sub directory")
+			}
+			for _, f := range dirFiles {
+				candidates = append(candidates, group+f.Name())
+			}
+		}
+		sort.Strings(candidates)
+
+		for _, hexKey := range candidates {
+			if keyOk(hexKey) {
+				hexKeys = append(hexKeys, hexKey)
+			}
+		}
+	}
+
+	if limit >= 0 && len(hexKeys) > limit {
+		hexKeys = hexKeys[:limit]
+	}
+
+	list := make([]persist.Key, len(hexKeys))
+	for i, hk := range hexKeys {
+		list[i], err = hex.DecodeString(hk)
+		if err != nil {
+			return nil, errors.Wrap(err, "roaringfiles: invalid path")
+		}
+	}
+	return list, nil
+}
+
 func (s Saver) Delete(k persist.Key) error {
 	fname := s.fnameForKey(k)
 	err := os.Remove(fname)
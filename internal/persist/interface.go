@@ -5,9 +5,11 @@
 package persist
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"sort"
 )
 
 type Key []byte
@@ -30,7 +32,72 @@ type Saver interface {
 	Delete(Key) error
 }
 
+// RangeLister is implemented by savers that can list a subset of their keys
+// in order, without walking (or loading) everything that is stored.
+type RangeLister interface {
+	// ListPrefix returns all keys that start with prefix in ascending order.
+	ListPrefix(prefix Key) ([]Key, error)
+
+	// ListRange returns up to limit keys that are equal to or greater than from, in ascending order.
+	// A negative limit returns all of them.
+	ListRange(from Key, limit int) ([]Key, error)
+}
+
 type KeyValuePair struct {
 	Key   Key
 	Value []byte
 }
+
+// ListPrefix returns all keys in s that start with prefix in ascending order.
+// It uses the RangeLister implementation of s if it has one and falls back to filtering List() otherwise.
+func ListPrefix(s Saver, prefix Key) ([]Key, error) {
+	if rl, ok := s.(RangeLister); ok {
+		return rl.ListPrefix(prefix)
+	}
+
+	all, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	SortKeys(all)
+
+	var keys []Key
+	for _, k := range all {
+		if bytes.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// ListRange returns up to limit keys of s that are equal to or greater than from, in ascending order.
+// It uses the RangeLister implementation of s if it has one and falls back to filtering List() otherwise.
+func ListRange(s Saver, from Key, limit int) ([]Key, error) {
+	if rl, ok := s.(RangeLister); ok {
+		return rl.ListRange(from, limit)
+	}
+
+	all, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	SortKeys(all)
+
+	var keys []Key
+	for _, k := range all {
+		if limit >= 0 && len(keys) >= limit {
+			break
+		}
+		if bytes.Compare(k, from) >= 0 {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// SortKeys sorts the passed keys in ascending byte order.
+func SortKeys(keys []Key) {
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+}
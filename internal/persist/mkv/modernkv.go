@@ -16,7 +16,10 @@ type ModernSaver struct {
 	db *kv.DB
 }
 
-var _ persist.Saver = (*ModernSaver)(nil)
+var (
+	_ persist.Saver       = (*ModernSaver)(nil)
+	_ persist.RangeLister = (*ModernSaver)(nil)
+)
 
 func (sl ModernSaver) Close() error {
 	return sl.db.Close()
@@ -43,6 +43,9 @@ func (s ModernSaver) Put(key persist.Key, data []byte) error {
 			}
 			return errors.Wrap(err, "scraping old pages failed")
 		}
+		if !bytes.Equal(k[:len(k)-1], key) {
+			break
+		}
 		err = s.db.Delete(k)
 		if err != nil {
 			return err
@@ -127,6 +130,65 @@ func (s ModernSaver) List() ([]persist.Key, error) {
 	return keys, nil
 }
 
+// ListPrefix returns all keys starting with prefix.
+// Since every stored key has a page byte appended, the iteration has to check the trimmed key.
+func (s ModernSaver) ListPrefix(prefix persist.Key) ([]persist.Key, error) {
+	has := make(map[string]struct{})
+	var keys []persist.Key
+	enum, _, err := s.db.Seek(prefix)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		k, _, err := enum.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if !bytes.HasPrefix(k, prefix) {
+			break
+		}
+		pk := persist.Key(k[:len(k)-1])
+		if !bytes.HasPrefix(pk, prefix) {
+			continue
+		}
+		if _, hit := has[pk.String()]; !hit {
+			keys = append(keys, pk)
+			has[pk.String()] = struct{}{}
+		}
+	}
+	return keys, nil
+}
+
+func (s ModernSaver) ListRange(from persist.Key, limit int) ([]persist.Key, error) {
+	has := make(map[string]struct{})
+	var keys []persist.Key
+	enum, _, err := s.db.Seek(from)
+	if err != nil {
+		return nil, err
+	}
+	for limit < 0 || len(keys) < limit {
+		k, _, err := enum.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		pk := persist.Key(k[:len(k)-1])
+		if bytes.Compare(pk, from) < 0 {
+			continue
+		}
+		if _, hit := has[pk.String()]; !hit {
+			keys = append(keys, pk)
+			has[pk.String()] = struct{}{}
+		}
+	}
+	return keys, nil
+}
+
 func (s ModernSaver) Delete(rm persist.Key) error {
 	enum, _, err := s.db.Seek(rm)
 	if err != nil {
@@ -144,6 +206,10 @@ func (s ModernSaver) Delete(rm persist.Key) error {
 		if !bytes.HasPrefix(k, rm) {
 			break
 		}
+		// only the pages of rm, not those of the keys rm is a prefix of
+		if len(k) != len(rm)+1 {
+			continue
+		}
 
 		if err := s.db.Delete(k); err != nil {
 			return err
@@ -0,0 +1,36 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package mkv
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/margaret/internal/persist"
+)
+
+func TestPutKeepsOtherKeys(t *testing.T) {
+	r := require.New(t)
+
+	s, err := New(filepath.Join(t.TempDir(), "kv"))
+	r.NoError(err)
+	defer s.Close()
+
+	r.NoError(s.Put(persist.Key("b"), []byte("after")))
+	r.NoError(s.Put(persist.Key("ab"), []byte("prefixed")))
+
+	// values of more than one page remove the leftover pages of the key, but only those
+	big := bytes.Repeat([]byte{1}, 2*pageSize+1)
+	r.NoError(s.Put(persist.Key("a"), big))
+
+	for k, want := range map[string][]byte{"a": big, "ab": []byte("prefixed"), "b": []byte("after")} {
+		got, err := s.Get(persist.Key(k))
+		r.NoError(err, "key %q", k)
+		r.Equal(want, got, "key %q", k)
+	}
+}
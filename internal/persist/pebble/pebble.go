@@ -0,0 +1,60 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package pebble
+
+import (
+	"fmt"
+
+	"github.com/cockroachdb/pebble"
+
+	"github.com/ssbc/margaret/internal/persist"
+)
+
+type PebbleSaver struct {
+	db *pebble.DB
+
+	// shared means the backing db is shared with other indexes
+	// this controls the closing behavior.
+	// shared instances need to be closed independantly.
+	shared bool
+
+	keyPrefix []byte
+}
+
+var (
+	_ persist.Saver       = (*PebbleSaver)(nil)
+	_ persist.RangeLister = (*PebbleSaver)(nil)
+)
+
+// Close closes the backing database if it's not shared.
+func (sl *PebbleSaver) Close() error {
+	if sl.shared {
+		return nil
+	}
+	return sl.db.Close()
+}
+
+// NewStandalone opens (or creates) the pebble database in the directory at path.
+func NewStandalone(path string) (*PebbleSaver, error) {
+	var ms PebbleSaver
+
+	var err error
+	ms.db, err = pebble.Open(path, &pebble.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("persist/pebble: failed to open database %s: %w", path, err)
+	}
+
+	return &ms, nil
+}
+
+// NewShared stores the keys in db with keyPrefix in front of them.
+// Closing the saver doesn't close db.
+func NewShared(db *pebble.DB, keyPrefix []byte) (*PebbleSaver, error) {
+	var ms PebbleSaver
+	ms.db = db
+	ms.shared = true
+	ms.keyPrefix = keyPrefix
+	return &ms, nil
+}
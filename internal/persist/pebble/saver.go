@@ -0,0 +1,110 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package pebble
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cockroachdb/pebble"
+
+	"github.com/ssbc/margaret/internal/persist"
+)
+
+// actualKey returns key with the prefix of the saver in front of it.
+func (s PebbleSaver) actualKey(key persist.Key) []byte {
+	return append(append([]byte{}, s.keyPrefix...), key...)
+}
+
+func (s PebbleSaver) Put(key persist.Key, data []byte) error {
+	return s.db.Set(s.actualKey(key), data, pebble.Sync)
+}
+
+func (s PebbleSaver) PutMultiple(values []persist.KeyValuePair) error {
+	b := s.db.NewBatch()
+	defer b.Close()
+
+	for i, kv := range values {
+		err := b.Set(s.actualKey(kv.Key), kv.Value, nil)
+		if err != nil {
+			return fmt.Errorf("persist/pebble: failed to put entry %d of %d (%s): %w", i, len(values), kv.Key, err)
+		}
+	}
+	return b.Commit(pebble.Sync)
+}
+
+func (s PebbleSaver) Get(key persist.Key) ([]byte, error) {
+	v, closer, err := s.db.Get(s.actualKey(key))
+	if err != nil {
+		if errors.Is(err, pebble.ErrNotFound) {
+			return nil, persist.ErrNotFound
+		}
+		return nil, err
+	}
+	defer closer.Close()
+
+	if len(v) == 0 {
+		return nil, persist.ErrNotFound
+	}
+
+	// v is only valid until the closer is closed
+	return append([]byte{}, v...), nil
+}
+
+func (s PebbleSaver) List() ([]persist.Key, error) {
+	return s.ListPrefix(nil)
+}
+
+func (s PebbleSaver) ListPrefix(prefix persist.Key) ([]persist.Key, error) {
+	actualPrefix := s.actualKey(prefix)
+	return s.list(actualPrefix, upperBound(actualPrefix), -1)
+}
+
+func (s PebbleSaver) ListRange(from persist.Key, limit int) ([]persist.Key, error) {
+	return s.list(s.actualKey(from), upperBound(s.keyPrefix), limit)
+}
+
+// list returns up to limit keys in [lower, upper), without the prefix of the saver. A nil upper bound means no bound.
+func (s PebbleSaver) list(lower, upper []byte, limit int) ([]persist.Key, error) {
+	opts := &pebble.IterOptions{
+		LowerBound: lower,
+		UpperBound: upper,
+	}
+
+	iter := s.db.NewIter(opts)
+	defer iter.Close()
+
+	var keys []persist.Key
+	for iter.First(); iter.Valid(); iter.Next() {
+		if limit >= 0 && len(keys) >= limit {
+			break
+		}
+
+		// we need to make a copy of the key since pebble reuses the slice on the next iteration
+		k := iter.Key()[len(s.keyPrefix):]
+		keys = append(keys, persist.Key(append([]byte{}, k...)))
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// upperBound returns the first key after all the keys that start with prefix, or nil if there is none.
+func upperBound(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+func (s PebbleSaver) Delete(rm persist.Key) error {
+	return s.db.Delete(s.actualKey(rm), pebble.Sync)
+}
@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package pebble
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/margaret/internal/persist"
+)
+
+func TestSharedPebble(t *testing.T) {
+	r := require.New(t)
+
+	path := filepath.Join("testrun", t.Name())
+	os.RemoveAll(path)
+
+	db, err := pebble.Open(path, &pebble.Options{})
+	r.NoError(err)
+
+	// make sure each prefix can use keys as if they are alone
+	collidingKey := persist.Key("meins")
+
+	// create two shared instances on the same backing db
+	fooBucket, err := NewShared(db, []byte("foo"))
+	r.NoError(err)
+
+	barBucket, err := NewShared(db, []byte("bar"))
+	r.NoError(err)
+
+	// write two chunks of random data to them
+	fooData := make([]byte, 32)
+	rand.Read(fooData)
+	r.NoError(fooBucket.Put(collidingKey, fooData))
+
+	barData := make([]byte, 32)
+	rand.Read(barData)
+	r.NoError(barBucket.Put(collidingKey, barData))
+
+	// should both have just one key
+	fooKeys, err := fooBucket.List()
+	r.NoError(err)
+	r.Equal([]persist.Key{collidingKey}, fooKeys)
+
+	barKeys, err := barBucket.List()
+	r.NoError(err)
+	r.Equal([]persist.Key{collidingKey}, barKeys)
+
+	// ranges stay within the prefix, too
+	barKeys, err = barBucket.ListRange(nil, -1)
+	r.NoError(err)
+	r.Equal([]persist.Key{collidingKey}, barKeys)
+
+	// make sure they didnt overwrite each other
+	fooGot, err := fooBucket.Get(collidingKey)
+	r.NoError(err)
+	r.Equal(fooData, fooGot)
+
+	barGot, err := barBucket.Get(collidingKey)
+	r.NoError(err)
+	r.Equal(barData, barGot)
+
+	// closing a shared should be a noop
+	r.NoError(fooBucket.Close())
+	r.NoError(barBucket.Close())
+
+	// the keys are stored with their prefix
+	v, closer, err := db.Get([]byte("foomeins"))
+	r.NoError(err)
+	r.Equal(fooData, v)
+	r.NoError(closer.Close())
+
+	r.NoError(db.Close())
+}
@@ -70,6 +70,47 @@ func (s SqliteSaver) List() ([]persist.Key, error) {
 	return keys, rows.Err()
 }
 
+// ListPrefix uses the ordering of the hex encoded keys, all keys starting with prefix sort between it and prefix+"g".
+func (s SqliteSaver) ListPrefix(prefix persist.Key) ([]persist.Key, error) {
+	hexPrefix := hex.EncodeToString(prefix)
+	rows, err := s.db.Query(`SELECT key from persisted_roaring WHERE key >= ? AND key < ? ORDER BY key`, hexPrefix, hexPrefix+"g")
+	if err != nil {
+		return nil, errors.Wrap(err, "persist/sqlite/listPrefix: failed to execute rows query")
+	}
+	return scanKeys(rows)
+}
+
+func (s SqliteSaver) ListRange(from persist.Key, limit int) ([]persist.Key, error) {
+	if limit < 0 {
+		limit = -1 // sqlite: no limit
+	}
+	rows, err := s.db.Query(`SELECT key from persisted_roaring WHERE key >= ? ORDER BY key LIMIT ?`, hex.EncodeToString(from), limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "persist/sqlite/listRange: failed to execute rows query")
+	}
+	return scanKeys(rows)
+}
+
+func scanKeys(rows *sql.Rows) ([]persist.Key, error) {
+	defer rows.Close()
+
+	var keys []persist.Key
+	for rows.Next() {
+		var k string
+		err := rows.Scan(&k)
+		if err != nil {
+			return nil, errors.Wrap(err, "persist/sqlite: failed to scan row result")
+		}
+		bk, err := hex.DecodeString(k)
+		if err != nil {
+			return nil, errors.Wrapf(err, "persist/sqlite: invalid key: %q", k)
+		}
+		keys = append(keys, bk)
+	}
+
+	return keys, rows.Err()
+}
+
 func (s SqliteSaver) Delete(k persist.Key) error {
 	hexKey := hex.EncodeToString(k)
 	_, err := s.db.Exec(`DELETE FROM persisted_roaring WHERE key = ?`, hexKey)
@@ -17,7 +17,10 @@ type SqliteSaver struct {
 	db *sql.DB
 }
 
-var _ persist.Saver = (*SqliteSaver)(nil)
+var (
+	_ persist.Saver       = (*SqliteSaver)(nil)
+	_ persist.RangeLister = (*SqliteSaver)(nil)
+)
 
 func (sl SqliteSaver) Close() error {
 	return sl.db.Close()
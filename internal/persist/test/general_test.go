@@ -14,8 +14,10 @@ import (
 
 	"github.com/ssbc/margaret/internal/persist"
 	"github.com/ssbc/margaret/internal/persist/badger"
+	"github.com/ssbc/margaret/internal/persist/bolt"
 	"github.com/ssbc/margaret/internal/persist/fs"
 	"github.com/ssbc/margaret/internal/persist/mkv"
+	"github.com/ssbc/margaret/internal/persist/pebble"
 	"github.com/ssbc/margaret/internal/persist/sqlite"
 	"github.com/stretchr/testify/require"
 
@@ -93,6 +95,137 @@ func SimpleSaver(mk func(*testing.T) persist.Saver) func(*testing.T) {
 		r.NoError(err)
 		r.Len(l, 1)
 
+		// deleting a key leaves the keys alone that it is a prefix of
+		r.NoError(p.Put(persist.Key("del"), []byte("short")))
+		r.NoError(p.Put(persist.Key("delete"), []byte("long")))
+		r.NoError(p.Delete(persist.Key("del")))
+		_, err = p.Get(persist.Key("del"))
+		r.EqualError(err, persist.ErrNotFound.Error())
+		d, err = p.Get(persist.Key("delete"))
+		r.NoError(err)
+		r.Equal([]byte("long"), d)
+
+		r.NoError(p.Close())
+	}
+}
+
+func RangeSaver(mk func(*testing.T) persist.Saver) func(*testing.T) {
+	return func(t *testing.T) {
+		p := mk(t)
+		r := require.New(t)
+
+		rl, ok := p.(persist.RangeLister)
+		r.True(ok, "saver %T has no range support", p)
+
+		l, err := rl.ListPrefix(persist.Key("a"))
+		r.NoError(err)
+		r.Len(l, 0)
+
+		keys := []persist.Key{
+			persist.Key("a"),
+			persist.Key("aa"),
+			persist.Key("ab"),
+			persist.Key("abc-some-long-key"),
+			persist.Key("abd-some-long-key"),
+			persist.Key("b"),
+			persist.Key("ba-some-long-key"),
+			persist.Key("c"),
+		}
+		// insert in reverse to make sure the results are sorted
+		for i := len(keys) - 1; i >= 0; i-- {
+			r.NoError(p.Put(keys[i], []byte("fooo")))
+		}
+
+		// bigger then one mkv page
+		_, big := makeRandData(r, 160*1024)
+		r.NoError(p.Put(persist.Key("ab"), big))
+
+		l, err = rl.ListPrefix(persist.Key("ab"))
+		r.NoError(err)
+		r.Equal(keys[2:5], l)
+
+		l, err = rl.ListPrefix(persist.Key("b"))
+		r.NoError(err)
+		r.Equal(keys[5:7], l)
+
+		l, err = rl.ListPrefix(persist.Key("x"))
+		r.NoError(err)
+		r.Len(l, 0)
+
+		l, err = rl.ListPrefix(nil)
+		r.NoError(err)
+		r.Equal(keys, l)
+
+		// paging
+		l, err = rl.ListRange(nil, 3)
+		r.NoError(err)
+		r.Equal(keys[:3], l)
+
+		next := append(l[2], 0)
+		l, err = rl.ListRange(next, 3)
+		r.NoError(err)
+		r.Equal(keys[3:6], l)
+
+		l, err = rl.ListRange(persist.Key("b"), -1)
+		r.NoError(err)
+		r.Equal(keys[5:], l)
+
+		l, err = rl.ListRange(persist.Key("d"), 10)
+		r.NoError(err)
+		r.Len(l, 0)
+
+		r.NoError(p.Close())
+	}
+}
+
+// RangeSaverPaging pages through keys of different lengths with the same first bytes,
+// which the fs saver stores in the base directory and in sub-directories.
+func RangeSaverPaging(mk func(*testing.T) persist.Saver) func(*testing.T) {
+	return func(t *testing.T) {
+		p := mk(t)
+		r := require.New(t)
+
+		rl, ok := p.(persist.RangeLister)
+		r.True(ok, "saver %T has no range support", p)
+
+		keys := []persist.Key{
+			{0xab},
+			{0xab, 0xcd},
+			{0xab, 0xcd, 0xe0},
+			{0xab, 0xcd, 0xe0, 0, 0, 0},
+			{0xab, 0xcd, 0xe0, 0, 0, 1},
+			{0xab, 0xcd, 0xe1},
+			{0xab, 0xcd, 0xe1, 0, 0, 0},
+			{0xab, 0xcd, 0xe2, 0, 0, 0},
+			{0xab, 0xce},
+			{0xac, 0, 0, 0, 0, 0},
+		}
+		for i := len(keys) - 1; i >= 0; i-- {
+			r.NoError(p.Put(keys[i], []byte("fooo")))
+		}
+
+		for _, limit := range []int{1, 2, 3, len(keys)} {
+			var (
+				all  []persist.Key
+				from persist.Key
+			)
+			for {
+				l, err := rl.ListRange(from, limit)
+				r.NoError(err)
+				r.True(len(l) <= limit, "got %d keys for a limit of %d", len(l), limit)
+				if len(l) == 0 {
+					break
+				}
+				all = append(all, l...)
+				from = append(append(persist.Key(nil), l[len(l)-1]...), 0)
+			}
+			r.Equal(keys, all, "limit %d", limit)
+		}
+
+		l, err := rl.ListRange(keys[3], 3)
+		r.NoError(err)
+		r.Equal(keys[3:6], l)
+
 		r.NoError(p.Close())
 	}
 }
@@ -112,6 +245,26 @@ func TestSaver(t *testing.T) {
 	t.Run("sqlite", SimpleSaver(makeSqlite))
 	t.Run("badger", SimpleSaver(makeBadger))
 	t.Run("kv", SimpleSaver(makeMKV))
+	t.Run("bolt", SimpleSaver(makeBolt))
+	t.Run("pebble", SimpleSaver(makePebble))
+}
+
+func TestRangeSaver(t *testing.T) {
+	t.Run("fs", RangeSaver(makeFS))
+	t.Run("sqlite", RangeSaver(makeSqlite))
+	t.Run("badger", RangeSaver(makeBadger))
+	t.Run("kv", RangeSaver(makeMKV))
+	t.Run("bolt", RangeSaver(makeBolt))
+	t.Run("pebble", RangeSaver(makePebble))
+}
+
+func TestRangeSaverPaging(t *testing.T) {
+	t.Run("fs", RangeSaverPaging(makeFS))
+	t.Run("sqlite", RangeSaverPaging(makeSqlite))
+	t.Run("badger", RangeSaverPaging(makeBadger))
+	t.Run("kv", RangeSaverPaging(makeMKV))
+	t.Run("bolt", RangeSaverPaging(makeBolt))
+	t.Run("pebble", RangeSaverPaging(makePebble))
 }
 
 func makeFS(t *testing.T) persist.Saver {
@@ -150,3 +303,23 @@ func makeMKV(t *testing.T) persist.Saver {
 	}
 	return s
 }
+
+func makeBolt(t *testing.T) persist.Saver {
+	base := filepath.Join("testrun", t.Name())
+	os.RemoveAll(base)
+	s, err := bolt.NewStandalone(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func makePebble(t *testing.T) persist.Saver {
+	base := filepath.Join("testrun", t.Name())
+	os.RemoveAll(base)
+	s, err := pebble.NewStandalone(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
@@ -0,0 +1,151 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package qryspec holds the query options of the logs that read their entries one sequence at a time,
+// like mem and offset2/buffered.
+//
+// Those logs embed a Spec in their query, apply the margaret.QuerySpecs to it
+// and only implement walking the sequences between Lower and Upper themselves.
+package qryspec
+
+import (
+	"fmt"
+
+	"github.com/ssbc/margaret"
+)
+
+// Spec implements margaret.Query by recording the options, so they can be read back while the query runs.
+type Spec struct {
+	gt, lt, gte, lte int64
+
+	limit   int
+	live    bool
+	seqWrap bool
+	reverse bool
+}
+
+var _ margaret.Query = (*Spec)(nil)
+
+// New returns a Spec without bounds and without a limit.
+func New() Spec {
+	return Spec{
+		gt:  margaret.SeqEmpty,
+		gte: margaret.SeqEmpty,
+		lt:  margaret.SeqEmpty,
+		lte: margaret.SeqEmpty,
+
+		limit: -1, //i.e. no limit
+	}
+}
+
+// Apply applies specs to s and returns the first error one of them returns.
+func (s *Spec) Apply(specs ...margaret.QuerySpec) error {
+	for _, spec := range specs {
+		err := spec(s)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Spec) Gt(seq int64) error {
+	if s.gt != margaret.SeqEmpty || s.gte != margaret.SeqEmpty {
+		return fmt.Errorf("lower bound already set")
+	}
+
+	s.gt = seq
+	return nil
+}
+
+func (s *Spec) Gte(seq int64) error {
+	if s.gt != margaret.SeqEmpty || s.gte != margaret.SeqEmpty {
+		return fmt.Errorf("lower bound already set")
+	}
+
+	s.gte = seq
+	return nil
+}
+
+func (s *Spec) Lt(seq int64) error {
+	if s.lt != margaret.SeqEmpty || s.lte != margaret.SeqEmpty {
+		return fmt.Errorf("upper bound already set")
+	}
+
+	s.lt = seq
+	return nil
+}
+
+func (s *Spec) Lte(seq int64) error {
+	if s.lt != margaret.SeqEmpty || s.lte != margaret.SeqEmpty {
+		return fmt.Errorf("upper bound already set")
+	}
+
+	s.lte = seq
+	return nil
+}
+
+func (s *Spec) Limit(n int) error {
+	s.limit = n
+	return nil
+}
+
+func (s *Spec) Live(live bool) error {
+	s.live = live
+	return nil
+}
+
+func (s *Spec) SeqWrap(wrap bool) error {
+	s.seqWrap = wrap
+	return nil
+}
+
+func (s *Spec) Reverse(yes bool) error {
+	s.reverse = yes
+	return nil
+}
+
+// IsLive reports whether the query waits for new entries at the end of the log.
+func (s *Spec) IsLive() bool { return s.live }
+
+// IsReverse reports whether the query returns the newest entries first.
+func (s *Spec) IsReverse() bool { return s.reverse }
+
+// Lower returns the lowest sequence the query may return.
+func (s *Spec) Lower() int64 {
+	if s.gt != margaret.SeqEmpty {
+		return s.gt + 1
+	}
+	if s.gte != margaret.SeqEmpty {
+		return s.gte
+	}
+	return 0
+}
+
+// Upper returns the highest sequence the query may return, or SeqEmpty if there is no upper bound.
+func (s *Spec) Upper() int64 {
+	if s.lt != margaret.SeqEmpty {
+		return s.lt - 1
+	}
+	if s.lte != margaret.SeqEmpty {
+		return s.lte
+	}
+	return margaret.SeqEmpty
+}
+
+// Exhausted reports whether the query returned as many entries as its limit allows.
+func (s *Spec) Exhausted() bool { return s.limit == 0 }
+
+// Stop exhausts the query, so it ends without returning more entries.
+func (s *Spec) Stop() { s.limit = 0 }
+
+// Wrap counts v, the entry at seq, against the limit and returns it as the query has to return it.
+func (s *Spec) Wrap(v interface{}, seq int64) interface{} {
+	s.limit--
+
+	if s.seqWrap {
+		return margaret.WrapWithSeq(v, seq)
+	}
+	return v
+}
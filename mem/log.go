@@ -5,73 +5,69 @@
 package mem // import "github.com/ssbc/margaret/mem"
 
 import (
-	"context"
+	"errors"
 	"io"
 	"sync"
 
-	"github.com/pkg/errors"
 	"github.com/ssbc/go-luigi"
 	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/internal/qryspec"
 )
 
-// TODO optimization idea: skip list
-type memlogElem struct {
-	v    interface{}
-	seq  int64
-	next *memlogElem
-	prev *memlogElem
+// ErrEvicted is returned for entries that a bounded log already dropped to make room for newer ones.
+var ErrEvicted = errors.New("mem: entry evicted")
 
-	wait chan struct{}
-}
+// nulled is stored in place of entries that were nulled
+type nulled struct{}
 
-func (el *memlogElem) waitNext(ctx context.Context, m *sync.Mutex) (*memlogElem, error) {
-	// closure to localize defer. We need to lock before accessing el.next in the return.
-	err := func() error {
-		// yes, first unlock, then lock. We need to release the mutex to
-		// allow Appends to happen, but we need to lock again afterwards!
-		m.Unlock()
-		defer m.Lock()
-
-		select {
-		// wait until new element has been added
-		case <-el.wait:
-		// or context is canceled
-		case <-ctx.Done():
-			return ctx.Err()
-		}
+type memlog struct {
+	l sync.Mutex
 
-		return nil
-	}()
-	if err != nil {
-		// return original element in error case
-		return el, err
-	}
+	seq luigi.Observable
 
-	return el.next, nil
-}
+	// entries holds the values of the entries in memory.
+	// Unbounded logs keep all entries, so the index is the sequence.
+	// Bounded logs use it as a ring buffer, where the index is the sequence modulo the capacity.
+	entries  []interface{}
+	capacity int // zero if unbounded
 
-type memlog struct {
-	l sync.Mutex
+	first, last int64 // sequence of the oldest entry in memory and of the newest one
 
-	seq        luigi.Observable
-	head, tail *memlogElem
+	// wait is closed and replaced when an entry is appended
+	wait chan struct{}
 
 	closed bool
 }
 
+var _ margaret.Alterer = (*memlog)(nil)
+
 // New returns a new in-memory log
 func New() margaret.Log {
-	root := &memlogElem{
-		seq:  margaret.SeqEmpty,
-		wait: make(chan struct{}),
+	return newLog(0)
+}
+
+// NewBounded returns an in-memory log that only keeps the newest capacity entries.
+// Older entries are dropped when new ones are appended, reading them returns ErrEvicted.
+// Queries start at the oldest entry still in memory. A query that falls behind returns ErrEvicted once and then ends.
+// The sequence numbers keep counting up, like those of an unbounded log.
+func NewBounded(capacity int) margaret.Log {
+	if capacity < 1 {
+		capacity = 1
 	}
+	return newLog(capacity)
+}
 
+func newLog(capacity int) *memlog {
 	log := &memlog{
-		seq:  luigi.NewObservable(margaret.SeqEmpty),
-		head: root,
-		tail: root,
+		seq:      luigi.NewObservable(margaret.SeqEmpty),
+		capacity: capacity,
+		first:    0,
+		last:     margaret.SeqEmpty,
+		wait:     make(chan struct{}),
+	}
+	if capacity > 0 {
+		log.entries = make([]interface{}, capacity)
 	}
-
 	return log
 }
 
@@ -86,38 +82,52 @@ func (log *memlog) Close() error {
 }
 
 func (log *memlog) Seq() int64 {
-	return log.tail.seq
+	log.l.Lock()
+	defer log.l.Unlock()
+
+	return log.last
 }
 
 func (log *memlog) Changes() luigi.Observable {
 	return log.seq
 }
 
-func (log *memlog) Get(s int64) (interface{}, error) {
-	log.l.Lock()
-	defer log.l.Unlock()
-	if log.closed {
-		return nil, io.ErrClosedPipe // already closed
+// index returns the position of seq in entries, or an error if it isn't in memory. Take the lock first!
+func (log *memlog) index(seq int64) (int, error) {
+	if seq < 0 || seq > log.last {
+		return 0, margaret.OOB
 	}
+	if seq < log.first {
+		return 0, ErrEvicted
+	}
+	if log.capacity > 0 {
+		return int(seq % int64(log.capacity)), nil
+	}
+	return int(seq), nil
+}
 
-	var (
-		cur = log.head
-	)
-
-	for cur.seq < s && cur.next != nil {
-		cur = cur.next
+// get returns the value of the entry at seq. Take the lock first!
+func (log *memlog) get(seq int64) (interface{}, error) {
+	i, err := log.index(seq)
+	if err != nil {
+		return nil, err
 	}
 
-	if cur.seq < s {
-		return nil, margaret.OOB
+	v := log.entries[i]
+	if _, ok := v.(nulled); ok {
+		return nil, margaret.ErrNulled
 	}
+	return v, nil
+}
 
-	if cur.seq > s {
-		// TODO maybe better handling of this case?
-		panic("datastructure borked, sequence number missing")
+func (log *memlog) Get(s int64) (interface{}, error) {
+	log.l.Lock()
+	defer log.l.Unlock()
+	if log.closed {
+		return nil, io.ErrClosedPipe // already closed
 	}
 
-	return cur.v, nil
+	return log.get(s)
 }
 
 func (log *memlog) Query(specs ...margaret.QuerySpec) (luigi.Source, error) {
@@ -128,26 +138,16 @@ func (log *memlog) Query(specs ...margaret.QuerySpec) (luigi.Source, error) {
 	}
 
 	qry := &memlogQuery{
-		log: log,
-		cur: log.head,
-
-		gt:  margaret.SeqEmpty,
-		gte: margaret.SeqEmpty,
-		lt:  margaret.SeqEmpty,
-		lte: margaret.SeqEmpty,
-
-		limit: -1, //i.e. no limit
+		log:  log,
+		Spec: qryspec.New(),
 	}
 
-	for _, spec := range specs {
-		err := spec(qry)
-		if err != nil {
-			return nil, err
-		}
+	if err := qry.Apply(specs...); err != nil {
+		return nil, err
 	}
 
-	if qry.reverse && qry.live {
-		return nil, errors.Errorf("memlog: can't do reverse and live")
+	if qry.IsReverse() && qry.IsLive() {
+		return nil, errors.New("memlog: can't do reverse and live")
 	}
 
 	return qry, nil
@@ -160,19 +160,52 @@ func (log *memlog) Append(v interface{}) (int64, error) {
 		return margaret.SeqErrored, io.ErrClosedPipe // already closed
 	}
 
-	nxt := &memlogElem{
-		v:    v,
-		seq:  log.tail.seq + 1,
-		wait: make(chan struct{}),
+	log.last++
+	if log.capacity > 0 {
+		log.entries[log.last%int64(log.capacity)] = v
+		if log.last-log.first >= int64(log.capacity) {
+			log.first++
+		}
+	} else {
+		log.entries = append(log.entries, v)
+	}
+
+	close(log.wait)
+	log.wait = make(chan struct{})
+	log.seq.Set(log.last)
+
+	return log.last, nil
+}
+
+// Null removes the value of the entry at seq. Reading it returns margaret.ErrNulled afterwards.
+func (log *memlog) Null(seq int64) error {
+	log.l.Lock()
+	defer log.l.Unlock()
+	if log.closed {
+		return io.ErrClosedPipe // already closed
 	}
 
-	log.tail.next = nxt
-	oldtail := log.tail
-	nxt.prev = oldtail
-	log.tail = log.tail.next
+	i, err := log.index(seq)
+	if err != nil {
+		return err
+	}
+	log.entries[i] = nulled{}
+	return nil
+}
 
-	close(oldtail.wait)
-	log.seq.Set(log.tail.seq)
+// Replace makes data the value of the entry at seq.
+// Unlike logs that store encoded entries, the value isn't decoded, so reading it returns data itself.
+func (log *memlog) Replace(seq int64, data []byte) error {
+	log.l.Lock()
+	defer log.l.Unlock()
+	if log.closed {
+		return io.ErrClosedPipe // already closed
+	}
 
-	return log.tail.seq, nil
+	i, err := log.index(seq)
+	if err != nil {
+		return err
+	}
+	log.entries[i] = data
+	return nil
 }
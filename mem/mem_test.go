@@ -0,0 +1,150 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package mem_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ssbc/go-luigi"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/mem"
+)
+
+func TestGet(t *testing.T) {
+	r := require.New(t)
+
+	log := mem.New()
+
+	_, err := log.Get(0)
+	r.True(margaret.IsOutOfBounds(err), "unexpected error: %v", err)
+
+	const n = 10000
+	for i := 0; i < n; i++ {
+		seq, err := log.Append(i)
+		r.NoError(err)
+		r.EqualValues(i, seq)
+	}
+	r.EqualValues(n-1, log.Seq())
+
+	for _, i := range []int{0, 1, n / 2, n - 1} {
+		v, err := log.Get(int64(i))
+		r.NoError(err)
+		r.Equal(i, v)
+	}
+
+	for _, seq := range []int64{-1, n, n + 100} {
+		_, err := log.Get(seq)
+		r.True(margaret.IsOutOfBounds(err), "seq %d: unexpected error: %v", seq, err)
+	}
+}
+
+func TestAlterer(t *testing.T) {
+	r := require.New(t)
+
+	log := mem.New()
+	for _, v := range []string{"a", "b", "c"} {
+		_, err := log.Append(v)
+		r.NoError(err)
+	}
+
+	alterer, ok := log.(margaret.Alterer)
+	r.True(ok, "mem log is not an Alterer")
+
+	r.NoError(alterer.Null(1))
+	_, err := log.Get(1)
+	r.True(margaret.IsErrNulled(err), "unexpected error: %v", err)
+
+	r.NoError(alterer.Replace(2, []byte("replaced")))
+	v, err := log.Get(2)
+	r.NoError(err)
+	r.Equal([]byte("replaced"), v)
+
+	r.True(margaret.IsOutOfBounds(alterer.Null(3)))
+	r.True(margaret.IsOutOfBounds(alterer.Replace(-1, nil)))
+
+	// queries return nulled entries as values
+	src, err := log.Query(margaret.SeqWrap(true))
+	r.NoError(err)
+	var got []interface{}
+	for {
+		v, err := src.Next(context.TODO())
+		if luigi.IsEOS(err) {
+			break
+		}
+		r.NoError(err)
+		sw := v.(margaret.SeqWrapper)
+		r.EqualValues(len(got), sw.Seq())
+		got = append(got, sw.Value())
+	}
+	r.Equal([]interface{}{"a", margaret.ErrNulled, []byte("replaced")}, got)
+}
+
+func TestBounded(t *testing.T) {
+	r := require.New(t)
+
+	log := mem.NewBounded(3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// a live query that falls behind the ring
+	live, err := log.Query(margaret.Live(true))
+	r.NoError(err)
+
+	for i := 0; i < 5; i++ {
+		if i == 1 {
+			v, err := live.Next(ctx)
+			r.NoError(err)
+			r.Equal(0, v)
+		}
+
+		seq, err := log.Append(i)
+		r.NoError(err)
+		r.EqualValues(i, seq)
+	}
+	r.EqualValues(4, log.Seq())
+
+	for _, seq := range []int64{0, 1} {
+		_, err := log.Get(seq)
+		r.True(errors.Is(err, mem.ErrEvicted), "seq %d: unexpected error: %v", seq, err)
+	}
+	for seq := int64(2); seq < 5; seq++ {
+		v, err := log.Get(seq)
+		r.NoError(err)
+		r.EqualValues(seq, v)
+	}
+	_, err = log.Get(5)
+	r.True(margaret.IsOutOfBounds(err), "unexpected error: %v", err)
+
+	_, err = live.Next(ctx)
+	r.True(errors.Is(err, mem.ErrEvicted), "unexpected error: %v", err)
+	_, err = live.Next(ctx)
+	r.True(luigi.IsEOS(err), "query didn't end after falling behind: %v", err)
+
+	// new queries start at the oldest entry still in memory
+	src, err := log.Query(margaret.Gte(1))
+	r.NoError(err)
+	for want := 2; want < 5; want++ {
+		v, err := src.Next(ctx)
+		r.NoError(err)
+		r.Equal(want, v)
+	}
+	_, err = src.Next(ctx)
+	r.True(luigi.IsEOS(err), "expected end of stream, got %v", err)
+
+	src, err = log.Query(margaret.Reverse(true))
+	r.NoError(err)
+	for want := 4; want >= 2; want-- {
+		v, err := src.Next(ctx)
+		r.NoError(err)
+		r.Equal(want, v)
+	}
+	_, err = src.Next(ctx)
+	r.True(luigi.IsEOS(err), "expected end of stream, got %v", err)
+}
@@ -8,157 +8,108 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/pkg/errors"
 	"github.com/ssbc/go-luigi"
 
 	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/internal/qryspec"
 )
 
 type memlogQuery struct {
 	log *memlog
-	cur *memlogElem
+	qryspec.Spec
 
-	gt, lt, gte, lte int64
-
-	limit   int
-	live    bool
-	seqWrap bool
-	reverse bool
+	// nextSeq is the sequence of the entry returned by the next call to Next.
+	// The first call picks it from the bounds.
+	nextSeq int64
+	started bool
 }
 
-func (qry *memlogQuery) seek(ctx context.Context) error {
-	var err error
-
-	if qry.gt != margaret.SeqEmpty {
-		if qry.cur.seq > qry.gt {
-			qry.cur = qry.log.head
-		}
-
-		for (qry.cur.seq + 1) <= qry.gt {
-			qry.cur, err = qry.cur.waitNext(ctx, &qry.log.l)
-			if err != nil {
-				return err
-			}
-		}
-	} else if qry.gte != margaret.SeqEmpty {
-		if qry.cur.seq > qry.gte {
-			qry.cur = qry.log.head
-		}
-
-		for (qry.cur.seq + 1) < qry.gte {
-			qry.cur, err = qry.cur.waitNext(ctx, &qry.log.l)
-			if err != nil {
-				return err
-			}
+// start sets nextSeq for the first call to Next. Take the lock first!
+func (qry *memlogQuery) start() {
+	if !qry.IsReverse() {
+		qry.nextSeq = qry.Lower()
+		if qry.nextSeq < qry.log.first {
+			// skip what a bounded log already evicted
+			qry.nextSeq = qry.log.first
 		}
+		return
 	}
 
-	return nil
-}
-
-func (qry *memlogQuery) Gt(s int64) error {
-	if qry.gt != margaret.SeqEmpty || qry.gte != margaret.SeqEmpty {
-		return fmt.Errorf("lower bound already set")
-	}
-
-	qry.gt = s
-	return nil
-}
-
-func (qry *memlogQuery) Gte(s int64) error {
-	if qry.gt != margaret.SeqEmpty || qry.gte != margaret.SeqEmpty {
-		return fmt.Errorf("lower bound already set")
-	}
-
-	qry.gte = s
-	return nil
-}
-
-func (qry *memlogQuery) Lt(s int64) error {
-	if qry.lt != margaret.SeqEmpty || qry.lte != margaret.SeqEmpty {
-		return fmt.Errorf("upper bound already set")
-	}
-
-	qry.lt = s
-	return nil
-}
-
-func (qry *memlogQuery) Lte(s int64) error {
-	if qry.lt != margaret.SeqEmpty || qry.lte != margaret.SeqEmpty {
-		return fmt.Errorf("upper bound already set")
+	qry.nextSeq = qry.log.last
+	if upper := qry.Upper(); upper != margaret.SeqEmpty && upper < qry.nextSeq {
+		qry.nextSeq = upper
 	}
-
-	qry.lte = s
-	return nil
-}
-
-func (qry *memlogQuery) Limit(n int) error {
-	qry.limit = n
-	return nil
-}
-
-func (qry *memlogQuery) Live(live bool) error {
-	qry.live = live
-	return nil
-}
-
-func (qry *memlogQuery) SeqWrap(wrap bool) error {
-	qry.seqWrap = wrap
-	return nil
 }
 
-func (qry *memlogQuery) Reverse(yes bool) error {
-	qry.reverse = yes
-	if yes {
-		qry.cur = qry.log.tail
+// wait blocks until an entry is appended or ctx is canceled. Take the lock first!
+func (qry *memlogQuery) wait(ctx context.Context) error {
+	wait := qry.log.wait
+
+	// yes, first unlock, then lock. We need to release the mutex to
+	// allow Appends to happen, but we need to lock again afterwards!
+	qry.log.l.Unlock()
+	defer qry.log.l.Lock()
+
+	select {
+	// wait until new element has been added
+	case <-wait:
+		return nil
+	// or context is canceled
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	return nil
 }
 
 func (qry *memlogQuery) Next(ctx context.Context) (interface{}, error) {
-	if qry.limit == 0 {
+	if qry.Exhausted() {
 		return nil, luigi.EOS{}
 	}
-	qry.limit--
 
 	qry.log.l.Lock()
 	defer qry.log.l.Unlock()
 
-	if qry.reverse {
-		if qry.cur == qry.log.head {
-			return qry.cur.v, luigi.EOS{}
-		}
-		v := qry.cur.v
-		qry.cur = qry.cur.prev
-		return v, nil
+	if !qry.started {
+		qry.start()
+		qry.started = true
 	}
 
-	if qry.cur.seq <= qry.gt || qry.cur.seq < qry.gt {
-		err := qry.seek(ctx)
-		if err != nil {
-			return nil, err
+	seq := qry.nextSeq
+	if qry.IsReverse() {
+		// like forward queries, stop at the oldest entry a bounded log still holds
+		if seq < qry.Lower() || seq < qry.log.first {
+			return nil, luigi.EOS{}
+		}
+	} else {
+		if upper := qry.Upper(); upper != margaret.SeqEmpty && seq > upper {
+			return nil, luigi.EOS{}
 		}
-	}
 
-	// no new data yet and non-blocking
-	if qry.cur.next == nil && !qry.live {
-		return nil, luigi.EOS{}
-	}
+		for seq > qry.log.last {
+			// no new data yet and non-blocking
+			if !qry.IsLive() {
+				return nil, luigi.EOS{}
+			}
 
-	if qry.lt != margaret.SeqEmpty && !(qry.cur.seq < (qry.lt)-1) {
-		return nil, luigi.EOS{}
-	} else if qry.lte != margaret.SeqEmpty && !(qry.cur.seq < qry.lte) {
-		return nil, luigi.EOS{}
+			if err := qry.wait(ctx); err != nil {
+				return nil, fmt.Errorf("error waiting for next value: %w", err)
+			}
+		}
 	}
 
-	var err error
-	qry.cur, err = qry.cur.waitNext(ctx, &qry.log.l)
-	if err != nil {
-		return nil, errors.Wrap(err, "error waiting for next value")
+	v, err := qry.log.get(seq)
+	if err == margaret.ErrNulled {
+		// like the other logs, return nulled entries as values so consumers can skip them
+		v = margaret.ErrNulled
+	} else if err != nil {
+		// a query that fell behind a bounded log can't go on without skipping entries, so it ends here
+		qry.Stop()
+		return nil, fmt.Errorf("memlog: error reading entry %d: %w", seq, err)
 	}
 
-	if qry.seqWrap {
-		return margaret.WrapWithSeq(qry.cur.v, qry.cur.seq), nil
+	if qry.IsReverse() {
+		qry.nextSeq--
+	} else {
+		qry.nextSeq++
 	}
-	return qry.cur.v, nil
+	return qry.Wrap(v, seq), nil
 }
@@ -14,4 +14,9 @@ func init() {
 	mtest.Register("mem", func(string, interface{}) (margaret.Log, error) {
 		return mem.New(), nil
 	})
+
+	// big enough that the generic tests never evict anything
+	mtest.Register("mem/bounded", func(string, interface{}) (margaret.Log, error) {
+		return mem.NewBounded(1 << 16), nil
+	})
 }
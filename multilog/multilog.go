@@ -30,6 +30,30 @@ type MultiLog interface {
 	Delete(indexes.Addr) error
 }
 
+// RangeLister is implemented by multilogs that can list a subset of their sublogs,
+// without opening all of them like List() does.
+type RangeLister interface {
+	// ListPrefix returns the addresses of all sublogs that start with prefix, in ascending order.
+	ListPrefix(prefix indexes.Addr) ([]indexes.Addr, error)
+
+	// ListRange returns up to limit addresses that are equal to or greater than from, in ascending order.
+	// A negative limit returns all of them.
+	ListRange(from indexes.Addr, limit int) ([]indexes.Addr, error)
+}
+
+// Checkpointer is implemented by multilogs that can store the progress of sinks.
+// Checkpoints are committed together with the contents of the sublogs when the multilog is flushed,
+// so that a sink resuming from a stored checkpoint never skips entries that didn't make it to disk.
+type Checkpointer interface {
+	MultiLog
+
+	// Checkpoint returns the last sequence set for name, or margaret.SeqEmpty if there is none.
+	Checkpoint(name string) (int64, error)
+
+	// SetCheckpoint sets the sequence for name. It is stored with the next flush.
+	SetCheckpoint(name string, seq int64) error
+}
+
 func Has(mlog MultiLog, addr indexes.Addr) (bool, error) {
 	slog, err := mlog.Get(addr)
 	if err != nil {
@@ -0,0 +1,260 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package multilog
+
+import (
+	"container/heap"
+	"context"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/ssbc/go-luigi"
+	"github.com/ssbc/margaret"
+)
+
+// NewParallelSink makes a Sink that runs the processing function for up to workers values at the same time.
+// Pour only blocks while all workers are busy. The values have to come from a single source in ascending order,
+// like a query returns them, since the sink can't know about values that weren't poured yet.
+//
+// The processing function doesn't get the context passed to Pour, which might be done before the worker is,
+// but one of the sink that is canceled once processing failed and after Close.
+//
+// The saved sequence only advances up to the highest value for which all lower values are processed,
+// so that a restart never skips an entry. Entries above it might be processed again after a restart,
+// which means f needs to be idempotent and can't rely on the order of the values.
+// Appending root sequences to roaring multilogs is both.
+//
+// Close waits for all running workers and returns the first error of the processing function.
+// Pour returns that error as well, once it occurred.
+//
+// Since Pour returns before the value is processed, the sink also has a Progress method,
+// which returns an observable of the sequence that would be saved, or of the error that stopped the sink.
+// manager.Tracker uses it to report when entries are processed instead of when they were handed off.
+//
+// The progress is stored like NewSink does, as a checkpoint if mlog is a Checkpointer and in file otherwise.
+func NewParallelSink(file *os.File, mlog MultiLog, f Func, workers int) Sink {
+	return newParallelSink(defaultProgress(file, mlog), mlog, f, workers)
+}
+
+// NewParallelCheckpointSink is like NewParallelSink but stores its progress as a checkpoint called name in the multilog,
+// like NewCheckpointSink.
+func NewParallelCheckpointSink(name string, mlog Checkpointer, f Func, workers int) Sink {
+	return newParallelSink(checkpointProgress{mlog: mlog, name: name}, mlog, f, workers)
+}
+
+func newParallelSink(p progress, mlog MultiLog, f Func, workers int) Sink {
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &parallelSink{
+		mlog:     mlog,
+		f:        f,
+		progress: p,
+
+		ctx:    ctx,
+		cancel: cancel,
+
+		slots: make(chan struct{}, workers),
+
+		saved:     margaret.SeqErrored,
+		inflight:  make(map[int64]struct{}),
+		processed: luigi.NewObservable(margaret.SeqEmpty),
+	}
+}
+
+type parallelSink struct {
+	mlog     MultiLog
+	f        Func
+	progress progress
+
+	// ctx is passed to the processing function, it outlives the contexts passed to Pour
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// slots bounds the number of running workers
+	slots chan struct{}
+	wg    sync.WaitGroup
+
+	l sync.Mutex
+
+	// saved is the last sequence number that was saved, SeqErrored if it wasn't loaded yet
+	saved int64
+
+	// inflight holds the sequences that are still processed
+	inflight map[int64]struct{}
+
+	// done holds processed sequences that can't be saved yet since a lower one is still in flight
+	done seqHeap
+
+	// processed holds the last saved sequence or the error that stopped the sink
+	processed luigi.Observable
+
+	err error
+}
+
+// Pour starts processing the value in the background.
+func (slog *parallelSink) Pour(ctx context.Context, v interface{}) error {
+	var sw margaret.SeqWrapper
+	switch tv := v.(type) {
+	case margaret.SeqWrapper:
+		sw = tv
+	case error:
+		if margaret.IsErrNulled(tv) {
+			return nil
+		}
+		return tv
+	default:
+		return errors.Errorf("multilog/sink: expecting seqwrapped value (%T)", v)
+	}
+
+	select {
+	case slog.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	slog.l.Lock()
+	if slog.err != nil {
+		slog.l.Unlock()
+		<-slog.slots
+		return slog.err
+	}
+	if err := slog.loadSaved(); err != nil {
+		slog.l.Unlock()
+		<-slog.slots
+		return err
+	}
+	slog.inflight[sw.Seq()] = struct{}{}
+	slog.l.Unlock()
+
+	slog.wg.Add(1)
+	go func() {
+		defer slog.wg.Done()
+		defer func() { <-slog.slots }()
+
+		var err error
+		if val := sw.Value(); !isNulled(val) {
+			err = slog.f(slog.ctx, sw.Seq(), val, slog.mlog)
+		}
+		slog.finish(sw.Seq(), err)
+	}()
+
+	return nil
+}
+
+func isNulled(v interface{}) bool {
+	err, ok := v.(error)
+	return ok && margaret.IsErrNulled(err)
+}
+
+// finish marks seq as processed and saves the highest sequence without gaps below it.
+func (slog *parallelSink) finish(seq int64, err error) {
+	slog.l.Lock()
+	defer slog.l.Unlock()
+
+	delete(slog.inflight, seq)
+
+	if err != nil {
+		if slog.err == nil {
+			slog.err = errors.Wrap(err, "multilog/sink: error in processing function")
+			// nothing the other workers do is saved anymore
+			slog.cancel()
+			slog.processed.Set(slog.err)
+		}
+		// don't save anything past the failed one
+		return
+	}
+	if slog.err != nil {
+		return
+	}
+
+	heap.Push(&slog.done, seq)
+
+	lowest := int64(-1)
+	for s := range slog.inflight {
+		if lowest == -1 || s < lowest {
+			lowest = s
+		}
+	}
+
+	next := slog.saved
+	for slog.done.Len() > 0 && (lowest == -1 || slog.done[0] < lowest) {
+		next = heap.Pop(&slog.done).(int64)
+	}
+
+	if next == slog.saved {
+		return
+	}
+
+	if err := slog.progress.save(next); err != nil {
+		slog.err = errors.Wrap(err, "error saving current sequence number")
+		slog.cancel()
+		slog.processed.Set(slog.err)
+		return
+	}
+	slog.saved = next
+	slog.processed.Set(next)
+}
+
+// Progress returns an observable of the last saved sequence, or of the error that stopped the sink.
+func (slog *parallelSink) Progress() luigi.Observable {
+	return slog.processed
+}
+
+// loadSaved reads the saved sequence, the lock needs to be held.
+func (slog *parallelSink) loadSaved() error {
+	if slog.saved != margaret.SeqErrored {
+		return nil
+	}
+
+	seq, err := slog.progress.load()
+	if err != nil {
+		return err
+	}
+	slog.saved = seq
+	return nil
+}
+
+// Close waits for the running workers and returns the first error that occurred.
+func (slog *parallelSink) Close() error {
+	slog.wg.Wait()
+	slog.cancel()
+
+	slog.l.Lock()
+	defer slog.l.Unlock()
+	return slog.err
+}
+
+// QuerySpec returns the query spec that queries the next needed messages from the log
+func (slog *parallelSink) QuerySpec() margaret.QuerySpec {
+	slog.l.Lock()
+	defer slog.l.Unlock()
+
+	if err := slog.loadSaved(); err != nil {
+		return margaret.ErrorQuerySpec(err)
+	}
+
+	return margaret.MergeQuerySpec(
+		margaret.Gt(slog.saved),
+		margaret.SeqWrap(true),
+	)
+}
+
+// seqHeap is a min-heap of sequence numbers
+type seqHeap []int64
+
+func (h seqHeap) Len() int            { return len(h) }
+func (h seqHeap) Less(i, j int) bool  { return h[i] < h[j] }
+func (h seqHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *seqHeap) Push(x interface{}) { *h = append(*h, x.(int64)) }
+func (h *seqHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
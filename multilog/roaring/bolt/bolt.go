@@ -0,0 +1,28 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package bolt
+
+import (
+	"go.etcd.io/bbolt"
+
+	pbolt "github.com/ssbc/margaret/internal/persist/bolt"
+	"github.com/ssbc/margaret/multilog/roaring"
+)
+
+func NewStandalone(base string) (*roaring.MultiLog, error) {
+	s, err := pbolt.NewStandalone(base)
+	if err != nil {
+		return nil, err
+	}
+	return roaring.NewStore(s), nil
+}
+
+func NewShared(db *bbolt.DB, bucket []byte) (*roaring.MultiLog, error) {
+	s, err := pbolt.NewShared(db, bucket)
+	if err != nil {
+		return nil, err
+	}
+	return roaring.NewStore(s), nil
+}
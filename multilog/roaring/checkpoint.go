@@ -0,0 +1,79 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package roaring
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/internal/persist"
+	"github.com/ssbc/margaret/multilog"
+)
+
+var _ multilog.Checkpointer = (*MultiLog)(nil)
+
+func checkpointKey(name string) persist.Key {
+	return metaKey("checkpoint", []byte(name))
+}
+
+const checkpointVersion1 = 1
+
+// encodeCheckpoint encodes seq as a version byte, the big-endian sequence and a crc32 of both.
+// Not all stores write values atomically, the checksum makes torn writes detectable.
+func encodeCheckpoint(seq int64) []byte {
+	buf := make([]byte, 1+8+4)
+	buf[0] = checkpointVersion1
+	binary.BigEndian.PutUint64(buf[1:], uint64(seq))
+	binary.BigEndian.PutUint32(buf[9:], crc32.ChecksumIEEE(buf[:9]))
+	return buf
+}
+
+func decodeCheckpoint(data []byte) (int64, error) {
+	if len(data) != 1+8+4 || data[0] != checkpointVersion1 {
+		return margaret.SeqErrored, fmt.Errorf("roaringfiles: invalid checkpoint (len:%d)", len(data))
+	}
+	if crc32.ChecksumIEEE(data[:9]) != binary.BigEndian.Uint32(data[9:]) {
+		return margaret.SeqErrored, fmt.Errorf("roaringfiles: checkpoint checksum mismatch")
+	}
+	return int64(binary.BigEndian.Uint64(data[1:])), nil
+}
+
+// Checkpoint returns the sequence last set for name, or margaret.SeqEmpty if it was never set.
+func (log *MultiLog) Checkpoint(name string) (int64, error) {
+	log.l.Lock()
+	defer log.l.Unlock()
+
+	if seq, has := log.checkpoints[name]; has {
+		return seq, nil
+	}
+
+	data, err := log.store.Get(checkpointKey(name))
+	if errors.Is(err, persist.ErrNotFound) {
+		return margaret.SeqEmpty, nil
+	} else if err != nil {
+		return margaret.SeqErrored, fmt.Errorf("roaringfiles: failed to load checkpoint %q: %w", name, err)
+	}
+
+	seq, err := decodeCheckpoint(data)
+	if err != nil {
+		return margaret.SeqErrored, fmt.Errorf("checkpoint %q: %w", name, err)
+	}
+	log.checkpoints[name] = seq
+	return seq, nil
+}
+
+// SetCheckpoint sets the sequence for name. It is only kept in memory until the next flush,
+// which writes it after the sublogs, so that a stored checkpoint is never ahead of the stored bitmaps.
+func (log *MultiLog) SetCheckpoint(name string, seq int64) error {
+	log.l.Lock()
+	defer log.l.Unlock()
+
+	log.checkpoints[name] = seq
+	log.dirtyCheckpoints[name] = struct{}{}
+	return nil
+}
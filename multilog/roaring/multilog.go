@@ -5,10 +5,13 @@
 package roaring
 
 import (
+	"container/list"
 	"context"
 	"errors"
 	"fmt"
 	stdlog "log"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -30,7 +33,15 @@ func NewStore(store persist.Saver) *MultiLog {
 	ml := &MultiLog{
 		store:   store,
 		l:       &sync.Mutex{},
-		sublogs: make(map[indexes.Addr]*sublog),
+		sublogs: make(map[indexes.Addr]*sublogState),
+
+		deletedAt: make(map[indexes.Addr]uint64),
+
+		checkpoints:      make(map[string]int64),
+		dirtyCheckpoints: make(map[string]struct{}),
+
+		lru:       list.New(),
+		maxCached: DefaultMaxCachedSublogs,
 
 		processing:    ctx,
 		done:          cancel,
@@ -59,34 +70,86 @@ func (log *MultiLog) writeBatches() {
 func (log *MultiLog) Flush() error {
 	log.l.Lock()
 	defer log.l.Unlock()
-	return log.flushAllSublogs()
+	if err := log.flushAllSublogs(); err != nil {
+		return err
+	}
+	// now that they are clean, the sublogs above the limit can be evicted
+	log.evictSublogs(log.maxCached)
+	return nil
 }
 
 func (log *MultiLog) flushAllSublogs() error {
-	var dirtySublogs []persist.KeyValuePair
-	for addr, sublog := range log.sublogs {
-		if sublog.dirty {
+	var (
+		dirtySublogs []persist.KeyValuePair
+		flushed      []*sublogState
+	)
+	for addr, st := range log.sublogs {
+		if st.dirty {
+			stat, err := statFromBitmap(st.bmap, st.updated).MarshalBinary()
+			if err != nil {
+				return err
+			}
 			dirtySublogs = append(dirtySublogs, persist.KeyValuePair{
 				Key:   persist.Key(addr),
-				Value: sublog.bmap.ToBuffer(),
+				Value: st.bmap.ToBuffer(),
+			}, persist.KeyValuePair{
+				Key:   statKey(addr),
+				Value: stat,
 			})
-			sublog.dirty = false
+			flushed = append(flushed, st)
 		}
 	}
 
+	// the checkpoints go last, the stores write in order and they shouldn't get ahead of the bitmaps
+	for name := range log.dirtyCheckpoints {
+		dirtySublogs = append(dirtySublogs, persist.KeyValuePair{
+			Key:   checkpointKey(name),
+			Value: encodeCheckpoint(log.checkpoints[name]),
+		})
+	}
+
+	if len(dirtySublogs) == 0 {
+		return nil
+	}
+
 	err := log.store.PutMultiple(dirtySublogs)
 	if err != nil {
 		return err
 	}
 
+	// only mark them clean once they are stored, otherwise they could be evicted
+	for _, st := range flushed {
+		st.dirty = false
+		log.updateLRU(st)
+	}
+	log.dirtyCheckpoints = make(map[string]struct{})
+
 	return nil
 }
 
+// DefaultMaxCachedSublogs is the number of sublogs a MultiLog keeps in memory, unless changed with SetMaxCachedSublogs.
+const DefaultMaxCachedSublogs = 4096
+
 type MultiLog struct {
 	store persist.Saver
 
 	l       *sync.Mutex
-	sublogs map[indexes.Addr]*sublog
+	sublogs map[indexes.Addr]*sublogState
+
+	// lru orders the sublogs that can be evicted by their last use, the most recent one is at the front.
+	// Dirty and observed sublogs aren't on it.
+	lru       *list.List
+	maxCached int
+	stats     CacheStats
+
+	// deletions counts the calls to Delete and deletedAt holds its value after the last delete of an address,
+	// so that handles of evicted sublogs can tell if it was deleted since they were opened
+	deletions uint64
+	deletedAt map[indexes.Addr]uint64
+
+	// checkpoints holds the progress of sinks, the dirty ones are written with the next flush
+	checkpoints      map[string]int64
+	dirtyCheckpoints map[string]struct{}
 
 	processing context.Context
 	done       context.CancelFunc
@@ -96,21 +159,39 @@ type MultiLog struct {
 }
 
 func (log *MultiLog) Get(addr indexes.Addr) (margaret.Log, error) {
+	if err := checkAddr(addr); err != nil {
+		return nil, err
+	}
+
 	log.l.Lock()
 	defer log.l.Unlock()
-	return log.openSublog(addr)
+	st, err := log.openSublog(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &sublog{
+		mlog:  log,
+		addr:  addr,
+		state: st,
+	}, nil
 }
 
 // openSublog alters the sublogs map, take the lock first!
-func (log *MultiLog) openSublog(addr indexes.Addr) (*sublog, error) {
-	slog, has := log.sublogs[addr]
+func (log *MultiLog) openSublog(addr indexes.Addr) (*sublogState, error) {
+	st, has := log.sublogs[addr]
 	if has {
-		return slog, nil
+		log.stats.Hits++
+		log.touch(st)
+		return st, nil
 	}
+	log.stats.Misses++
 
 	pk := persist.Key(addr)
 
-	var seq int64
+	var (
+		seq     int64
+		updated time.Time
+	)
 
 	r, err := log.loadBitmap(pk)
 	if errors.Is(err, persist.ErrNotFound) {
@@ -120,6 +201,12 @@ func (log *MultiLog) openSublog(addr indexes.Addr) (*sublog, error) {
 		return nil, err
 	} else {
 		seq = int64(r.GetCardinality())
+
+		stat, _, err := log.loadStat(addr)
+		if err != nil {
+			return nil, err
+		}
+		updated = stat.Updated
 	}
 
 	var obsV uint64
@@ -127,16 +214,124 @@ func (log *MultiLog) openSublog(addr indexes.Addr) (*sublog, error) {
 		obsV = uint64(seq)
 	}
 
-	slog = &sublog{
-		mlog:      log,
+	st = &sublogState{
+		addr:      addr,
 		key:       pk,
 		seq:       seqobsv.New(obsV),
-		luigiObsv: luigi.NewObservable(seq),
+		luigiObsv: luigi.NewObservable(int64(obsV) - 1), // the count of entries minus one, like Seq()
 		bmap:      r,
+		updated:   updated,
+		opened:    log.deletions,
 	}
+
+	// make room before adding it, so that the new one isn't evicted right away
+	log.evictSublogs(log.maxCached - 1)
+
 	// the better idea is to have a store that can collece puts
-	log.sublogs[addr] = slog
-	return slog, nil
+	log.sublogs[addr] = st
+	log.updateLRU(st)
+	return st, nil
+}
+
+// deletedSince returns true if the sublog at addr was deleted after a state was opened when deletions was at opened.
+// Take the lock first!
+func (log *MultiLog) deletedSince(addr indexes.Addr, opened uint64) bool {
+	at, has := log.deletedAt[addr]
+	return has && at > opened
+}
+
+// markDeleted makes the handles of st fail with multilog.ErrSublogDeleted. Take the lock first!
+func (log *MultiLog) markDeleted(st *sublogState) {
+	st.deleted = true
+	st.luigiObsv.Set(multilog.ErrSublogDeleted)
+	st.seq = seqobsv.New(0)
+	log.updateLRU(st)
+}
+
+// updateLRU puts st on the eviction list if it can be evicted and takes it off otherwise. Take the lock first!
+func (log *MultiLog) updateLRU(st *sublogState) {
+	evictable := !st.dirty && st.observers == 0 && !st.deleted && !st.evicted
+	switch {
+	case evictable && st.lruElem == nil:
+		st.lruElem = log.lru.PushFront(st)
+	case !evictable && st.lruElem != nil:
+		log.lru.Remove(st.lruElem)
+		st.lruElem = nil
+	}
+}
+
+// touch marks st as the most recently used sublog. Take the lock first!
+func (log *MultiLog) touch(st *sublogState) {
+	if st.lruElem != nil {
+		log.lru.MoveToFront(st.lruElem)
+	}
+}
+
+// pin keeps st in memory until unpin is called as often. Take the lock first!
+func (log *MultiLog) pin(st *sublogState) {
+	st.observers++
+	log.updateLRU(st)
+}
+
+// unpin releases a pin taken by pin. Take the lock first!
+func (log *MultiLog) unpin(st *sublogState) {
+	st.observers--
+	log.updateLRU(st)
+}
+
+// SetMaxCachedSublogs sets the number of sublogs that are kept in memory.
+// If more are opened, the least recently used ones are evicted, as long as they are flushed and have
+// no registrations on Changes() or waiting live queries. n <= 0 disables the eviction.
+func (log *MultiLog) SetMaxCachedSublogs(n int) {
+	log.l.Lock()
+	defer log.l.Unlock()
+	log.maxCached = n
+	log.evictSublogs(n)
+}
+
+// CacheStats holds counters about the sublogs a MultiLog keeps in memory.
+type CacheStats struct {
+	// Hits and Misses count how often a sublog was found in memory or had to be loaded from the store.
+	Hits, Misses uint64
+
+	// Evictions counts how often a sublog was removed from memory.
+	Evictions uint64
+
+	// Cached is the number of sublogs that are currently in memory.
+	Cached int
+}
+
+// CacheStats returns the current counters of the sublog cache.
+func (log *MultiLog) CacheStats() CacheStats {
+	log.l.Lock()
+	defer log.l.Unlock()
+	stats := log.stats
+	stats.Cached = len(log.sublogs)
+	return stats
+}
+
+// evictSublogs removes the least recently used sublogs until at most n are left in memory.
+// Sublogs that are dirty or observed aren't on the eviction list and stay. Take the lock first!
+func (log *MultiLog) evictSublogs(n int) {
+	if log.maxCached <= 0 {
+		return
+	}
+
+	for len(log.sublogs) > n {
+		e := log.lru.Back()
+		if e == nil {
+			return
+		}
+
+		st := e.Value.(*sublogState)
+		log.lru.Remove(e)
+		st.lruElem = nil
+		delete(log.sublogs, st.addr)
+
+		st.evicted = true
+		st.bmap = nil // open handles reload it when they are used again
+		log.stats.Evictions++
+	}
 }
 
 // LoadInternalBitmap loads the raw roaringbitmap for key
@@ -164,15 +359,24 @@ func (log *MultiLog) loadBitmap(key []byte) (*sroar.Bitmap, error) {
 }
 
 func (log *MultiLog) Delete(addr indexes.Addr) error {
+	if err := checkAddr(addr); err != nil {
+		return err
+	}
+
 	log.l.Lock()
 	defer log.l.Unlock()
 
-	if sl, ok := log.sublogs[addr]; ok {
-		sl.deleted = true
-		sl.luigiObsv.Set(multilog.ErrSublogDeleted)
-		sl.seq = seqobsv.New(0)
+	if st, ok := log.sublogs[addr]; ok {
+		log.markDeleted(st)
 		delete(log.sublogs, addr)
 	}
+	// handles of the evicted state notice it when they reload it
+	log.deletions++
+	log.deletedAt[addr] = log.deletions
+
+	if err := log.store.Delete(statKey(addr)); err != nil {
+		return fmt.Errorf("roaringfiles: failed to delete stat of %s: %w", addr, err)
+	}
 
 	return log.store.Delete(persist.Key(addr))
 }
@@ -182,37 +386,128 @@ func (log *MultiLog) List() ([]indexes.Addr, error) {
 	log.l.Lock()
 	defer log.l.Unlock()
 
-	err := log.loadAll()
+	var list []indexes.Addr
+	for addr, st := range log.sublogs {
+		if st.bmap.GetCardinality() == 0 {
+			continue
+		}
+		list = append(list, addr)
+	}
+
+	// check the stored ones without adding them to the cache
+	keys, err := log.store.List()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("roaringfiles: store iteration failed: %w", err)
 	}
+	for _, bk := range keys {
+		if isMetaKey(bk) {
+			continue
+		}
+		addr := indexes.Addr(bk)
+		if _, has := log.sublogs[addr]; has {
+			continue
+		}
 
-	list := make([]indexes.Addr, len(log.sublogs))
-	i := 0
-	for addr, sublog := range log.sublogs {
-		if sublog.bmap.GetCardinality() == 0 {
+		bmap, err := log.loadBitmap(bk)
+		if err != nil {
+			return nil, fmt.Errorf("roaringfiles: broken bitmap file (%s): %w", bk, err)
+		}
+		if bmap.GetCardinality() == 0 {
 			continue
 		}
-		list[i] = addr
-		i++
+		list = append(list, addr)
 	}
-	list = list[:i] // cut off the skipped ones
 
 	return list, nil
 }
 
-func (log *MultiLog) loadAll() error {
-	keys, err := log.store.List()
+var _ multilog.RangeLister = (*MultiLog)(nil)
+
+// ListPrefix returns the addresses of all sublogs that start with prefix, in ascending order.
+// Unlike List it only looks at the keys of the store and the sublogs in memory and doesn't load any bitmaps.
+func (log *MultiLog) ListPrefix(prefix indexes.Addr) ([]indexes.Addr, error) {
+	log.l.Lock()
+	defer log.l.Unlock()
+
+	keys, err := persist.ListPrefix(log.store, persist.Key(prefix))
 	if err != nil {
-		return fmt.Errorf("roaringfiles: store iteration failed: %w", err)
+		return nil, fmt.Errorf("roaringfiles: store prefix listing failed: %w", err)
 	}
-	for _, bk := range keys {
-		_, err := log.openSublog(indexes.Addr(bk))
+	return log.withDirty(keysToAddrs(keys), func(addr indexes.Addr) bool {
+		return strings.HasPrefix(string(addr), string(prefix))
+	}, -1), nil
+}
+
+// ListRange returns up to limit addresses of stored sublogs that are equal to or greater than from.
+// Like ListPrefix it doesn't load any bitmaps, which makes it useful for paging through all the sublogs.
+func (log *MultiLog) ListRange(from indexes.Addr, limit int) ([]indexes.Addr, error) {
+	log.l.Lock()
+	defer log.l.Unlock()
+
+	var list []indexes.Addr
+	next := persist.Key(from)
+	for limit < 0 || len(list) < limit {
+		want := limit
+		if limit >= 0 {
+			want = limit - len(list)
+		}
+
+		keys, err := persist.ListRange(log.store, next, want)
 		if err != nil {
-			return fmt.Errorf("roaringfiles: broken bitmap file (%s): %w", bk, err)
+			return nil, fmt.Errorf("roaringfiles: store range listing failed: %w", err)
+		}
+		list = append(list, keysToAddrs(keys)...)
+
+		// only continue if meta keys were skipped from a full page
+		if limit < 0 || len(keys) < want {
+			break
 		}
+		next = append(append(persist.Key{}, keys[len(keys)-1]...), 0)
 	}
-	return nil
+	return log.withDirty(list, func(addr indexes.Addr) bool {
+		return addr >= from
+	}, limit), nil
+}
+
+// withDirty adds the addresses of the dirty sublogs for which match returns true to the sorted addresses of the store,
+// so listings include the sublogs that weren't flushed yet without writing them.
+// It returns up to limit addresses, unless limit is negative. Take the lock first!
+func (log *MultiLog) withDirty(stored []indexes.Addr, match func(indexes.Addr) bool, limit int) []indexes.Addr {
+	list := stored
+	for addr, st := range log.sublogs {
+		if st.dirty && match(addr) {
+			list = append(list, addr)
+		}
+	}
+	if len(list) == len(stored) {
+		return stored
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	// dirty sublogs that were stored before are listed twice
+	uniq := list[:1]
+	for _, addr := range list[1:] {
+		if addr != uniq[len(uniq)-1] {
+			uniq = append(uniq, addr)
+		}
+	}
+
+	if limit >= 0 && len(uniq) > limit {
+		uniq = uniq[:limit]
+	}
+	return uniq
+}
+
+// keysToAddrs turns the keys of the store into addresses, skipping the meta keys.
+func keysToAddrs(keys []persist.Key) []indexes.Addr {
+	list := make([]indexes.Addr, 0, len(keys))
+	for _, k := range keys {
+		if isMetaKey(k) {
+			continue
+		}
+		list = append(list, indexes.Addr(k))
+	}
+	return list
 }
 
 func (log *MultiLog) Close() error {
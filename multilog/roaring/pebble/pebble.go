@@ -0,0 +1,28 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package pebble
+
+import (
+	"github.com/cockroachdb/pebble"
+
+	ppebble "github.com/ssbc/margaret/internal/persist/pebble"
+	"github.com/ssbc/margaret/multilog/roaring"
+)
+
+func NewStandalone(base string) (*roaring.MultiLog, error) {
+	s, err := ppebble.NewStandalone(base)
+	if err != nil {
+		return nil, err
+	}
+	return roaring.NewStore(s), nil
+}
+
+func NewShared(db *pebble.DB, keyPrefix []byte) (*roaring.MultiLog, error) {
+	s, err := ppebble.NewShared(db, keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return roaring.NewStore(s), nil
+}
@@ -78,7 +78,8 @@ func (qry *query) SeqWrap(wrap bool) error {
 func (qry *query) Reverse(rev bool) error {
 	qry.reverse = rev
 	if rev {
-		qry.nextSeq = qry.log.seq.Seq() - 1
+		// Query() resolved the state before applying the specs
+		qry.nextSeq = qry.log.state.seq.Seq() - 1
 	}
 	return nil
 }
@@ -86,6 +87,12 @@ func (qry *query) Reverse(rev bool) error {
 func (qry *query) Next(ctx context.Context) (interface{}, error) {
 	qry.log.mlog.l.Lock()
 
+	st, err := qry.log.resolve()
+	if err != nil {
+		qry.log.mlog.l.Unlock()
+		return nil, err
+	}
+
 	if qry.limit == 0 {
 		qry.log.mlog.l.Unlock()
 		return nil, luigi.EOS{}
@@ -108,7 +115,7 @@ func (qry *query) Next(ctx context.Context) (interface{}, error) {
 	}
 
 	var v interface{}
-	seqVal, err := qry.log.bmap.Select(uint64(qry.nextSeq))
+	seqVal, err := st.bmap.Select(uint64(qry.nextSeq))
 	v = int64(seqVal)
 	if err != nil {
 		if !strings.Contains(err.Error(), " is not less than the cardinality:") {
@@ -123,7 +130,7 @@ func (qry *query) Next(ctx context.Context) (interface{}, error) {
 			return nil, luigi.EOS{}
 		}
 
-		return qry.livequery(ctx)
+		return qry.livequery(ctx, st)
 	}
 
 	if qry.seqWrap {
@@ -146,17 +153,27 @@ func (qry *query) Next(ctx context.Context) (interface{}, error) {
 	return v, nil
 }
 
-func (qry *query) livequery(ctx context.Context) (interface{}, error) {
+// livequery waits for the next value to be appended.
+// The state is pinned in the cache while waiting, the caller has to hold the lock of the multilog.
+func (qry *query) livequery(ctx context.Context, st *sublogState) (interface{}, error) {
 	thisNextSeq := qry.nextSeq
+	qry.log.mlog.pin(st)
+	written := st.seq.WaitFor(uint64(thisNextSeq))
 	qry.log.mlog.l.Unlock()
 
+	defer func() {
+		qry.log.mlog.l.Lock()
+		qry.log.mlog.unpin(st)
+		qry.log.mlog.l.Unlock()
+	}()
+
 	var (
 		v   interface{}
 		err error
 	)
 
 	select {
-	case <-qry.log.seq.WaitFor(uint64(thisNextSeq)):
+	case <-written:
 		v, err = qry.log.Get(thisNextSeq)
 		if !qry.seqWrap { // simpler to have two +1's here then a defer
 			qry.nextSeq++
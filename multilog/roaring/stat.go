@@ -0,0 +1,184 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package roaring
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/sroar"
+
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/indexes"
+	"github.com/ssbc/margaret/internal/persist"
+)
+
+// metaKeyPrefix is prepended to the keys in the store that don't hold bitmaps, like the stats of a sublog.
+// Sublog addresses can't start with it, so these keys can be skipped when listing the sublogs.
+var metaKeyPrefix = []byte("\x00margaret-meta\x00")
+
+func isMetaKey(k persist.Key) bool {
+	return bytes.HasPrefix(k, metaKeyPrefix)
+}
+
+// checkAddr returns an error for addresses that would be mistaken for meta keys.
+func checkAddr(addr indexes.Addr) error {
+	if isMetaKey(persist.Key(addr)) {
+		return fmt.Errorf("roaringfiles: sublog address %q is reserved", addr)
+	}
+	return nil
+}
+
+// metaKey returns the key for name of the given kind.
+// The name is hashed, so that the key has the same length for every name. Otherwise long addresses would
+// exceed the length limits of stores like the fs one, which hex-encodes keys into file names.
+// It also keeps meta keys of the same kind from being prefixes of each other, which some stores delete together with the key.
+func metaKey(kind string, name []byte) persist.Key {
+	sum := sha256.Sum256(name)
+
+	k := append(persist.Key{}, metaKeyPrefix...)
+	k = append(k, kind...)
+	k = append(k, ':')
+	return append(k, sum[:]...)
+}
+
+func statKey(addr indexes.Addr) persist.Key {
+	return metaKey("stat", []byte(addr))
+}
+
+// SublogStat holds the statistics of a sublog.
+type SublogStat struct {
+	// Count is the number of entries in the sublog.
+	Count uint64
+
+	// Min and Max are the lowest and the highest root sequence stored in the sublog.
+	// Both are margaret.SeqEmpty if the sublog is empty.
+	Min, Max int64
+
+	// Updated is the time of the last append. It is zero if it's unknown.
+	Updated time.Time
+}
+
+func emptyStat() SublogStat {
+	return SublogStat{Min: margaret.SeqEmpty, Max: margaret.SeqEmpty}
+}
+
+func statFromBitmap(bmap *sroar.Bitmap, updated time.Time) SublogStat {
+	if bmap.IsEmpty() {
+		return emptyStat()
+	}
+	return SublogStat{
+		Count:   uint64(bmap.GetCardinality()),
+		Min:     int64(bmap.Minimum()),
+		Max:     int64(bmap.Maximum()),
+		Updated: updated,
+	}
+}
+
+const statVersion1 = 1
+
+// MarshalBinary encodes the stat as a version byte followed by four big-endian uint64s.
+func (s SublogStat) MarshalBinary() ([]byte, error) {
+	var updated int64
+	if !s.Updated.IsZero() {
+		updated = s.Updated.UnixNano()
+	}
+
+	buf := make([]byte, 1+4*8)
+	buf[0] = statVersion1
+	binary.BigEndian.PutUint64(buf[1:], s.Count)
+	binary.BigEndian.PutUint64(buf[9:], uint64(s.Min))
+	binary.BigEndian.PutUint64(buf[17:], uint64(s.Max))
+	binary.BigEndian.PutUint64(buf[25:], uint64(updated))
+	return buf, nil
+}
+
+// UnmarshalBinary decodes data created by MarshalBinary.
+func (s *SublogStat) UnmarshalBinary(data []byte) error {
+	if len(data) != 1+4*8 || data[0] != statVersion1 {
+		return fmt.Errorf("roaringfiles: invalid sublog stat (len:%d)", len(data))
+	}
+	s.Count = binary.BigEndian.Uint64(data[1:])
+	s.Min = int64(binary.BigEndian.Uint64(data[9:]))
+	s.Max = int64(binary.BigEndian.Uint64(data[17:]))
+	s.Updated = time.Time{}
+	if updated := int64(binary.BigEndian.Uint64(data[25:])); updated != 0 {
+		s.Updated = time.Unix(0, updated)
+	}
+	return nil
+}
+
+// Stat returns the statistics of the sublog at addr.
+// They are stored next to the bitmap, so unless the sublog is in memory already, the bitmap isn't loaded.
+// Stats of sublogs written before they were tracked are computed from the bitmap and have a zero Updated time.
+func (log *MultiLog) Stat(addr indexes.Addr) (SublogStat, error) {
+	log.l.Lock()
+	defer log.l.Unlock()
+	return log.stat(addr)
+}
+
+// StatMany returns the statistics of multiple sublogs, in the order of addrs.
+func (log *MultiLog) StatMany(addrs []indexes.Addr) ([]SublogStat, error) {
+	log.l.Lock()
+	defer log.l.Unlock()
+
+	stats := make([]SublogStat, len(addrs))
+	for i, addr := range addrs {
+		var err error
+		stats[i], err = log.stat(addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
+
+// stat reads the stats of a sublog, take the lock first!
+func (log *MultiLog) stat(addr indexes.Addr) (SublogStat, error) {
+	st, cached := log.sublogs[addr]
+	if cached && st.dirty {
+		return statFromBitmap(st.bmap, st.updated), nil
+	}
+
+	s, has, err := log.loadStat(addr)
+	if err != nil {
+		return SublogStat{}, err
+	} else if has {
+		return s, nil
+	}
+
+	// not tracked yet, compute it from the bitmap
+	if cached {
+		return statFromBitmap(st.bmap, st.updated), nil
+	}
+
+	bmap, err := log.loadBitmap(persist.Key(addr))
+	if errors.Is(err, persist.ErrNotFound) {
+		return emptyStat(), nil
+	} else if err != nil {
+		return SublogStat{}, err
+	}
+	return statFromBitmap(bmap, time.Time{}), nil
+}
+
+// loadStat reads the stored stats of a sublog. It returns false if there are none.
+func (log *MultiLog) loadStat(addr indexes.Addr) (SublogStat, bool, error) {
+	data, err := log.store.Get(statKey(addr))
+	if errors.Is(err, persist.ErrNotFound) {
+		return SublogStat{}, false, nil
+	} else if err != nil {
+		return SublogStat{}, false, fmt.Errorf("roaringfiles: failed to load stat of %s: %w", addr, err)
+	}
+
+	var s SublogStat
+	if err := s.UnmarshalBinary(data); err != nil {
+		return SublogStat{}, false, err
+	}
+	return s, true, nil
+}
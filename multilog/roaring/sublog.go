@@ -5,36 +5,106 @@
 package roaring
 
 import (
+	"container/list"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/dgraph-io/sroar"
 	"github.com/ssbc/go-luigi"
 
 	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/indexes"
 	"github.com/ssbc/margaret/internal/persist"
 	"github.com/ssbc/margaret/internal/seqobsv"
 	"github.com/ssbc/margaret/multilog"
 )
 
-type sublog struct {
-	mlog *MultiLog
-
+// sublogState is the cached, in-memory state of a sublog.
+// It can be evicted from the cache of the multilog once it is clean and unobserved.
+type sublogState struct {
+	addr      indexes.Addr
 	key       persist.Key
 	seq       *seqobsv.Observable
 	luigiObsv luigi.Observable
 	bmap      *sroar.Bitmap
 
+	// updated is the time of the last append
+	updated time.Time
+
+	// lruElem is the position in the lru list of the multilog, nil while the state can't be evicted
+	lruElem *list.Element
+
+	// opened is the number of deletions of the multilog when the state was opened
+	opened uint64
+
+	// observers counts the registrations on Changes() and waiting live queries
+	observers int
+
 	dirty bool
 
 	deleted bool
+	evicted bool
+}
+
+// sublog is the margaret.Log returned by the multilog.
+// It only holds on to the state it was opened with and reloads it, if it was evicted from the cache.
+// All the fields are guarded by the lock of the multilog.
+type sublog struct {
+	mlog *MultiLog
+
+	addr  indexes.Addr
+	state *sublogState
+}
+
+// resolve returns the current state of the sublog.
+// The caller needs to hold the lock of the multilog.
+func (log *sublog) resolve() (*sublogState, error) {
+	if log.state.deleted {
+		return nil, multilog.ErrSublogDeleted
+	}
+
+	if log.state.evicted {
+		// the sublog might have been deleted while the state wasn't in memory
+		if log.mlog.deletedSince(log.addr, log.state.opened) {
+			log.mlog.markDeleted(log.state)
+			return nil, multilog.ErrSublogDeleted
+		}
+
+		st, err := log.mlog.openSublog(log.addr)
+		if err != nil {
+			return nil, err
+		}
+		log.state = st
+		return st, nil
+	}
+
+	log.mlog.touch(log.state)
+	return log.state, nil
 }
 
 func (log *sublog) Seq() int64 {
-	return log.seq.Seq() - 1
+	log.mlog.l.Lock()
+	defer log.mlog.l.Unlock()
+
+	st, err := log.resolve()
+	if log.state.deleted {
+		return margaret.SeqEmpty
+	} else if err != nil {
+		return margaret.SeqErrored
+	}
+	return st.seq.Seq() - 1
 }
 
 func (log *sublog) Changes() luigi.Observable {
-	return log.luigiObsv
+	log.mlog.l.Lock()
+	defer log.mlog.l.Unlock()
+
+	if log.state.deleted {
+		return log.state.luigiObsv
+	}
+
+	return &changesObservable{log: log}
 }
 
 func (log *sublog) Get(seq int64) (interface{}, error) {
@@ -44,15 +114,16 @@ func (log *sublog) Get(seq int64) (interface{}, error) {
 }
 
 func (log *sublog) get(seq int64) (interface{}, error) {
-	if log.deleted {
-		return nil, multilog.ErrSublogDeleted
+	st, err := log.resolve()
+	if err != nil {
+		return nil, err
 	}
 
 	if seq < 0 {
 		return nil, luigi.EOS{}
 	}
 
-	v, err := log.bmap.Select(uint64(seq))
+	v, err := st.bmap.Select(uint64(seq))
 	if err != nil {
 		return nil, luigi.EOS{}
 	}
@@ -62,9 +133,11 @@ func (log *sublog) get(seq int64) (interface{}, error) {
 func (log *sublog) Query(specs ...margaret.QuerySpec) (luigi.Source, error) {
 	log.mlog.l.Lock()
 	defer log.mlog.l.Unlock()
-	if log.deleted {
-		return nil, multilog.ErrSublogDeleted
+
+	if _, err := log.resolve(); err != nil {
+		return nil, err
 	}
+
 	qry := &query{
 		log: log,
 
@@ -87,9 +160,12 @@ func (log *sublog) Query(specs ...margaret.QuerySpec) (luigi.Source, error) {
 func (log *sublog) Append(v interface{}) (int64, error) {
 	log.mlog.l.Lock()
 	defer log.mlog.l.Unlock()
-	if log.deleted {
-		return margaret.SeqSublogDeleted, multilog.ErrSublogDeleted
+
+	st, err := log.resolve()
+	if err != nil {
+		return margaret.SeqSublogDeleted, err
 	}
+
 	val, ok := v.(int64)
 	if !ok {
 		switch tv := v.(type) {
@@ -107,15 +183,20 @@ func (log *sublog) Append(v interface{}) (int64, error) {
 		return margaret.SeqErrored, fmt.Errorf("roaringfiles can only store positive numbers")
 	}
 
-	log.bmap.Set(uint64(val))
+	if !st.bmap.Set(uint64(val)) {
+		// already stored, for instance when a sink processes an entry again after a restart
+		return int64(st.bmap.Rank(uint64(val))), nil
+	}
 
-	log.dirty = true
-	log.seq.Inc()
+	st.dirty = true
+	log.mlog.updateLRU(st)
+	st.updated = time.Now()
+	st.seq.Inc()
 
-	count := log.bmap.GetCardinality() - 1
+	count := st.bmap.GetCardinality() - 1
 	newSeq := int64(count)
 
-	err := log.luigiObsv.Set(newSeq)
+	err = st.luigiObsv.Set(newSeq)
 	if err != nil {
 		err = fmt.Errorf("roaringfiles: failed to update sequence: %w", err)
 		return margaret.SeqErrored, err
@@ -123,16 +204,53 @@ func (log *sublog) Append(v interface{}) (int64, error) {
 	return newSeq, nil
 }
 
-func (log *sublog) store() error {
-	if log.deleted {
-		return multilog.ErrSublogDeleted
+// changesObservable forwards to the observable of the current state of the sublog.
+// Registrations pin the state in the cache, so that it isn't evicted while someone is listening.
+type changesObservable struct {
+	log *sublog
+}
+
+func (obv *changesObservable) current() (*sublogState, error) {
+	obv.log.mlog.l.Lock()
+	defer obv.log.mlog.l.Unlock()
+	return obv.log.resolve()
+}
+
+func (obv *changesObservable) Register(sink luigi.Sink) func() {
+	obv.log.mlog.l.Lock()
+	st, err := obv.log.resolve()
+	if err != nil {
+		obv.log.mlog.l.Unlock()
+		return luigi.NewObservable(err).Register(sink)
 	}
-	data := log.bmap.ToBuffer()
+	obv.log.mlog.pin(st)
+	obv.log.mlog.l.Unlock()
+
+	cancel := st.luigiObsv.Register(sink)
+
+	var once sync.Once
+	return func() {
+		cancel()
+		once.Do(func() {
+			obv.log.mlog.l.Lock()
+			obv.log.mlog.unpin(st)
+			obv.log.mlog.l.Unlock()
+		})
+	}
+}
+
+func (obv *changesObservable) Value() (interface{}, error) {
+	st, err := obv.current()
+	if err != nil {
+		return nil, err
+	}
+	return st.luigiObsv.Value()
+}
 
-	var err error
-	err = log.mlog.store.Put(log.key, data)
+func (obv *changesObservable) Set(v interface{}) error {
+	st, err := obv.current()
 	if err != nil {
-		return fmt.Errorf("roaringfiles: file write failed: %w", err)
+		return err
 	}
-	return nil
+	return st.luigiObsv.Set(v)
 }
@@ -0,0 +1,223 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/go-luigi"
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/indexes"
+	"github.com/ssbc/margaret/internal/persist/fs"
+	"github.com/ssbc/margaret/multilog"
+	"github.com/ssbc/margaret/multilog/roaring"
+)
+
+func TestSublogEviction(t *testing.T) {
+	r := require.New(t)
+
+	dir, err := ioutil.TempDir("", "roarcache")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	mlog := roaring.NewStore(fs.New(dir))
+	mlog.SetMaxCachedSublogs(4)
+
+	addr := func(i int) indexes.Addr { return indexes.Addr(fmt.Sprintf("sub%02d", i)) }
+
+	var handles []margaret.Log
+	for i := 0; i < 20; i++ {
+		slog, err := mlog.Get(addr(i))
+		r.NoError(err)
+		_, err = slog.Append(int64(i))
+		r.NoError(err)
+		handles = append(handles, slog)
+	}
+
+	// all of them are dirty
+	stats := mlog.CacheStats()
+	r.Equal(20, stats.Cached)
+	r.EqualValues(20, stats.Misses)
+	r.EqualValues(0, stats.Evictions)
+
+	r.NoError(mlog.Flush())
+	stats = mlog.CacheStats()
+	r.Equal(4, stats.Cached)
+	r.EqualValues(16, stats.Evictions)
+
+	// the first ones were evicted but the handles still work
+	v, err := handles[0].Get(0)
+	r.NoError(err)
+	r.EqualValues(0, v)
+
+	_, err = handles[1].Append(int64(100))
+	r.NoError(err)
+	r.EqualValues(1, handles[1].Seq())
+
+	fresh, err := mlog.Get(addr(1))
+	r.NoError(err)
+	r.EqualValues(1, fresh.Seq())
+	v, err = fresh.Get(1)
+	r.NoError(err)
+	r.EqualValues(100, v)
+
+	// observed sublogs stay in memory
+	obvLog, err := mlog.Get(addr(2))
+	r.NoError(err)
+	var got []interface{}
+	cancel := obvLog.Changes().Register(luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+		got = append(got, v)
+		return nil
+	}))
+
+	// live queries, too
+	ctx, cancelQry := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelQry()
+	liveLog, err := mlog.Get(addr(3))
+	r.NoError(err)
+	src, err := liveLog.Query(margaret.Gt(0), margaret.Live(true))
+	r.NoError(err)
+	liveVal := make(chan interface{})
+	go func() {
+		v, err := src.Next(ctx)
+		if err != nil {
+			liveVal <- err
+			return
+		}
+		liveVal <- v
+	}()
+	time.Sleep(50 * time.Millisecond) // wait for the query to block
+
+	for i := 20; i < 40; i++ {
+		slog, err := mlog.Get(addr(i))
+		r.NoError(err)
+		_, err = slog.Append(int64(i))
+		r.NoError(err)
+		r.NoError(mlog.Flush())
+	}
+
+	_, err = handles[2].Append(int64(200))
+	r.NoError(err)
+	r.Equal([]interface{}{int64(0), int64(1)}, got)
+	cancel()
+
+	_, err = handles[3].Append(int64(300))
+	r.NoError(err)
+	select {
+	case v := <-liveVal:
+		r.EqualValues(300, v)
+	case <-ctx.Done():
+		t.Fatal("live query did not return")
+	}
+
+	stats = mlog.CacheStats()
+	r.True(stats.Hits > 0)
+	r.True(stats.Evictions > 16)
+
+	r.NoError(mlog.Close())
+}
+
+func TestSublogDeletedWhileEvicted(t *testing.T) {
+	r := require.New(t)
+
+	dir, err := ioutil.TempDir("", "roarcachedel")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	mlog := roaring.NewStore(fs.New(dir))
+	mlog.SetMaxCachedSublogs(1)
+
+	old, err := mlog.Get("deleted")
+	r.NoError(err)
+	_, err = old.Append(int64(1))
+	r.NoError(err)
+	r.NoError(mlog.Flush())
+
+	// opening another one evicts the first one
+	other, err := mlog.Get("other")
+	r.NoError(err)
+	_, err = other.Append(int64(2))
+	r.NoError(err)
+	r.EqualValues(1, mlog.CacheStats().Evictions)
+
+	r.NoError(mlog.Delete("deleted"))
+
+	// the old handle doesn't recreate the sublog
+	r.EqualValues(margaret.SeqEmpty, old.Seq())
+	_, err = old.Get(0)
+	r.True(errors.Is(err, multilog.ErrSublogDeleted), "unexpected error: %v", err)
+	_, err = old.Append(int64(3))
+	r.True(errors.Is(err, multilog.ErrSublogDeleted), "unexpected error: %v", err)
+
+	// but a new handle does
+	fresh, err := mlog.Get("deleted")
+	r.NoError(err)
+	r.EqualValues(margaret.SeqEmpty, fresh.Seq())
+	_, err = fresh.Append(int64(4))
+	r.NoError(err)
+	_, err = old.Get(0)
+	r.True(errors.Is(err, multilog.ErrSublogDeleted), "unexpected error: %v", err)
+
+	// dirty sublogs stay in memory
+	r.Equal(2, mlog.CacheStats().Cached)
+	r.NoError(mlog.Flush())
+	r.Equal(1, mlog.CacheStats().Cached)
+
+	r.NoError(mlog.Close())
+}
+
+func TestListWithoutFlush(t *testing.T) {
+	r := require.New(t)
+
+	dir, err := ioutil.TempDir("", "roarlist")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	store := fs.New(dir)
+	mlog := roaring.NewStore(store)
+
+	appendTo := func(addr indexes.Addr, v int64) {
+		slog, err := mlog.Get(addr)
+		r.NoError(err)
+		_, err = slog.Append(v)
+		r.NoError(err)
+	}
+
+	appendTo("b", 1)
+	appendTo("d", 2)
+	r.NoError(mlog.Flush())
+
+	// dirty sublogs, one of them also stored
+	appendTo("a", 3)
+	appendTo("c", 4)
+	appendTo("d", 5)
+
+	addrs, err := mlog.ListPrefix("")
+	r.NoError(err)
+	r.Equal([]indexes.Addr{"a", "b", "c", "d"}, addrs)
+
+	addrs, err = mlog.ListRange("b", 2)
+	r.NoError(err)
+	r.Equal([]indexes.Addr{"b", "c"}, addrs)
+
+	addrs, err = mlog.ListRange("c", -1)
+	r.NoError(err)
+	r.Equal([]indexes.Addr{"c", "d"}, addrs)
+
+	// listing doesn't write the dirty sublogs
+	keys, err := store.ListPrefix([]byte("a"))
+	r.NoError(err)
+	r.Len(keys, 0, "listing flushed the sublogs")
+
+	r.NoError(mlog.Close())
+}
@@ -0,0 +1,184 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/indexes"
+	"github.com/ssbc/margaret/internal/persist/fs"
+	"github.com/ssbc/margaret/multilog/roaring"
+	"github.com/ssbc/margaret/multilog/roaring/mkv"
+)
+
+func TestSublogStat(t *testing.T) {
+	r := require.New(t)
+
+	dir, err := ioutil.TempDir("", "roarstat")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	mlog := roaring.NewStore(fs.New(dir))
+
+	st, err := mlog.Stat("nope")
+	r.NoError(err)
+	r.EqualValues(0, st.Count)
+	r.EqualValues(margaret.SeqEmpty, st.Min)
+	r.EqualValues(margaret.SeqEmpty, st.Max)
+	r.True(st.Updated.IsZero())
+
+	before := time.Now()
+	vals := map[indexes.Addr][]int64{
+		"alice": {3, 7, 23, 42},
+		"bob":   {5},
+		"carla": {1, 100},
+	}
+	for addr, vs := range vals {
+		slog, err := mlog.Get(addr)
+		r.NoError(err)
+		for _, v := range vs {
+			_, err = slog.Append(v)
+			r.NoError(err)
+		}
+	}
+
+	check := func() {
+		stats, err := mlog.StatMany([]indexes.Addr{"alice", "bob", "carla", "nope"})
+		r.NoError(err)
+		r.Len(stats, 4)
+
+		r.EqualValues(4, stats[0].Count)
+		r.EqualValues(3, stats[0].Min)
+		r.EqualValues(42, stats[0].Max)
+		r.False(stats[0].Updated.Before(before))
+
+		r.EqualValues(1, stats[1].Count)
+		r.EqualValues(5, stats[1].Min)
+		r.EqualValues(5, stats[1].Max)
+
+		r.EqualValues(2, stats[2].Count)
+		r.EqualValues(1, stats[2].Min)
+		r.EqualValues(100, stats[2].Max)
+
+		r.EqualValues(0, stats[3].Count)
+	}
+
+	// from memory
+	check()
+
+	// from the store, without any of the sublogs in memory
+	r.NoError(mlog.Close())
+	mlog = roaring.NewStore(fs.New(dir))
+	check()
+	r.Equal(0, mlog.CacheStats().Cached, "stat should not load bitmaps")
+
+	// the stats are not listed as sublogs
+	addrs, err := mlog.List()
+	r.NoError(err)
+	r.Len(addrs, 3)
+	addrs, err = mlog.ListPrefix("")
+	r.NoError(err)
+	r.Len(addrs, 3)
+	addrs, err = mlog.ListRange("", 2)
+	r.NoError(err)
+	r.Equal([]indexes.Addr{"alice", "bob"}, addrs)
+
+	r.NoError(mlog.Delete("bob"))
+	st, err = mlog.Stat("bob")
+	r.NoError(err)
+	r.EqualValues(0, st.Count)
+
+	r.NoError(mlog.Close())
+}
+
+func TestSublogStatKeys(t *testing.T) {
+	r := require.New(t)
+
+	dir, err := ioutil.TempDir("", "roarstatkeys")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	// mkv deletes keys by prefix
+	mlog, err := mkv.NewMultiLog(filepath.Join(dir, "db"))
+	r.NoError(err)
+	mlog.SetMaxCachedSublogs(1)
+
+	for i, addr := range []indexes.Addr{"a", "ab"} {
+		slog, err := mlog.Get(addr)
+		r.NoError(err)
+		_, err = slog.Append(int64(i))
+		r.NoError(err)
+	}
+	r.NoError(mlog.Flush())
+
+	// the time of the last append survives the eviction of the sublog
+	st, err := mlog.Stat("ab")
+	r.NoError(err)
+	r.False(st.Updated.IsZero())
+	_, err = mlog.Get("a")
+	r.NoError(err)
+	r.Equal(1, mlog.CacheStats().Cached)
+	after, err := mlog.Stat("ab")
+	r.NoError(err)
+	r.True(st.Updated.Equal(after.Updated), "updated changed from %s to %s", st.Updated, after.Updated)
+
+	// deleting a sublog leaves the stats of the others alone
+	r.NoError(mlog.Delete("a"))
+	st, err = mlog.Stat("ab")
+	r.NoError(err)
+	r.EqualValues(1, st.Count)
+
+	// addresses that look like meta keys can't be used
+	_, err = mlog.Get("\x00margaret-meta\x00stat:")
+	r.Error(err)
+
+	r.NoError(mlog.Close())
+}
+
+func TestSublogStatLongAddr(t *testing.T) {
+	r := require.New(t)
+
+	dir, err := ioutil.TempDir("", "roarstatlong")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	// the fs store hex-encodes keys into file names, which can't be longer than 255 bytes
+	mlog := roaring.NewStore(fs.New(dir))
+
+	addr := indexes.Addr(strings.Repeat("x", 120))
+	slog, err := mlog.Get(addr)
+	r.NoError(err)
+	_, err = slog.Append(int64(23))
+	r.NoError(err)
+	r.NoError(mlog.Close())
+
+	mlog = roaring.NewStore(fs.New(dir))
+	st, err := mlog.Stat(addr)
+	r.NoError(err)
+	r.EqualValues(1, st.Count)
+	r.EqualValues(23, st.Max)
+	r.Equal(0, mlog.CacheStats().Cached, "stat should not load bitmaps")
+
+	r.NoError(mlog.SetCheckpoint(string(addr), 42))
+	r.NoError(mlog.Close())
+
+	mlog = roaring.NewStore(fs.New(dir))
+	seq, err := mlog.Checkpoint(string(addr))
+	r.NoError(err)
+	r.EqualValues(42, seq)
+
+	addrs, err := mlog.List()
+	r.NoError(err)
+	r.Equal([]indexes.Addr{addr}, addrs)
+	r.NoError(mlog.Close())
+}
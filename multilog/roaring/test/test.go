@@ -16,7 +16,9 @@ import (
 	"github.com/ssbc/margaret/multilog"
 	"github.com/ssbc/margaret/multilog/roaring"
 	"github.com/ssbc/margaret/multilog/roaring/badger"
+	"github.com/ssbc/margaret/multilog/roaring/bolt"
 	"github.com/ssbc/margaret/multilog/roaring/mkv"
+	"github.com/ssbc/margaret/multilog/roaring/pebble"
 	"github.com/ssbc/margaret/multilog/roaring/sqlite"
 	mltest "github.com/ssbc/margaret/multilog/test"
 )
@@ -71,4 +73,27 @@ func init() {
 		r, err := mkv.NewMultiLog(filepath.Join(testDir, "mkv.roar"))
 		return r, testDir, err
 	})
+	mltest.Register("roaring_bolt", func(name string, tipe interface{}, testDir string) (multilog.MultiLog, string, error) {
+		if testDir == "" {
+			var err error
+			testDir, err = ioutil.TempDir("", "roarbolt")
+			if err != nil {
+				return nil, "", errors.Wrap(err, "error creating tempdir")
+			}
+		}
+		r, err := bolt.NewStandalone(testDir)
+		return r, testDir, err
+	})
+
+	mltest.Register("roaring_pebble", func(name string, tipe interface{}, testDir string) (multilog.MultiLog, string, error) {
+		if testDir == "" {
+			var err error
+			testDir, err = ioutil.TempDir("", "roarpebble")
+			if err != nil {
+				return nil, "", errors.Wrap(err, "error creating tempdir")
+			}
+		}
+		r, err := pebble.NewStandalone(testDir)
+		return r, testDir, err
+	})
 }
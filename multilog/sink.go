@@ -8,6 +8,7 @@ import (
 	"context"
 	"io"
 	"os"
+	"path/filepath"
 	"sync"
 
 	"github.com/keks/persist"
@@ -26,35 +27,124 @@ type Sink interface {
 }
 
 // NewSink makes a new Sink by wrapping a MultiLog and a processing function of type Func.
+//
+// If mlog is a Checkpointer, the sequence of the last processed entry is stored as a checkpoint
+// named after the base name of file, like NewCheckpointSink does.
+// file is then only read as long as there is no checkpoint yet, to continue where sinks that saved their progress in it stopped.
+// Otherwise the sequence is saved in file.
 func NewSink(file *os.File, mlog MultiLog, f Func) Sink {
 	return &sinkLog{
-		mlog: mlog,
-		f:    f,
-		file: file,
-		l:    &sync.Mutex{},
+		mlog:     mlog,
+		f:        f,
+		progress: defaultProgress(file, mlog),
+		l:        &sync.Mutex{},
 	}
 }
 
-type sinkLog struct {
-	mlog MultiLog
-	f    Func
+// NewCheckpointSink is like NewSink but stores its progress as a checkpoint called name in the multilog,
+// without a file to take the progress from before the first checkpoint.
+// Since checkpoints are flushed together with the sublogs, the stored progress never gets ahead of them.
+func NewCheckpointSink(name string, mlog Checkpointer, f Func) Sink {
+	return &sinkLog{
+		mlog:     mlog,
+		f:        f,
+		progress: checkpointProgress{mlog: mlog, name: name},
+		l:        &sync.Mutex{},
+	}
+}
+
+// progress stores the sequence of the last processed entry of a sink.
+type progress interface {
+	// load returns margaret.SeqEmpty if nothing was saved yet
+	load() (int64, error)
+	save(seq int64) error
+}
+
+// defaultProgress returns the progress of the sinks that are created with a file.
+func defaultProgress(file *os.File, mlog MultiLog) progress {
+	cp, ok := mlog.(Checkpointer)
+	if !ok {
+		return fileProgress{file}
+	}
+
+	return migratingProgress{
+		checkpoint: checkpointProgress{mlog: cp, name: filepath.Base(file.Name())},
+		file:       fileProgress{file},
+	}
+}
+
+type fileProgress struct {
 	file *os.File
-	l    *sync.Mutex
 }
 
-// Pour calls the processing function to add a value to a sublog.
+func (fp fileProgress) load() (int64, error) {
+	var seq int64
+	if err := persist.Load(fp.file, &seq); err != nil {
+		if errors.Cause(err) != io.EOF {
+			return margaret.SeqErrored, err
+		}
+		return margaret.SeqEmpty, nil
+	}
+	return seq, nil
+}
+
+func (fp fileProgress) save(seq int64) error {
+	return persist.Save(fp.file, seq)
+}
+
+type checkpointProgress struct {
+	mlog Checkpointer
+	name string
+}
+
+func (cp checkpointProgress) load() (int64, error) {
+	return cp.mlog.Checkpoint(cp.name)
+}
+
+func (cp checkpointProgress) save(seq int64) error {
+	return cp.mlog.SetCheckpoint(cp.name, seq)
+}
+
+// migratingProgress saves to a checkpoint, but loads from the file that held the progress before
+// as long as no checkpoint was saved.
+type migratingProgress struct {
+	checkpoint checkpointProgress
+	file       fileProgress
+}
+
+func (mp migratingProgress) load() (int64, error) {
+	seq, err := mp.checkpoint.load()
+	if err != nil || seq != margaret.SeqEmpty {
+		return seq, err
+	}
+	return mp.file.load()
+}
+
+func (mp migratingProgress) save(seq int64) error {
+	return mp.checkpoint.save(seq)
+}
+
+type sinkLog struct {
+	mlog     MultiLog
+	f        Func
+	progress progress
+	l        *sync.Mutex
+}
+
+// Pour calls the processing function to add a value to a sublog and saves its sequence afterwards.
 func (slog *sinkLog) Pour(ctx context.Context, v interface{}) error {
 	slog.l.Lock()
 	defer slog.l.Unlock()
 
 	seq := v.(margaret.SeqWrapper)
-	err := persist.Save(slog.file, seq.Seq())
+
+	err := slog.f(ctx, seq.Seq(), seq.Value(), slog.mlog)
 	if err != nil {
-		return errors.Wrap(err, "error saving current sequence number")
+		return errors.Wrap(err, "multilog/sink: error in processing function")
 	}
 
-	err = slog.f(ctx, seq.Seq(), seq.Value(), slog.mlog)
-	return errors.Wrap(err, "multilog/sink: error in processing function")
+	err = slog.progress.save(seq.Seq())
+	return errors.Wrap(err, "error saving current sequence number")
 }
 
 // Close does nothing.
@@ -65,14 +155,9 @@ func (slog *sinkLog) QuerySpec() margaret.QuerySpec {
 	slog.l.Lock()
 	defer slog.l.Unlock()
 
-	var seq int64
-
-	if err := persist.Load(slog.file, &seq); err != nil {
-		if errors.Cause(err) != io.EOF {
-			return margaret.ErrorQuerySpec(err)
-		}
-
-		seq = margaret.SeqEmpty
+	seq, err := slog.progress.load()
+	if err != nil {
+		return margaret.ErrorQuerySpec(err)
 	}
 
 	return margaret.MergeQuerySpec(
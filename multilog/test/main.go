@@ -15,6 +15,9 @@ type NewLogFunc func(name string, tipe interface{}, testdir string) (multilog.Mu
 func SinkTest(f NewLogFunc) func(*testing.T) {
 	return func(t *testing.T) {
 		t.Run("Simple", SinkTestSimple(f))
+		t.Run("Parallel", SinkTestParallel(f))
+		t.Run("Checkpoint", SinkTestCheckpoint(f))
+		t.Run("CheckpointMigrate", SinkTestCheckpointMigrate(f))
 	}
 }
 
@@ -24,6 +27,7 @@ func MultiLogTest(f NewLogFunc) func(*testing.T) {
 		t.Run("GetFreshThenReopenAndLogSomeMore", MultilogTestGetFreshLogCloseThenOpenAgain(f))
 		t.Run("MultiSimple", MultiLogTestSimple(f))
 		t.Run("Live", MultilogLiveQueryCheck(f))
+		t.Run("ListPrefixAndRange", MultilogTestListPrefixAndRange(f))
 	}
 }
 
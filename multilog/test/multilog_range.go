@@ -0,0 +1,105 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/margaret/indexes"
+	"github.com/ssbc/margaret/multilog"
+)
+
+func MultilogTestListPrefixAndRange(f NewLogFunc) func(*testing.T) {
+	return func(t *testing.T) {
+		r := require.New(t)
+
+		mlog, dir, err := f(t.Name(), int64(0), "")
+		r.NoError(err)
+
+		rl, ok := mlog.(multilog.RangeLister)
+		if !ok {
+			r.NoError(mlog.Close())
+			t.Skipf("%T does not support range listing", mlog)
+		}
+
+		addrs, err := rl.ListPrefix("feed:")
+		r.NoError(err)
+		r.Len(addrs, 0)
+
+		sorted := []indexes.Addr{
+			"feed:alice",
+			"feed:bob",
+			"feed:carla",
+			"feed:dan",
+			"type:about",
+			"type:contact",
+			"type:post",
+		}
+		for i, addr := range sorted {
+			slog, err := mlog.Get(addr)
+			r.NoError(err)
+			_, err = slog.Append(int64(i))
+			r.NoError(err)
+		}
+
+		// empty sublogs are not listed
+		_, err = mlog.Get("feed:empty")
+		r.NoError(err)
+
+		// listing without a flush needs to return the fresh sublogs, too
+		addrs, err = rl.ListPrefix("feed:")
+		r.NoError(err)
+		r.Equal(sorted[:4], addrs)
+
+		addrs, err = rl.ListPrefix("type:")
+		r.NoError(err)
+		r.Equal(sorted[4:], addrs)
+
+		addrs, err = rl.ListPrefix("nope:")
+		r.NoError(err)
+		r.Len(addrs, 0)
+
+		// page through all of them
+		var (
+			paged []indexes.Addr
+			from  indexes.Addr
+		)
+		for {
+			page, err := rl.ListRange(from, 3)
+			r.NoError(err)
+			if len(page) == 0 {
+				break
+			}
+			r.True(len(page) <= 3)
+			paged = append(paged, page...)
+			from = page[len(page)-1] + "\x00"
+		}
+		r.Equal(sorted, paged)
+
+		// reopen and check again
+		r.NoError(mlog.Close())
+		mlog, dir, err = f(t.Name(), int64(0), dir)
+		r.NoError(err)
+		rl = mlog.(multilog.RangeLister)
+
+		addrs, err = rl.ListRange("type:", -1)
+		r.NoError(err)
+		r.Equal(sorted[4:], addrs)
+
+		r.NoError(mlog.Delete("type:contact"))
+
+		addrs, err = rl.ListPrefix("type:")
+		r.NoError(err)
+		r.Equal([]indexes.Addr{"type:about", "type:post"}, addrs)
+
+		r.NoError(mlog.Close())
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}
+}
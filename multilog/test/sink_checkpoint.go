@@ -0,0 +1,225 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package test // import "github.com/ssbc/margaret/multilog/test"
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/keks/persist"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/go-luigi"
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/indexes"
+	"github.com/ssbc/margaret/mem"
+	"github.com/ssbc/margaret/multilog"
+)
+
+// SinkTestCheckpoint checks that sinks storing their progress in the multilog resume after it was reopened.
+func SinkTestCheckpoint(f NewLogFunc) func(*testing.T) {
+	return func(t *testing.T) {
+		r := require.New(t)
+		ctx := context.Background()
+
+		mlog, dir, err := f(t.Name(), int64(0), "")
+		r.NoError(err, "error creating multilog")
+		defer func() {
+			if t.Failed() {
+				t.Log("db location:", dir)
+			} else {
+				os.RemoveAll(dir)
+			}
+		}()
+
+		cp, ok := mlog.(multilog.Checkpointer)
+		if !ok {
+			r.NoError(mlog.Close())
+			t.Skip("multilog doesn't support checkpoints")
+		}
+
+		seq, err := cp.Checkpoint("factors")
+		r.NoError(err)
+		r.EqualValues(margaret.SeqEmpty, seq)
+
+		src := mem.New()
+		appendCount := func(n int) {
+			for i := 0; i < n; i++ {
+				_, err := src.Append(int64(src.Seq() + 1))
+				r.NoError(err)
+			}
+		}
+
+		var (
+			l         sync.Mutex
+			processed []int64
+		)
+		addFactors := func(ctx context.Context, seq int64, v interface{}, mlog multilog.MultiLog) error {
+			l.Lock()
+			processed = append(processed, seq)
+			l.Unlock()
+
+			for _, fac := range uniq(factorize(int(v.(int64)))) {
+				prefixBs := make([]byte, 4)
+				binary.BigEndian.PutUint32(prefixBs, uint32(fac))
+
+				slog, err := mlog.Get(indexes.Addr(prefixBs))
+				if err != nil {
+					return err
+				}
+				if _, err := slog.Append(seq); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		pump := func(sink multilog.Sink) {
+			qry, err := src.Query(sink.QuerySpec())
+			r.NoError(err)
+			r.NoError(luigi.Pump(ctx, sink, qry))
+			r.NoError(sink.Close())
+		}
+
+		appendCount(50)
+		pump(multilog.NewCheckpointSink("factors", cp, addFactors))
+		r.Len(processed, 50)
+
+		seq, err = cp.Checkpoint("factors")
+		r.NoError(err)
+		r.EqualValues(49, seq)
+
+		seq, err = cp.Checkpoint("other")
+		r.NoError(err)
+		r.EqualValues(margaret.SeqEmpty, seq, "checkpoints should be independent")
+
+		// closing flushes the sublogs and the checkpoint
+		r.NoError(mlog.Close())
+
+		mlog, _, err = f(t.Name(), int64(0), dir)
+		r.NoError(err, "error reopening multilog")
+		defer func() {
+			r.NoError(mlog.Close())
+		}()
+		cp = mlog.(multilog.Checkpointer)
+
+		seq, err = cp.Checkpoint("factors")
+		r.NoError(err)
+		r.EqualValues(49, seq, "checkpoint not restored")
+
+		// only the new entries are processed
+		appendCount(50)
+		processed = nil
+		pump(multilog.NewParallelCheckpointSink("factors", cp, addFactors, 4))
+		r.Len(processed, 50)
+		for _, seq := range processed {
+			r.True(seq >= 50, "entry %d processed again", seq)
+		}
+
+		seq, err = cp.Checkpoint("factors")
+		r.NoError(err)
+		r.EqualValues(99, seq)
+
+		// the checkpoints aren't listed as sublogs
+		addrs, err := mlog.List()
+		r.NoError(err)
+		for _, addr := range addrs {
+			r.Len(addr, 4, "unexpected sublog %q", addr)
+		}
+
+		prefixBs := make([]byte, 4)
+		binary.BigEndian.PutUint32(prefixBs, 3)
+		slog, err := mlog.Get(indexes.Addr(prefixBs))
+		r.NoError(err)
+		r.EqualValues(32, slog.Seq(), "wrong number of multiples of 3")
+	}
+}
+
+// SinkTestCheckpointMigrate checks that sinks made with a progress file store their progress as a checkpoint,
+// starting from the sequence saved in the file.
+func SinkTestCheckpointMigrate(f NewLogFunc) func(*testing.T) {
+	return func(t *testing.T) {
+		r := require.New(t)
+		ctx := context.Background()
+
+		mlog, dir, err := f(t.Name(), int64(0), "")
+		r.NoError(err, "error creating multilog")
+		defer func() {
+			r.NoError(mlog.Close())
+			if t.Failed() {
+				t.Log("db location:", dir)
+			} else {
+				os.RemoveAll(dir)
+			}
+		}()
+
+		cp, ok := mlog.(multilog.Checkpointer)
+		if !ok {
+			t.Skip("multilog doesn't support checkpoints")
+		}
+
+		// a sink that kept its progress in the file got up to 9
+		prefix := "curSeq-" + strings.Replace(t.Name(), "/", "_", -1) + "-"
+		file, err := ioutil.TempFile(".", prefix)
+		r.NoError(err, "error creating curseq file")
+		defer os.Remove(file.Name())
+		r.NoError(persist.Save(file, int64(9)))
+
+		src := mem.New()
+		for i := 0; i < 20; i++ {
+			_, err := src.Append(int64(i))
+			r.NoError(err)
+		}
+
+		var processed []int64
+		addSeq := func(ctx context.Context, seq int64, v interface{}, mlog multilog.MultiLog) error {
+			processed = append(processed, seq)
+
+			slog, err := mlog.Get(indexes.Addr("all"))
+			if err != nil {
+				return err
+			}
+			_, err = slog.Append(seq)
+			return err
+		}
+
+		pump := func(sink multilog.Sink) {
+			qry, err := src.Query(sink.QuerySpec())
+			r.NoError(err)
+			r.NoError(luigi.Pump(ctx, sink, qry))
+			r.NoError(sink.Close())
+		}
+
+		pump(multilog.NewSink(file, mlog, addSeq))
+		r.Equal([]int64{10, 11, 12, 13, 14, 15, 16, 17, 18, 19}, processed, "didn't continue from the file")
+
+		name := filepath.Base(file.Name())
+		seq, err := cp.Checkpoint(name)
+		r.NoError(err)
+		r.EqualValues(19, seq, "progress not stored as checkpoint")
+
+		// the file isn't written anymore, the checkpoint takes precedence
+		var fileSeq int64
+		r.NoError(persist.Load(file, &fileSeq))
+		r.EqualValues(9, fileSeq)
+
+		_, err = src.Append(int64(20))
+		r.NoError(err)
+
+		processed = nil
+		pump(multilog.NewParallelSink(file, mlog, addSeq, 1))
+		r.Equal([]int64{20}, processed)
+
+		seq, err = cp.Checkpoint(name)
+		r.NoError(err)
+		r.EqualValues(20, seq)
+	}
+}
@@ -0,0 +1,159 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package test // import "github.com/ssbc/margaret/multilog/test"
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/go-luigi"
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/indexes"
+	"github.com/ssbc/margaret/mem"
+	"github.com/ssbc/margaret/multilog"
+)
+
+// SinkTestParallel pumps a log into a parallel sink, checks that the sublogs are complete
+// and that the sink resumes at the first entry that wasn't processed after an error.
+func SinkTestParallel(f NewLogFunc) func(*testing.T) {
+	return func(t *testing.T) {
+		r := require.New(t)
+		ctx := context.Background()
+
+		mlog, dir, err := f(t.Name(), int64(0), "")
+		r.NoError(err, "error creating multilog")
+		defer func() {
+			r.NoError(mlog.Close())
+			if t.Failed() {
+				t.Log("db location:", dir)
+			} else {
+				os.RemoveAll(dir)
+			}
+		}()
+
+		prefix := "curSeq-" + strings.Replace(t.Name(), "/", "_", -1) + "-"
+		file, err := ioutil.TempFile(".", prefix)
+		r.NoError(err, "error creating curseq file")
+		defer os.Remove(file.Name())
+
+		const n = 200
+		src := mem.New()
+		for i := 0; i < n; i++ {
+			_, err := src.Append(int64(i))
+			r.NoError(err)
+		}
+
+		var (
+			l         sync.Mutex
+			processed = make(map[int64]int)
+			failAt    = int64(123)
+		)
+		addFactors := func(ctx context.Context, seq int64, v interface{}, mlog multilog.MultiLog) error {
+			l.Lock()
+			processed[seq]++
+			fail := seq == failAt
+			l.Unlock()
+			if fail {
+				return errors.New("test failure")
+			}
+
+			for _, fac := range uniq(factorize(int(v.(int64)))) {
+				prefixBs := make([]byte, 4)
+				binary.BigEndian.PutUint32(prefixBs, uint32(fac))
+
+				slog, err := mlog.Get(indexes.Addr(prefixBs))
+				if err != nil {
+					return err
+				}
+				if _, err := slog.Append(seq); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		pump := func() error {
+			sink := multilog.NewParallelSink(file, mlog, addFactors, 8)
+			qry, err := src.Query(sink.QuerySpec())
+			r.NoError(err)
+			pumpErr := luigi.Pump(ctx, sink, qry)
+			closeErr := sink.Close()
+			if pumpErr != nil {
+				return pumpErr
+			}
+			return closeErr
+		}
+
+		// the first run stops at the failing entry
+		err = pump()
+		r.Error(err)
+		r.Equal(1, processed[failAt])
+
+		// the second one resumes before it
+		failAt = -1
+		processed = make(map[int64]int)
+		r.NoError(pump())
+		r.Equal(1, processed[123], "failed entry not processed again")
+		for i := int64(124); i < n; i++ {
+			r.Equal(1, processed[i], "entry %d not processed", i)
+		}
+
+		// nothing left to do
+		processed = make(map[int64]int)
+		r.NoError(pump())
+		r.Len(processed, 0)
+
+		for _, fac := range []uint32{2, 3, 5, 7} {
+			prefixBs := make([]byte, 4)
+			binary.BigEndian.PutUint32(prefixBs, fac)
+
+			slog, err := mlog.Get(indexes.Addr(prefixBs))
+			r.NoError(err)
+
+			var want []int64
+			for i := int64(fac); i < n; i += int64(fac) {
+				want = append(want, i)
+			}
+			r.EqualValues(len(want)-1, slog.Seq(), "wrong count for %d", fac)
+
+			qry, err := slog.Query()
+			r.NoError(err)
+			var got []int64
+			for {
+				v, err := qry.Next(ctx)
+				if luigi.IsEOS(err) {
+					break
+				}
+				r.NoError(err)
+				got = append(got, v.(int64))
+			}
+			r.Equal(want, got, "wrong entries for %d", fac)
+		}
+
+		// the workers keep running after the context passed to Pour is done
+		ctxFile, err := ioutil.TempFile(".", prefix)
+		r.NoError(err, "error creating curseq file")
+		defer os.Remove(ctxFile.Name())
+
+		release := make(chan struct{})
+		sink := multilog.NewParallelSink(ctxFile, mlog, func(ctx context.Context, seq int64, v interface{}, mlog multilog.MultiLog) error {
+			<-release
+			return ctx.Err()
+		}, 2)
+		pourCtx, cancel := context.WithCancel(ctx)
+		r.NoError(sink.Pour(pourCtx, margaret.WrapWithSeq(int64(0), 0)))
+		cancel()
+		close(release)
+		r.NoError(sink.Close())
+	}
+}
@@ -0,0 +1,266 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package buffered implements a write-behind margaret.Log on top of an offset2 log.
+//
+// Appended entries are kept in memory and written to the offset2 log by a background goroutine,
+// so Append doesn't wait for the disk. The writer takes everything that piled up since its last write
+// and appends it to the offset2 log as one batch, with a single write and sync.
+// Entries are encoded when they are appended and decoded when they are read from memory,
+// so Get and Query return the same values before and after an entry reached the disk.
+// Append blocks while the buffer is full, and Flush waits until everything appended so far is on disk.
+package buffered // import "github.com/ssbc/margaret/offset2/buffered"
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ssbc/go-luigi"
+
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/offset2"
+)
+
+// ErrClosed is returned by Append and Flush after the log was closed.
+var ErrClosed = errors.New("buffered: log closed")
+
+// DefaultCapacity is the number of entries buffered by logs created with a capacity of zero.
+const DefaultCapacity = 1024
+
+// Log is a margaret.Log that buffers appended entries and writes them to an offset2 log in the background.
+type Log struct {
+	disk     *offset2.OffsetLog
+	codec    margaret.Codec
+	capacity int
+
+	seq luigi.Observable
+
+	l sync.Mutex
+
+	// cond is signalled whenever the buffer, the flushed sequence or the state of the log changes
+	cond *sync.Cond
+
+	// buf holds the entries that aren't on disk yet, buf[i] has sequence flushed+1+i
+	buf     []entry
+	flushed int64
+
+	// wait is closed and replaced when an entry is appended, for live queries
+	wait chan struct{}
+
+	// err is the error that stopped the writer
+	err    error
+	closed bool
+
+	done chan struct{}
+}
+
+var _ margaret.Log = (*Log)(nil)
+
+// entry is an appended value and its encoding
+type entry struct {
+	v    interface{}
+	data []byte
+}
+
+// New returns a log that writes to disk and buffers up to capacity entries until they are written.
+// A capacity of zero means DefaultCapacity.
+// Nothing else may append to disk while the returned log is in use, and it has to be closed before disk.
+func New(disk *offset2.OffsetLog, capacity int) *Log {
+	log := newLog(disk, capacity)
+	go log.write()
+	return log
+}
+
+// newLog returns a log without starting its writer.
+func newLog(disk *offset2.OffsetLog, capacity int) *Log {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+
+	seq := disk.Seq()
+	log := &Log{
+		disk:     disk,
+		codec:    disk.Codec(),
+		capacity: capacity,
+
+		seq: luigi.NewObservable(seq),
+
+		flushed: seq,
+		wait:    make(chan struct{}),
+
+		done: make(chan struct{}),
+	}
+	log.cond = sync.NewCond(&log.l)
+	return log
+}
+
+// Seq returns the sequence of the last appended entry, which might not be on disk yet.
+func (log *Log) Seq() int64 {
+	log.l.Lock()
+	defer log.l.Unlock()
+
+	return log.last()
+}
+
+// last returns the sequence of the last appended entry. Take the lock first!
+func (log *Log) last() int64 {
+	return log.flushed + int64(len(log.buf))
+}
+
+// Changes returns an observable that holds the sequence of the last appended entry.
+func (log *Log) Changes() luigi.Observable {
+	return log.seq
+}
+
+// Get returns the entry at seq, from the buffer or from disk.
+func (log *Log) Get(seq int64) (interface{}, error) {
+	log.l.Lock()
+	if seq < 0 || seq > log.last() {
+		log.l.Unlock()
+		return nil, margaret.OOB
+	}
+	if seq > log.flushed {
+		data := log.buf[seq-log.flushed-1].data
+		log.l.Unlock()
+
+		v, err := log.codec.Unmarshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("buffered: error decoding entry %d: %w", seq, err)
+		}
+		return v, nil
+	}
+	log.l.Unlock()
+
+	// entries stay on disk once they got there, so this doesn't need the lock
+	return log.disk.Get(seq)
+}
+
+// Append adds v to the buffer and returns its sequence.
+// It blocks while the buffer is full and fails once writing to disk failed.
+func (log *Log) Append(v interface{}) (int64, error) {
+	data, err := log.codec.Marshal(v)
+	if err != nil {
+		return margaret.SeqErrored, fmt.Errorf("buffered: error encoding entry: %w", err)
+	}
+
+	log.l.Lock()
+	defer log.l.Unlock()
+
+	for len(log.buf) >= log.capacity && log.err == nil && !log.closed {
+		log.cond.Wait()
+	}
+	if log.closed {
+		return margaret.SeqErrored, ErrClosed
+	}
+	if log.err != nil {
+		return margaret.SeqErrored, log.err
+	}
+
+	log.buf = append(log.buf, entry{v: v, data: data})
+	seq := log.last()
+
+	close(log.wait)
+	log.wait = make(chan struct{})
+	log.cond.Broadcast()
+	log.seq.Set(seq)
+
+	return seq, nil
+}
+
+// Flush blocks until all entries appended before the call are on disk.
+func (log *Log) Flush() error {
+	log.l.Lock()
+	defer log.l.Unlock()
+
+	if log.closed {
+		return ErrClosed
+	}
+
+	target := log.last()
+	for log.flushed < target && log.err == nil {
+		log.cond.Wait()
+	}
+	return log.err
+}
+
+// Close flushes the buffer and stops the writer. It doesn't close the offset2 log.
+// Entries can still be read afterwards, but not appended.
+func (log *Log) Close() error {
+	log.l.Lock()
+	if log.closed {
+		log.l.Unlock()
+		return ErrClosed
+	}
+	log.closed = true
+	close(log.wait) // wake live queries, they end once they read everything
+	log.cond.Broadcast()
+	log.l.Unlock()
+
+	<-log.done
+
+	log.l.Lock()
+	defer log.l.Unlock()
+	return log.err
+}
+
+// write moves the entries from the buffer to disk until the log is closed or writing fails.
+// It takes everything that piled up while it was writing at once, so it only needs the lock between these batches.
+func (log *Log) write() {
+	defer close(log.done)
+
+	log.l.Lock()
+	defer log.l.Unlock()
+
+	for {
+		for len(log.buf) == 0 && !log.closed {
+			log.cond.Wait()
+		}
+		if len(log.buf) == 0 {
+			return // closed and flushed
+		}
+
+		// appends only ever grow the buffer, so the batch stays valid without the lock
+		batch := log.buf[:len(log.buf):len(log.buf)]
+		next := log.flushed + 1
+
+		log.l.Unlock()
+		n, err := log.writeBatch(next, batch)
+		log.l.Lock()
+
+		// free the written entries for the garbage collector
+		for i := range log.buf[:n] {
+			log.buf[i] = entry{}
+		}
+		log.buf = log.buf[n:]
+		log.flushed += int64(n)
+		log.cond.Broadcast()
+
+		if err != nil {
+			log.err = err
+			return
+		}
+	}
+}
+
+// writeBatch appends the entries in batch, starting at sequence next, to disk at once and returns how many it wrote.
+func (log *Log) writeBatch(next int64, batch []entry) (int, error) {
+	var (
+		vs     = make([]interface{}, len(batch))
+		frames = make([][]byte, len(batch))
+	)
+	for i, e := range batch {
+		vs[i] = e.v
+		frames[i] = e.data
+	}
+
+	seq, err := log.disk.AppendEncoded(vs, frames)
+	if err != nil {
+		return 0, fmt.Errorf("buffered: error writing entries %d to %d: %w", next, next+int64(len(batch))-1, err)
+	}
+	if want := next + int64(len(batch)) - 1; seq != want {
+		return len(batch), fmt.Errorf("buffered: entry %d was written as %d, something else appends to the log", want, seq)
+	}
+	return len(batch), nil
+}
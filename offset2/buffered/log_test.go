@@ -0,0 +1,207 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package buffered
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ssbc/go-luigi"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/codec/json"
+	"github.com/ssbc/margaret/offset2"
+)
+
+func TestFlush(t *testing.T) {
+	r := require.New(t)
+	dir := t.TempDir()
+
+	disk, err := offset2.Open(dir, json.New(0))
+	r.NoError(err)
+
+	log := New(disk, 16)
+	for i := 0; i < 100; i++ {
+		seq, err := log.Append(i)
+		r.NoError(err)
+		r.EqualValues(i, seq)
+	}
+	r.NoError(log.Flush())
+	r.EqualValues(99, disk.Seq())
+
+	_, err = log.Append(100)
+	r.NoError(err)
+	r.NoError(log.Close())
+	r.True(errors.Is(log.Flush(), ErrClosed))
+	_, err = log.Append(101)
+	r.True(errors.Is(err, ErrClosed))
+	r.NoError(disk.Close())
+
+	// everything is on disk after closing
+	disk, err = offset2.Open(dir, json.New(0))
+	r.NoError(err)
+	defer disk.Close()
+	r.EqualValues(100, disk.Seq())
+	for i := int64(0); i <= 100; i++ {
+		v, err := disk.Get(i)
+		r.NoError(err)
+		r.EqualValues(i, v)
+	}
+}
+
+func TestReadUnflushed(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	disk, err := offset2.Open(t.TempDir(), json.New(0))
+	r.NoError(err)
+	defer disk.Close()
+	for i := 0; i < 3; i++ {
+		_, err := disk.Append(i)
+		r.NoError(err)
+	}
+	r.NoError(disk.Null(1))
+
+	// without a writer, new entries stay in the buffer
+	log := newLog(disk, 10)
+	for i := 3; i < 6; i++ {
+		seq, err := log.Append(i)
+		r.NoError(err)
+		r.EqualValues(i, seq)
+	}
+	r.EqualValues(2, disk.Seq())
+	r.EqualValues(5, log.Seq())
+
+	for i := int64(0); i < 6; i++ {
+		v, err := log.Get(i)
+		if i == 1 {
+			r.True(margaret.IsErrNulled(err), "unexpected error: %v", err)
+			continue
+		}
+		r.NoError(err)
+		r.EqualValues(i, v)
+	}
+	_, err = log.Get(6)
+	r.True(margaret.IsOutOfBounds(err), "unexpected error: %v", err)
+
+	src, err := log.Query(margaret.Gte(1), margaret.SeqWrap(true))
+	r.NoError(err)
+	for i := int64(1); i < 6; i++ {
+		v, err := src.Next(ctx)
+		r.NoError(err)
+		sw := v.(margaret.SeqWrapper)
+		r.Equal(i, sw.Seq())
+		if i == 1 {
+			r.Equal(margaret.ErrNulled, sw.Value())
+			continue
+		}
+		r.EqualValues(i, sw.Value())
+	}
+	_, err = src.Next(ctx)
+	r.True(luigi.IsEOS(err), "expected end of stream, got %v", err)
+
+	go log.write()
+	r.NoError(log.Close())
+	r.EqualValues(5, disk.Seq())
+}
+
+func TestBackpressure(t *testing.T) {
+	r := require.New(t)
+
+	disk, err := offset2.Open(t.TempDir(), json.New(0))
+	r.NoError(err)
+	defer disk.Close()
+
+	log := newLog(disk, 2)
+	for i := 0; i < 2; i++ {
+		_, err := log.Append(i)
+		r.NoError(err)
+	}
+
+	appended := make(chan error)
+	go func() {
+		_, err := log.Append(2)
+		appended <- err
+	}()
+
+	select {
+	case err := <-appended:
+		t.Fatalf("append didn't block on a full buffer: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	go log.write()
+	r.NoError(<-appended)
+	r.NoError(log.Close())
+	r.EqualValues(2, disk.Seq())
+}
+
+func TestWriteError(t *testing.T) {
+	r := require.New(t)
+
+	disk, err := offset2.Open(t.TempDir(), json.New(0))
+	r.NoError(err)
+
+	log := newLog(disk, 10)
+	_, err = log.Append(0)
+	r.NoError(err)
+
+	// appends to a closed offset2 log fail
+	r.NoError(disk.Close())
+	go log.write()
+
+	r.Error(log.Flush())
+	_, err = log.Append(1)
+	r.Error(err)
+
+	// the entry that couldn't be written can still be read
+	v, err := log.Get(0)
+	r.NoError(err)
+	r.EqualValues(0, v)
+}
+
+func TestDecodedValues(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	disk, err := offset2.Open(t.TempDir(), json.New(nil))
+	r.NoError(err)
+	defer disk.Close()
+
+	// values read from the buffer go through the codec, like those read from disk
+	log := newLog(disk, 10)
+	_, err = log.Append(1)
+	r.NoError(err)
+	_, err = log.Append(make(chan int))
+	r.Error(err, "value the codec can't encode")
+	r.EqualValues(0, log.Seq())
+
+	get := func() interface{} {
+		v, err := log.Get(0)
+		r.NoError(err)
+		return v
+	}
+	next := func() interface{} {
+		src, err := log.Query()
+		r.NoError(err)
+		v, err := src.Next(ctx)
+		r.NoError(err)
+		return v
+	}
+
+	r.Equal(float64(1), get())
+	r.Equal(float64(1), next())
+
+	go log.write()
+	r.NoError(log.Flush())
+	r.EqualValues(0, disk.Seq())
+
+	r.Equal(float64(1), get())
+	r.Equal(float64(1), next())
+	r.NoError(log.Close())
+}
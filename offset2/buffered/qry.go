@@ -0,0 +1,120 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package buffered // import "github.com/ssbc/margaret/offset2/buffered"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ssbc/go-luigi"
+
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/internal/qryspec"
+)
+
+// Query returns a source over the entries in the buffer and on disk.
+// Entries are read one by one with Get, so the source doesn't notice when they move to disk.
+func (log *Log) Query(specs ...margaret.QuerySpec) (luigi.Source, error) {
+	qry := &query{
+		log:  log,
+		Spec: qryspec.New(),
+	}
+
+	if err := qry.Apply(specs...); err != nil {
+		return nil, err
+	}
+
+	if qry.IsReverse() && qry.IsLive() {
+		return nil, errors.New("buffered: can't do reverse and live")
+	}
+
+	return qry, nil
+}
+
+type query struct {
+	log *Log
+	qryspec.Spec
+
+	// nextSeq is the sequence of the entry returned by the next call to Next.
+	// The first call picks it from the bounds.
+	nextSeq int64
+	started bool
+}
+
+// await blocks until the log has an entry at seq.
+// It returns false if the log doesn't get one, because the query isn't live or the log was closed.
+func (qry *query) await(ctx context.Context, seq int64) (bool, error) {
+	log := qry.log
+	log.l.Lock()
+	defer log.l.Unlock()
+
+	for seq > log.last() {
+		if !qry.IsLive() || log.closed {
+			return false, nil
+		}
+
+		wait := log.wait
+		log.l.Unlock()
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			log.l.Lock()
+			return false, ctx.Err()
+		}
+		log.l.Lock()
+	}
+	return true, nil
+}
+
+func (qry *query) Next(ctx context.Context) (interface{}, error) {
+	if qry.Exhausted() {
+		return nil, luigi.EOS{}
+	}
+
+	if !qry.started {
+		qry.nextSeq = qry.Lower()
+		if qry.IsReverse() {
+			qry.nextSeq = qry.log.Seq()
+			if upper := qry.Upper(); upper != margaret.SeqEmpty && upper < qry.nextSeq {
+				qry.nextSeq = upper
+			}
+		}
+		qry.started = true
+	}
+
+	seq := qry.nextSeq
+	if qry.IsReverse() {
+		if seq < qry.Lower() || seq < 0 {
+			return nil, luigi.EOS{}
+		}
+	} else {
+		if upper := qry.Upper(); upper != margaret.SeqEmpty && seq > upper {
+			return nil, luigi.EOS{}
+		}
+
+		ok, err := qry.await(ctx, seq)
+		if err != nil {
+			return nil, fmt.Errorf("error waiting for next value: %w", err)
+		} else if !ok {
+			return nil, luigi.EOS{}
+		}
+	}
+
+	v, err := qry.log.Get(seq)
+	if margaret.IsErrNulled(err) {
+		// like the other logs, return nulled entries as values so consumers can skip them
+		v = margaret.ErrNulled
+	} else if err != nil {
+		return nil, fmt.Errorf("buffered: error reading entry %d: %w", seq, err)
+	}
+
+	if qry.IsReverse() {
+		qry.nextSeq--
+	} else {
+		qry.nextSeq++
+	}
+	return qry.Wrap(v, seq), nil
+}
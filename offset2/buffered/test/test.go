@@ -0,0 +1,23 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package test
+
+import (
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/codec/json"
+	"github.com/ssbc/margaret/offset2"
+	"github.com/ssbc/margaret/offset2/buffered"
+	mtest "github.com/ssbc/margaret/test"
+)
+
+func init() {
+	mtest.Register("offset2/buffered/json", func(name string, tipe interface{}) (margaret.Log, error) {
+		disk, err := offset2.Open(name, json.New(tipe))
+		if err != nil {
+			return nil, err
+		}
+		return buffered.New(disk, 0), nil
+	})
+}
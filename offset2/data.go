@@ -94,3 +94,33 @@ func (d *data) append(data []byte) (int64, error) {
 	}
 	return ofst, nil
 }
+
+// appendFrames writes all of frames to the end of the file at once and returns their offsets.
+func (d *data) appendFrames(frames [][]byte) ([]int64, error) {
+	ofst, err := d.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to seek to end of file: %w", err)
+	}
+
+	var sz int
+	for _, f := range frames {
+		sz += 8 + len(f)
+	}
+
+	var (
+		buf   = make([]byte, sz)
+		ofsts = make([]int64, len(frames))
+		pos   int
+	)
+	for i, f := range frames {
+		ofsts[i] = ofst + int64(pos)
+		binary.BigEndian.PutUint64(buf[pos:], uint64(len(f)))
+		pos += 8 + copy(buf[pos+8:], f)
+	}
+
+	_, err = d.Write(buf)
+	if err != nil {
+		return nil, fmt.Errorf("error writing data: %w", err)
+	}
+	return ofsts, nil
+}
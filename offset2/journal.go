@@ -46,6 +46,11 @@ func (j *journal) readSeq() (int64, error) {
 }
 
 func (j *journal) bump() (int64, error) {
+	return j.advance(1)
+}
+
+// advance adds n to the sequence in the journal and returns the new one.
+func (j *journal) advance(n int64) (int64, error) {
 	seq, err := j.readSeq()
 	if err != nil {
 		return margaret.SeqEmpty, fmt.Errorf("error reading old journal value: %w", err)
@@ -56,7 +61,7 @@ func (j *journal) bump() (int64, error) {
 		return margaret.SeqEmpty, fmt.Errorf("could not seek to start of file: %w", err)
 	}
 
-	seq = seq + 1
+	seq = seq + n
 	err = binary.Write(j, binary.BigEndian, seq)
 	if err != nil {
 		return margaret.SeqEmpty, fmt.Errorf("error writing seq: %w", err)
@@ -68,3 +68,23 @@ func (o *offset) append(ofst int64) (int64, error) {
 	}
 	return seq, nil
 }
+
+// appendMany writes all of ofsts to the end of the file at once and returns the sequence of the first one.
+func (o *offset) appendMany(ofsts []int64) (int64, error) {
+	ofstOfst, err := o.Seek(0, io.SeekEnd)
+	if err != nil {
+		return margaret.SeqEmpty, fmt.Errorf("could not seek to end of file:%w", err)
+	}
+	seq := int64(ofstOfst / 8)
+
+	buf := make([]byte, 8*len(ofsts))
+	for i, ofst := range ofsts {
+		binary.BigEndian.PutUint64(buf[8*i:], uint64(ofst))
+	}
+
+	_, err = o.Write(buf)
+	if err != nil {
+		return margaret.SeqEmpty, fmt.Errorf("error writing offsets:%w", err)
+	}
+	return seq, nil
+}
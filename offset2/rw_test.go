@@ -189,3 +189,66 @@ func TestRecover(t *testing.T) {
 	r.NoError(err, "error while recover")
 	r.EqualValues(v, len(tevs)-1)
 }
+
+func TestAppendEncoded(t *testing.T) {
+	//setup
+	r := require.New(t)
+	name, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(name)
+
+	log, err := Open(name, mjson.New(&testEvent{}))
+	r.NoError(err, "error during log creation")
+
+	seq, err := log.Append(testEvent{"hello", 23})
+	r.NoError(err)
+	r.EqualValues(0, seq)
+
+	// an empty batch doesn't change anything
+	seq, err = log.AppendEncoded(nil, nil)
+	r.NoError(err)
+	r.EqualValues(0, seq)
+
+	_, err = log.AppendEncoded([]interface{}{testEvent{}}, nil)
+	r.Error(err, "values and frames don't match")
+
+	tevs := []testEvent{
+		testEvent{"world", 42},
+		testEvent{"world", 161},
+		testEvent{"world", 1312},
+	}
+	var (
+		vs     []interface{}
+		frames [][]byte
+	)
+	for _, ev := range tevs {
+		frame, err := log.Codec().Marshal(ev)
+		r.NoError(err)
+		vs = append(vs, ev)
+		frames = append(frames, frame)
+	}
+
+	seq, err = log.AppendEncoded(vs, frames)
+	r.NoError(err)
+	r.EqualValues(3, seq)
+	r.EqualValues(3, log.Seq())
+
+	seq, err = log.Append(testEvent{"bye", 1})
+	r.NoError(err)
+	r.EqualValues(4, seq)
+
+	r.NoError(log.Close())
+
+	// the batch survives reopening and passes the consistency check
+	log, err = Open(name, mjson.New(&testEvent{}))
+	r.NoError(err, "error during log open")
+	r.NoError(log.CheckConsistency())
+	r.EqualValues(4, log.Seq())
+
+	for i, ev := range tevs {
+		v, err := log.Get(int64(i + 1))
+		r.NoError(err, "failed to get event %d", i+1)
+		r.Equal(ev, *v.(*testEvent))
+	}
+	r.NoError(log.Close())
+}
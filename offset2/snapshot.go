@@ -0,0 +1,63 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package offset2
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/mem"
+)
+
+// Snapshot writes all entries of log, usually an in-memory one, to a new offset2 log in dir, encoded with cdc.
+// The entries keep their sequence numbers, so dir must not hold a log with entries yet.
+// Bounded in-memory logs can only be written as long as they didn't evict any entries.
+func Snapshot(log margaret.Log, dir string, cdc margaret.Codec) error {
+	if _, err := log.Get(0); errors.Is(err, mem.ErrEvicted) {
+		return fmt.Errorf("offset2: can't snapshot log without its first entries: %w", err)
+	}
+
+	dst, err := Open(dir, cdc)
+	if err != nil {
+		return fmt.Errorf("offset2: error opening snapshot log: %w", err)
+	}
+
+	if seq := dst.Seq(); seq != margaret.SeqEmpty {
+		dst.Close()
+		return fmt.Errorf("offset2: snapshot log in %s already holds entries up to %d", dir, seq)
+	}
+
+	if _, err := margaret.Copy(dst, log); err != nil {
+		dst.Close()
+		return fmt.Errorf("offset2: error writing snapshot: %w", err)
+	}
+
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("offset2: error closing snapshot log: %w", err)
+	}
+	return nil
+}
+
+// Restore reads the offset2 log in dir, encoded with cdc, into a new in-memory log.
+func Restore(dir string, cdc margaret.Codec) (margaret.Log, error) {
+	// Open would create a new log if there is none
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("offset2: can't restore snapshot: %w", err)
+	}
+
+	src, err := Open(dir, cdc)
+	if err != nil {
+		return nil, fmt.Errorf("offset2: error opening snapshot log: %w", err)
+	}
+	defer src.Close()
+
+	log := mem.New()
+	if _, err := margaret.Copy(log, src); err != nil {
+		return nil, fmt.Errorf("offset2: error reading snapshot: %w", err)
+	}
+	return log, nil
+}
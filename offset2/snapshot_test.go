@@ -0,0 +1,114 @@
+// SPDX-FileCopyrightText: 2021 The margaret Authors
+//
+// SPDX-License-Identifier: MIT
+
+package offset2_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/codec/json"
+	"github.com/ssbc/margaret/mem"
+	"github.com/ssbc/margaret/offset2"
+)
+
+type entry struct {
+	N    int
+	Text string
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	r := require.New(t)
+	dir := filepath.Join(t.TempDir(), "snapshot")
+	codec := json.New(entry{})
+
+	log := mem.New()
+	for i := 0; i < 10; i++ {
+		_, err := log.Append(entry{N: i, Text: "hello"})
+		r.NoError(err)
+	}
+	r.NoError(log.(margaret.Alterer).Null(3))
+
+	r.NoError(offset2.Snapshot(log, dir, codec))
+	r.Error(offset2.Snapshot(log, dir, codec), "snapshot into a log with entries")
+
+	restored, err := offset2.Restore(dir, codec)
+	r.NoError(err)
+	r.EqualValues(9, restored.Seq())
+
+	for i := int64(0); i < 10; i++ {
+		v, err := restored.Get(i)
+		if i == 3 {
+			r.True(margaret.IsErrNulled(err), "unexpected error: %v", err)
+			continue
+		}
+		r.NoError(err)
+		r.Equal(entry{N: int(i), Text: "hello"}, v)
+	}
+
+	_, err = offset2.Restore(filepath.Join(t.TempDir(), "missing"), codec)
+	r.Error(err)
+}
+
+func TestSnapshotEvicted(t *testing.T) {
+	r := require.New(t)
+
+	log := mem.NewBounded(2)
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(entry{N: i})
+		r.NoError(err)
+	}
+
+	err := offset2.Snapshot(log, t.TempDir(), json.New(entry{}))
+	r.True(errors.Is(err, mem.ErrEvicted), "unexpected error: %v", err)
+}
+
+func TestCopy(t *testing.T) {
+	r := require.New(t)
+
+	src, err := offset2.Open(t.TempDir(), json.New(entry{}))
+	r.NoError(err)
+	defer src.Close()
+	for i := 0; i < 10; i++ {
+		_, err := src.Append(entry{N: i})
+		r.NoError(err)
+	}
+	r.NoError(src.Null(0))
+
+	var calls []int64
+	dst := mem.New()
+	n, err := margaret.CopyWithProgress(context.Background(), dst, src, func(copied, seq int64) {
+		r.EqualValues(len(calls)+1, copied)
+		calls = append(calls, seq)
+	}, margaret.Lt(5), margaret.SeqWrap(true))
+	r.NoError(err)
+	r.EqualValues(5, n)
+	r.Equal([]int64{0, 1, 2, 3, 4}, calls)
+
+	_, err = dst.Get(0)
+	r.True(margaret.IsErrNulled(err), "unexpected error: %v", err)
+	v, err := dst.Get(4)
+	r.NoError(err)
+	r.Equal(entry{N: 4}, v, "values are copied without their sequence numbers")
+
+	// the rest of the log
+	n, err = margaret.Copy(dst, src, margaret.Gt(dst.Seq()))
+	r.NoError(err)
+	r.EqualValues(5, n)
+	r.EqualValues(src.Seq(), dst.Seq())
+
+	// logs that can't null entries
+	_, err = margaret.Copy(noAlterer{mem.New()}, src)
+	r.True(margaret.IsErrNulled(err), "unexpected error: %v", err)
+}
+
+// noAlterer hides the Alterer methods of a log.
+type noAlterer struct {
+	margaret.Log
+}
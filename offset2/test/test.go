@@ -7,6 +7,8 @@ package test
 import (
 	"github.com/ssbc/margaret"
 	"github.com/ssbc/margaret/codec/cbor"
+	"github.com/ssbc/margaret/codec/compress"
+	"github.com/ssbc/margaret/codec/encrypt"
 	"github.com/ssbc/margaret/codec/json"
 	"github.com/ssbc/margaret/codec/msgpack"
 	"github.com/ssbc/margaret/offset2"
@@ -22,6 +24,11 @@ func init() {
 		"json":    json.New,
 		"msgpack": msgpack.New,
 		"cbor":    cbor.New,
+
+		"json+zstd":   mtest.CompressedCodec(json.New, compress.Zstd, nil),
+		"json+snappy": mtest.CompressedCodec(json.New, compress.Snappy, nil),
+
+		"json+xchacha20poly1305": mtest.EncryptedCodec(json.New, encrypt.XChaCha20Poly1305),
 	}
 
 	buildNewLogFunc := func(newCodec mtest.NewCodecFunc) mtest.NewLogFunc {
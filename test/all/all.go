@@ -8,5 +8,6 @@ import (
 	// import to register testing helpers
 	_ "github.com/ssbc/margaret/mem/test"
 
+	_ "github.com/ssbc/margaret/offset2/buffered/test"
 	_ "github.com/ssbc/margaret/offset2/test"
 )
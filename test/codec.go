@@ -5,8 +5,259 @@
 package test
 
 import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
 	"github.com/ssbc/margaret"
+	"github.com/ssbc/margaret/codec/compress"
+	"github.com/ssbc/margaret/codec/encrypt"
 )
 
 // NewCodecFunc is a function that returns a codec
 type NewCodecFunc func(tipe interface{}) margaret.Codec
+
+// CodecSetup is a registered codec, together with the type it decodes into and values of that type.
+type CodecSetup struct {
+	New    NewCodecFunc
+	Tipe   interface{}
+	Values []interface{}
+}
+
+var NewCodecFuncs map[string]CodecSetup
+
+// GenericCodecFuncs holds codecs that can encode any Go value, like json, cbor and msgpack.
+var GenericCodecFuncs map[string]NewCodecFunc
+
+func init() {
+	NewCodecFuncs = map[string]CodecSetup{}
+	GenericCodecFuncs = map[string]NewCodecFunc{}
+}
+
+// CompressedCodec returns a NewCodecFunc that wraps the codecs of newCodec in a compressor for alg, using dict if it isn't nil.
+func CompressedCodec(newCodec NewCodecFunc, alg compress.Algorithm, dict []byte) NewCodecFunc {
+	return func(tipe interface{}) margaret.Codec {
+		c, err := compress.New(newCodec(tipe), alg, dict)
+		if err != nil {
+			panic(err)
+		}
+		return c
+	}
+}
+
+// EncryptedCodec returns a NewCodecFunc that wraps the codecs of newCodec in cipher, with a fixed test key.
+func EncryptedCodec(newCodec NewCodecFunc, cipher encrypt.Cipher) NewCodecFunc {
+	keys, err := encrypt.NewKeys(1, bytes.Repeat([]byte{0x42}, encrypt.KeySize))
+	if err != nil {
+		panic(err)
+	}
+	return func(tipe interface{}) margaret.Codec {
+		c, err := encrypt.New(newCodec(tipe), cipher, keys)
+		if err != nil {
+			panic(err)
+		}
+		return c
+	}
+}
+
+// RegisterCodec registers a codec for the codec tests, which checks that the values round-trip when decoded as tipe.
+func RegisterCodec(name string, f NewCodecFunc, tipe interface{}, values ...interface{}) {
+	NewCodecFuncs[name] = CodecSetup{New: f, Tipe: tipe, Values: values}
+}
+
+// RegisterGenericCodec registers a codec for the conformance tests, which check a table of values of different types.
+func RegisterGenericCodec(name string, f NewCodecFunc) {
+	GenericCodecFuncs[name] = f
+}
+
+func RunCodecTests(t *testing.T) {
+	for name, setup := range NewCodecFuncs {
+		t.Run(name, CodecTest(setup))
+	}
+	for name, f := range GenericCodecFuncs {
+		t.Run(name+"/Conformance", CodecTestConformance(f))
+	}
+}
+
+func CodecTest(setup CodecSetup) func(*testing.T) {
+	return func(t *testing.T) {
+		t.Run("Marshal", CodecTestMarshal(setup))
+		t.Run("Stream", CodecTestStream(setup))
+		t.Run("Frame", CodecTestFrame(setup))
+	}
+}
+
+// requireSameValue checks that v and got have the same type and are deeply equal or encode to the same bytes.
+// Comparing the encoded values works for types that can't be compared with reflect.DeepEqual, like protobuf messages.
+func requireSameValue(t *testing.T, c margaret.Codec, v, got interface{}) {
+	r := require.New(t)
+	r.Equal(reflect.TypeOf(v), reflect.TypeOf(got), "decoded value has the wrong type")
+	if reflect.DeepEqual(v, got) {
+		return
+	}
+
+	want, err := c.Marshal(v)
+	r.NoError(err)
+	data, err := c.Marshal(got)
+	r.NoError(err)
+	r.Equal(want, data, "decoded value differs")
+}
+
+// CodecTestMarshal checks that Unmarshal returns the values passed to Marshal.
+func CodecTestMarshal(setup CodecSetup) func(*testing.T) {
+	return func(t *testing.T) {
+		r := require.New(t)
+		c := setup.New(setup.Tipe)
+
+		for i, v := range setup.Values {
+			data, err := c.Marshal(v)
+			r.NoError(err, "marshal #%d", i)
+
+			got, err := c.Unmarshal(data)
+			r.NoError(err, "unmarshal #%d", i)
+			requireSameValue(t, c, v, got)
+		}
+	}
+}
+
+// CodecTestStream checks that a decoder returns the values written by an encoder, in order, followed by io.EOF.
+func CodecTestStream(setup CodecSetup) func(*testing.T) {
+	return func(t *testing.T) {
+		r := require.New(t)
+		c := setup.New(setup.Tipe)
+
+		var buf bytes.Buffer
+		enc := c.NewEncoder(&buf)
+		for i, v := range setup.Values {
+			r.NoError(enc.Encode(v), "encode #%d", i)
+		}
+
+		dec := c.NewDecoder(&buf)
+		for i, v := range setup.Values {
+			got, err := dec.Decode()
+			r.NoError(err, "decode #%d", i)
+			requireSameValue(t, c, v, got)
+		}
+
+		_, err := dec.Decode()
+		r.True(errors.Is(err, io.EOF), "expected io.EOF after the last value, got %v", err)
+	}
+}
+
+// CodecTestFrame checks that the output of Marshal can be read with a decoder, which is how offset2 reads its frames.
+func CodecTestFrame(setup CodecSetup) func(*testing.T) {
+	return func(t *testing.T) {
+		r := require.New(t)
+		c := setup.New(setup.Tipe)
+
+		for i, v := range setup.Values {
+			data, err := c.Marshal(v)
+			r.NoError(err, "marshal #%d", i)
+
+			got, err := c.NewDecoder(bytes.NewReader(data)).Decode()
+			r.NoError(err, "decode #%d", i)
+			requireSameValue(t, c, v, got)
+		}
+	}
+}
+
+type conformanceStruct struct {
+	Name  string
+	Count int
+	Tags  []string
+	Blob  []byte
+}
+
+type conformanceCase struct {
+	name  string
+	tipe  interface{}
+	value interface{}
+
+	// the decoded value, if it differs from value
+	want interface{}
+}
+
+func conformanceCases() []conformanceCase {
+	s := conformanceStruct{
+		Name:  "test",
+		Count: 3,
+		Tags:  []string{"a", "b"},
+		Blob:  []byte{0, 1, 0xff},
+	}
+
+	return []conformanceCase{
+		{name: "struct", tipe: conformanceStruct{}, value: s},
+		{name: "struct pointer", tipe: &conformanceStruct{}, value: &s},
+		{name: "nil pointer", tipe: &conformanceStruct{}, value: (*conformanceStruct)(nil)},
+		{name: "empty struct", tipe: conformanceStruct{}, value: conformanceStruct{}},
+		{name: "int", tipe: 0, value: 42},
+		{name: "negative int", tipe: int64(0), value: int64(-1 << 40)},
+		{name: "string", tipe: "", value: "hello"},
+		{name: "empty string", tipe: "", value: ""},
+		{name: "bool", tipe: false, value: true},
+		{name: "map", tipe: map[string]int{}, value: map[string]int{"a": 1, "b": 2}},
+		{name: "map of structs", tipe: map[string]conformanceStruct{}, value: map[string]conformanceStruct{"s": s}},
+		{name: "slice", tipe: []string{}, value: []string{"x", "y", "z"}},
+		{name: "blob", tipe: []byte{}, value: []byte{0, 1, 2, 0xfe, 0xff}},
+		{name: "big blob", tipe: []byte{}, value: bytes.Repeat([]byte{0xaa, 0x55}, 1<<15)},
+
+		// without a type, values are decoded like json.Unmarshal into an interface{} does, as far as the format allows
+		{name: "untyped string", tipe: nil, value: "hello"},
+		{name: "untyped bool", tipe: nil, value: true},
+		{name: "untyped nil", tipe: nil, value: nil},
+		{name: "untyped list", tipe: nil, value: []interface{}{"a", false, nil}},
+		{
+			name:  "untyped map",
+			tipe:  nil,
+			value: map[string]interface{}{"a": "b", "list": []interface{}{"c"}, "nested": map[string]interface{}{"d": true}},
+		},
+		{
+			name:  "untyped map with string keys",
+			tipe:  nil,
+			value: map[string]string{"a": "b"},
+			want:  map[string]interface{}{"a": "b"},
+		},
+	}
+}
+
+// CodecTestConformance checks that a generic codec round-trips a table of values, with Marshal and with an encoder.
+func CodecTestConformance(f NewCodecFunc) func(*testing.T) {
+	return func(t *testing.T) {
+		for _, tc := range conformanceCases() {
+			tc := tc
+			t.Run(tc.name, func(t *testing.T) {
+				r := require.New(t)
+				c := f(tc.tipe)
+
+				want := tc.want
+				if want == nil {
+					want = tc.value
+				}
+
+				data, err := c.Marshal(tc.value)
+				r.NoError(err, "marshal")
+				got, err := c.Unmarshal(data)
+				r.NoError(err, "unmarshal")
+				r.Equal(want, got, "value from Unmarshal differs")
+
+				var buf bytes.Buffer
+				enc := c.NewEncoder(&buf)
+				r.NoError(enc.Encode(tc.value), "encode")
+				r.NoError(enc.Encode(tc.value), "encode")
+
+				dec := c.NewDecoder(&buf)
+				for i := 0; i < 2; i++ {
+					got, err := dec.Decode()
+					r.NoError(err, "decode #%d", i)
+					r.Equal(want, got, "value #%d from decoder differs", i)
+				}
+				_, err = dec.Decode()
+				r.True(errors.Is(err, io.EOF), "expected io.EOF after the last value, got %v", err)
+			})
+		}
+	}
+}